@@ -0,0 +1,253 @@
+// Package catalog indexes scraped movies and stills in a SQLite
+// database, so downloads can be queried without walking the data dir.
+package catalog
+
+import (
+	"database/sql"
+	"fmt"
+	"moviestills/scraper"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Pure-Go SQLite driver, no need for cgo
+	_ "modernc.org/sqlite"
+)
+
+// Tables of the catalog, created when missing
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY,
+	command     TEXT NOT NULL,
+	websites    TEXT NOT NULL DEFAULT '',
+	started_at  TEXT NOT NULL,
+	finished_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS sites (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS movies (
+	id      INTEGER PRIMARY KEY,
+	site_id INTEGER NOT NULL REFERENCES sites(id),
+	title   TEXT NOT NULL,
+	year    TEXT NOT NULL DEFAULT '',
+	url     TEXT NOT NULL DEFAULT '',
+	path    TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS images (
+	id            INTEGER PRIMARY KEY,
+	movie_id      INTEGER NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+	run_id        INTEGER REFERENCES runs(id),
+	filename      TEXT NOT NULL,
+	path          TEXT NOT NULL UNIQUE,
+	url           TEXT NOT NULL DEFAULT '',
+	size          INTEGER NOT NULL,
+	width         INTEGER,
+	height        INTEGER,
+	sha256        TEXT NOT NULL,
+	last_modified TEXT NOT NULL DEFAULT '',
+	etag          TEXT NOT NULL DEFAULT '',
+	fetched_at    TEXT
+);
+
+CREATE INDEX IF NOT EXISTS movies_site ON movies(site_id);
+CREATE INDEX IF NOT EXISTS movies_title ON movies(title);
+CREATE INDEX IF NOT EXISTS images_movie ON images(movie_id);
+CREATE INDEX IF NOT EXISTS images_sha256 ON images(sha256);
+`
+
+// Catalog is a SQLite database of scraped movies and stills
+type Catalog struct {
+	db *sql.DB
+}
+
+// Open opens the catalog at the given path, creating it if needed
+func Open(path string) (*Catalog, error) {
+	dsn := "file:" + filepath.ToSlash(path) +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scrapers running concurrently share a single connection,
+	// so writes are serialized instead of failing with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't create catalog schema: %w", err)
+	}
+
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the database
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// DB gives access to the database to query it
+func (c *Catalog) DB() *sql.DB {
+	return c.db
+}
+
+// Reset removes every site, movie and image from the catalog.
+// The history of runs is kept.
+func (c *Catalog) Reset() error {
+	_, err := c.db.Exec(`DELETE FROM images; DELETE FROM movies; DELETE FROM sites;`)
+	return err
+}
+
+// Run is a single execution of the app writing to the catalog.
+// Images record the run that saved them last.
+type Run struct {
+	ID      int64
+	catalog *Catalog
+}
+
+// StartRun records the start of a command, eg. "scrape"
+func (c *Catalog) StartRun(command string, websites []string) (*Run, error) {
+	result, err := c.db.Exec(
+		`INSERT INTO runs (command, websites, started_at) VALUES (?, ?, ?)`,
+		command, strings.Join(websites, ","), timestamp(time.Now()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Run{ID: id, catalog: c}, nil
+}
+
+// Finish records the end of the run
+func (r *Run) Finish() error {
+	_, err := r.catalog.db.Exec(`UPDATE runs SET finished_at = ? WHERE id = ?`, timestamp(time.Now()), r.ID)
+	return err
+}
+
+// RecordImage adds or updates an image saved for a movie,
+// along with the movie and its website.
+func (r *Run) RecordImage(movie scraper.Movie, image scraper.ManifestImage) error {
+	tx, err := r.catalog.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var siteID int64
+	err = tx.QueryRow(
+		`INSERT INTO sites (name) VALUES (?)
+		ON CONFLICT (name) DO UPDATE SET name = excluded.name
+		RETURNING id`,
+		movie.Site,
+	).Scan(&siteID)
+	if err != nil {
+		return err
+	}
+
+	// The URL of a movie is unknown when indexing old downloads,
+	// don't forget it if we already have it.
+	var movieID int64
+	err = tx.QueryRow(
+		`INSERT INTO movies (site_id, title, year, url, path) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			site_id = excluded.site_id,
+			title = excluded.title,
+			year = excluded.year,
+			url = CASE WHEN excluded.url != '' THEN excluded.url ELSE movies.url END
+		RETURNING id`,
+		siteID, movie.Name, movie.Year, movie.URL, movie.Path,
+	).Scan(&movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO images (movie_id, run_id, filename, path, url, size, width, height,
+			sha256, last_modified, etag, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			movie_id = excluded.movie_id,
+			run_id = excluded.run_id,
+			filename = excluded.filename,
+			url = CASE WHEN excluded.url != '' THEN excluded.url ELSE images.url END,
+			size = excluded.size,
+			width = excluded.width,
+			height = excluded.height,
+			sha256 = excluded.sha256,
+			last_modified = excluded.last_modified,
+			etag = excluded.etag,
+			fetched_at = COALESCE(excluded.fetched_at, images.fetched_at)`,
+		movieID, r.ID, image.FileName, filepath.Join(movie.Path, image.FileName), image.URL, image.Size,
+		nullInt(image.Width), nullInt(image.Height), image.SHA256, image.LastModified, image.ETag,
+		nullTime(image.FetchedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveImage forgets an image moved out of a movie folder
+func (r *Run) RemoveImage(movie scraper.Movie, fileName string) error {
+	_, err := r.catalog.db.Exec(`DELETE FROM images WHERE path = ?`, filepath.Join(movie.Path, fileName))
+	return err
+}
+
+// timestamp formats dates the same way everywhere in the database
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+func nullTime(t time.Time) sql.NullString {
+	return sql.NullString{String: timestamp(t), Valid: !t.IsZero()}
+}
+
+// SiteCount is the number of movies and images of a website
+type SiteCount struct {
+	Site   string
+	Movies int64
+	Images int64
+}
+
+// Counts returns the number of movies and images of every website
+func (c *Catalog) Counts() ([]SiteCount, error) {
+	rows, err := c.db.Query(
+		`SELECT sites.name, COUNT(DISTINCT movies.id), COUNT(images.id)
+		FROM sites
+		JOIN movies ON movies.site_id = sites.id
+		LEFT JOIN images ON images.movie_id = movies.id
+		GROUP BY sites.id
+		ORDER BY sites.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []SiteCount{}
+	for rows.Next() {
+		var count SiteCount
+		if err := rows.Scan(&count.Site, &count.Movies, &count.Images); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,150 @@
+package catalog
+
+import (
+	"moviestills/scraper"
+	"moviestills/scraper/scrapertest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+
+	cat, err := Open(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = cat.Close() })
+
+	return cat
+}
+
+func TestRecordImage(t *testing.T) {
+	cat := openTestCatalog(t)
+
+	run, err := cat.StartRun("scrape", []string{"example"})
+	if err != nil {
+		t.Fatalf("StartRun() unexpected error: %v", err)
+	}
+
+	movie := scraper.Movie{Name: "Alien", Year: "1979", URL: "https://example.com/alien", Path: "data/example/Alien", Site: "example"}
+	image := scraper.ManifestImage{
+		URL:       "https://example.com/alien/1.jpg",
+		FileName:  "1.jpg",
+		Size:      1234,
+		Width:     1920,
+		Height:    1080,
+		SHA256:    "abc",
+		FetchedAt: time.Now(),
+	}
+
+	// Recording the same image twice updates it
+	for _, size := range []int64{1000, 1234} {
+		image.Size = size
+		if err := run.RecordImage(movie, image); err != nil {
+			t.Fatalf("RecordImage() unexpected error: %v", err)
+		}
+	}
+	if err := run.RecordImage(movie, scraper.ManifestImage{FileName: "2.jpg", SHA256: "def"}); err != nil {
+		t.Fatalf("RecordImage() unexpected error: %v", err)
+	}
+
+	if err := run.Finish(); err != nil {
+		t.Fatalf("Finish() unexpected error: %v", err)
+	}
+
+	counts, err := cat.Counts()
+	if err != nil {
+		t.Fatalf("Counts() unexpected error: %v", err)
+	}
+	if expected := []SiteCount{{Site: "example", Movies: 1, Images: 2}}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Counts() = %v, expected %v", counts, expected)
+	}
+
+	var runID, size, width int64
+	var url string
+	err = cat.DB().QueryRow(`SELECT run_id, size, width, url FROM images WHERE path = ?`,
+		filepath.Join(movie.Path, "1.jpg")).Scan(&runID, &size, &width, &url)
+	if err != nil {
+		t.Fatalf("Can't find recorded image: %v", err)
+	}
+	if runID != run.ID || size != 1234 || width != 1920 || url != image.URL {
+		t.Errorf("Recorded image is wrong: run %d, size %d, width %d, url %s", runID, size, width, url)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dataDir := t.TempDir()
+	jpeg, _ := scrapertest.FakeImage("/still.jpg")
+
+	// An old download, without manifest
+	oldPath := filepath.Join(dataDir, "blubeaver", "12 Angry Men")
+	writeFile(t, filepath.Join(oldPath, "still_1.jpg"), jpeg)
+	writeFile(t, filepath.Join(oldPath, "still_2"), jpeg)
+
+	// A recent download, with its manifest
+	movie := scraper.Movie{Name: "Alien", Year: "1979", URL: "https://example.com/alien", Path: filepath.Join(dataDir, "film-grab", "Alien"), Site: "film-grab"}
+	writeFile(t, filepath.Join(movie.Path, "alien.jpg"), jpeg)
+	if err := scraper.RecordImage(movie, scraper.ManifestImage{FileName: "alien.jpg", URL: "https://example.com/alien.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files outside of movie folders
+	writeFile(t, filepath.Join(dataDir, "notes.txt"), []byte("hello"))
+	writeFile(t, filepath.Join(oldPath, ".DS_Store"), []byte("hello"))
+
+	cat := openTestCatalog(t)
+	run, err := cat.StartRun("rebuild", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, images, err := run.Rebuild(dataDir)
+	if err != nil {
+		t.Fatalf("Rebuild() unexpected error: %v", err)
+	}
+	if movies != 2 || images != 3 {
+		t.Errorf("Rebuild() indexed %d movies and %d images, expected 2 and 3", movies, images)
+	}
+
+	var title, year, movieURL, imageURL, sha string
+	var width, height int
+	err = cat.DB().QueryRow(
+		`SELECT movies.title, movies.year, movies.url, images.url, images.sha256, images.width, images.height
+		FROM images JOIN movies ON movies.id = images.movie_id WHERE images.filename = 'alien.jpg'`,
+	).Scan(&title, &year, &movieURL, &imageURL, &sha, &width, &height)
+	if err != nil {
+		t.Fatalf("Can't find indexed image: %v", err)
+	}
+	if title != "Alien" || year != "1979" || movieURL != movie.URL || imageURL != "https://example.com/alien.jpg" {
+		t.Errorf("Movie described by its manifest is wrong: %s, %s, %s, %s", title, year, movieURL, imageURL)
+	}
+	if sha != scraper.SHA256(jpeg) || width != 1280 || height != 720 {
+		t.Errorf("Image is not described by its file: %s, %dx%d", sha, width, height)
+	}
+
+	var site string
+	err = cat.DB().QueryRow(
+		`SELECT sites.name, movies.title FROM movies JOIN sites ON sites.id = movies.site_id WHERE movies.path = ?`,
+		oldPath,
+	).Scan(&site, &title)
+	if err != nil {
+		t.Fatalf("Can't find old download: %v", err)
+	}
+	if site != "blubeaver" || title != "12 Angry Men" {
+		t.Errorf("Old download is described by its path as %s/%s", site, title)
+	}
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
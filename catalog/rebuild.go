@@ -0,0 +1,132 @@
+package catalog
+
+import (
+	"moviestills/scraper"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rebuild indexes every movie folder found in a data dir, including
+// downloads made before movie manifests existed. Movies are described
+// by their manifest when they have one, otherwise by their path, laid
+// out as <data dir>/<website>/<movie>. Returns the number of movies
+// and images indexed. Files that are not in a movie folder are ignored.
+func (r *Run) Rebuild(dataDir string) (int, int, error) {
+	folders := make(map[string][]os.DirEntry)
+
+	err := filepath.WalkDir(dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Near-duplicates moved aside are not part of movies
+		if d.IsDir() && d.Name() == scraper.DuplicatesFolder {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !isImage(d.Name()) {
+			return nil
+		}
+		folder := filepath.Dir(path)
+		folders[folder] = append(folders[folder], d)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Index movies in a predictable order
+	paths := make([]string, 0, len(folders))
+	for path := range folders {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	movies, images := 0, 0
+	for _, path := range paths {
+		movie, manifest, err := movieFromFolder(dataDir, path)
+		if err != nil {
+			return 0, 0, err
+		}
+		if movie.Site == "" {
+			continue
+		}
+		movies++
+
+		for _, entry := range folders[path] {
+			image, err := imageFromFile(path, entry.Name(), manifest)
+			if err != nil {
+				return 0, 0, err
+			}
+			// Movies of several websites can share a folder
+			imageMovie := movie
+			if image.Site != "" {
+				imageMovie.Site = image.Site
+			}
+			if err := r.RecordImage(imageMovie, image); err != nil {
+				return 0, 0, err
+			}
+			images++
+		}
+	}
+
+	return movies, images, nil
+}
+
+// movieFromFolder describes the movie saved in a folder
+func movieFromFolder(dataDir, path string) (scraper.Movie, *scraper.Manifest, error) {
+	manifest, err := scraper.ReadManifest(path)
+	if err != nil {
+		return scraper.Movie{}, nil, err
+	}
+
+	movie := scraper.Movie{
+		Name: manifest.Title,
+		Year: manifest.Year,
+		URL:  manifest.URL,
+		Path: path,
+		Site: manifest.Site,
+	}
+
+	// Old downloads only have their path to tell what they are.
+	// Files outside of <website>/<movie> folders are not movies.
+	if movie.Site == "" {
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return scraper.Movie{}, nil, err
+		}
+		if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) >= 2 {
+			movie.Site = parts[0]
+		}
+	}
+	if movie.Name == "" {
+		movie.Name = filepath.Base(path)
+	}
+
+	return movie, manifest, nil
+}
+
+// imageFromFile describes an image saved in a movie folder. Size,
+// dimensions and hash always come from the file itself.
+func imageFromFile(moviePath, fileName string, manifest *scraper.Manifest) (scraper.ManifestImage, error) {
+	content, err := os.ReadFile(filepath.Join(moviePath, fileName))
+	if err != nil {
+		return scraper.ManifestImage{}, err
+	}
+
+	image, _ := manifest.Image(fileName)
+	image.FileName = fileName
+	image.Size = int64(len(content))
+	image.Width, image.Height = scraper.ImageSize(content)
+	image.SHA256 = scraper.SHA256(content)
+
+	return image, nil
+}
+
+// isImage tells if a file of a movie folder is a movie still.
+// Images are saved with the filename given by the website, which
+// doesn't always have an extension, so anything else but manifests
+// and hidden files is an image.
+func isImage(fileName string) bool {
+	return !strings.HasPrefix(fileName, ".") && !strings.HasPrefix(fileName, scraper.ManifestFile)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"moviestills/catalog"
+	"moviestills/config"
+	"moviestills/pagecache"
+	"moviestills/scraper"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/pterm/pterm"
+)
+
+// runCatalogCommand shows what the catalog holds, after
+// rebuilding it from the data directory if asked.
+func runCatalogCommand(options *config.Options) {
+	path := options.CatalogPath()
+
+	// Make sure the catalog can be created in the data directory
+	setupDirectories(options)
+
+	cat, err := catalog.Open(path)
+	if err != nil {
+		pterm.Error.Println("Can't open the catalog", pterm.White(path), pterm.Red(err))
+		os.Exit(1)
+	}
+	defer cat.Close()
+
+	if options.Catalog.Rebuild != nil {
+		rebuildCatalog(cat, options)
+	}
+
+	counts, err := cat.Counts()
+	if err != nil {
+		pterm.Error.Println("Can't read the catalog", pterm.White(path), pterm.Red(err))
+		os.Exit(1)
+	}
+
+	pterm.DefaultSection.Println("Catalog")
+	items := []pterm.BulletListItem{}
+	for _, count := range counts {
+		items = append(items, pterm.BulletListItem{
+			Level:       0,
+			Text:        pterm.Yellow(count.Site) + ": " + pterm.Sprintf("%s movies, %s images", pterm.White(count.Movies), pterm.White(count.Images)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgGreen),
+		})
+	}
+	if len(items) == 0 {
+		pterm.Info.Println("The catalog is empty, you can build it from the data directory with", pterm.Blue("catalog rebuild"))
+		return
+	}
+
+	if err := pterm.DefaultBulletList.WithItems(items).Render(); err != nil {
+		pterm.Error.Println("Could not print the catalog", pterm.Red(err))
+	}
+}
+
+// rebuildCatalog indexes again everything found in the data directory
+func rebuildCatalog(cat *catalog.Catalog, options *config.Options) {
+	pterm.Info.Println("Rebuilding the catalog from", pterm.White(options.DataDir))
+
+	if err := cat.Reset(); err != nil {
+		pterm.Error.Println("Can't empty the catalog", pterm.Red(err))
+		os.Exit(1)
+	}
+
+	run, err := cat.StartRun("rebuild", nil)
+	if err != nil {
+		pterm.Error.Println("Can't start rebuilding the catalog", pterm.Red(err))
+		os.Exit(1)
+	}
+
+	movies, images, err := run.Rebuild(options.DataDir)
+	if err != nil {
+		pterm.Error.Println("Can't rebuild the catalog", pterm.Red(err))
+		os.Exit(1)
+	}
+
+	if err := run.Finish(); err != nil {
+		pterm.Warning.Println("Can't record the end of the rebuild", pterm.Red(err))
+	}
+
+	pterm.Success.Println("Indexed", pterm.White(images), "images of", pterm.White(movies), "movies")
+}
+
+// runCacheCommand manages the cache folder
+func runCacheCommand(parser *arg.Parser, options *config.Options) {
+	if options.Cache.Migrate == nil {
+		parser.WriteHelpForSubcommand(os.Stdout, "cache")
+		return
+	}
+
+	// Images were cached along pages before, they
+	// are only saved in the data directory now.
+	pterm.Info.Println("Removing images from the cache folder", pterm.White(options.CacheDir))
+	removed, freed, err := pagecache.StripImages(options.CacheDir)
+	if err != nil {
+		pterm.Error.Println("Can't migrate the cache folder", pterm.White(options.CacheDir), pterm.Red(err))
+		os.Exit(1)
+	}
+
+	pterm.Success.Println("Removed", pterm.White(removed), "cached images, freeing", pterm.White(pterm.Sprintf("%.1f MB", float64(freed)/1e6)))
+}
+
+// runDataCommand manages the data directory
+func runDataCommand(parser *arg.Parser, options *config.Options) {
+	if options.Data.Dedupe != nil {
+		runDataDedupeCommand(options)
+		return
+	}
+	if options.Data.Migrate == nil {
+		parser.WriteHelpForSubcommand(os.Stdout, "data")
+		return
+	}
+
+	dryRun := options.Data.Migrate.DryRun
+	fileNameTemplate := options.FileNameTemplate
+	if options.Naming == scraper.NamingContent {
+		fileNameTemplate = scraper.ContentFileNameTemplate
+	}
+	pterm.Info.Println("Moving movies and stills of", pterm.White(options.DataDir), "to follow the templates",
+		pterm.White(options.PathTemplate), "and", pterm.White(fileNameTemplate))
+
+	moves, err := scraper.Relayout(options.DataDir, scraper.LayoutFor(options), dryRun)
+	for _, move := range moves {
+		if dryRun {
+			pterm.Info.Println(pterm.White(move.From), "->", pterm.White(move.To))
+		} else {
+			pterm.Debug.Println("Moved", pterm.White(move.From), "->", pterm.White(move.To))
+		}
+	}
+	if err != nil {
+		pterm.Warning.Println("Some movies were left as is:", pterm.Red(err))
+	}
+
+	if dryRun {
+		pterm.Info.Println(pterm.White(len(moves)), "images would be moved, run again without", pterm.Blue("--dry-run"), "to move them")
+		return
+	}
+	pterm.Success.Println("Moved", pterm.White(len(moves)), "images")
+
+	// The catalog must follow the images moved
+	if len(moves) == 0 || options.NoCatalog {
+		return
+	}
+	if _, err := os.Stat(options.CatalogPath()); err != nil {
+		return
+	}
+	cat, err := catalog.Open(options.CatalogPath())
+	if err != nil {
+		pterm.Error.Println("Can't open the catalog", pterm.White(options.CatalogPath()), pterm.Red(err))
+		os.Exit(1)
+	}
+	defer cat.Close()
+	rebuildCatalog(cat, options)
+}
+
+// runDataDedupeCommand links identical images of the data directory
+func runDataDedupeCommand(options *config.Options) {
+	dryRun := options.Data.Dedupe.DryRun
+	pterm.Info.Println("Linking identical stills of", pterm.White(options.DataDir), "with", pterm.White(options.LinkMode), "links")
+
+	stats, err := scraper.Dedupe(options.DataDir, options.LinkMode, dryRun)
+	if err != nil {
+		pterm.Warning.Println("Some stills were left as is:", pterm.Red(err))
+	}
+	printLinkStats(stats, dryRun)
+
+	if dryRun {
+		pterm.Info.Println("Run again without", pterm.Blue("--dry-run"), "to link them")
+	}
+}
+
+// printLinkStats shows the space reclaimed by linking identical images, by website
+func printLinkStats(stats []scraper.LinkStats, dryRun bool) {
+	verb := "Linked"
+	if dryRun {
+		verb = "Would link"
+	}
+
+	var files int
+	var reclaimed int64
+	for _, s := range stats {
+		pterm.Info.Println(pterm.Yellow(s.Site)+":", verb, pterm.White(s.Files), "identical stills, reclaiming",
+			pterm.White(pterm.Sprintf("%.1f MB", float64(s.Reclaimed)/1e6)))
+		files += s.Files
+		reclaimed += s.Reclaimed
+	}
+
+	pterm.Success.Println(verb, pterm.White(files), "identical stills in total, reclaiming",
+		pterm.White(pterm.Sprintf("%.1f MB", float64(reclaimed)/1e6)))
+}
+
+// runConfigCommand checks the options, or shows them. Options were
+// read and checked when starting already.
+func runConfigCommand(parser *arg.Parser, options *config.Options, origins *config.Origins) {
+	switch {
+	case options.Config.Validate != nil:
+		validateWebsites(options)
+		if options.ConfigFile != "" {
+			pterm.Success.Println("The config file", pterm.White(options.ConfigFile), "and the other options are valid")
+		} else {
+			pterm.Success.Println("The options are valid, no config file given")
+		}
+
+	case options.Config.Print != nil && options.Config.Print.Effective:
+		printEffectiveOptions(options, origins)
+
+	case options.Config.Print != nil:
+		if options.ConfigFile == "" {
+			pterm.Info.Println("No config file given, use", pterm.Blue("--config"), "to read one")
+			return
+		}
+		file, err := config.ReadFile(options.ConfigFile)
+		if err == nil {
+			var content []byte
+			if content, err = file.Marshal(); err == nil {
+				fmt.Print(string(content))
+				return
+			}
+		}
+		pterm.Error.Println("Can't read the config file", pterm.White(options.ConfigFile), pterm.Red(err))
+		os.Exit(1)
+
+	default:
+		parser.WriteHelpForSubcommand(os.Stdout, "config")
+	}
+}
+
+// validateWebsites makes sure the websites of the options exist
+func validateWebsites(options *config.Options) {
+	var unknown []string
+	for _, website := range options.Website {
+		if _, exists := scraper.Lookup(website); !exists {
+			unknown = append(unknown, website)
+		}
+	}
+	for website := range options.Sites {
+		if _, exists := scraper.Lookup(website); !exists {
+			unknown = append(unknown, website)
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	sort.Strings(unknown)
+	pterm.Error.Println("We don't have a scraper for:", pterm.White(strings.Join(unknown, ", ")))
+	os.Exit(1)
+}
+
+// printEffectiveOptions shows the value of every option, and of the
+// options of websites, with where it came from
+func printEffectiveOptions(options *config.Options, origins *config.Origins) {
+	// Passwords of proxies are not shown
+	options = options.Redacted()
+
+	rows := [][]string{{"Option", "Value", "Origin"}}
+	for _, option := range config.OptionsList() {
+		rows = append(rows, []string{option.Name, fmt.Sprint(options.OptionValue(option)), origins.Options[option.Name].String()})
+	}
+
+	websites := make([]string, 0, len(origins.Sites))
+	for website := range origins.Sites {
+		websites = append(websites, website)
+	}
+	sort.Strings(websites)
+	for _, website := range websites {
+		values := options.Sites[website].Values()
+		for _, key := range config.SiteOptionKeys {
+			origin, found := origins.Sites[website][key]
+			if !found {
+				continue
+			}
+			rows = append(rows, []string{website + "." + key, fmt.Sprint(values[key]), origin.String()})
+		}
+	}
+
+	if err := pterm.DefaultTable.WithHasHeader().WithData(rows).Render(); err != nil {
+		pterm.Error.Println("Could not print the options", pterm.Red(err))
+	}
+}
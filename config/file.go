@@ -0,0 +1,335 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-scalar"
+	"gopkg.in/yaml.v3"
+)
+
+// SitesKey is the section of config files holding options by website
+const SitesKey = "sites"
+
+// Where the value of an option came from, from the weakest to the strongest
+const (
+	FromDefault = "default"
+	FromFile    = "config file"
+	FromEnv     = "env"
+	FromCLI     = "cli"
+)
+
+// Origin tells where the value of an option came from
+type Origin struct {
+	From string
+
+	// Name of the option where it came from, eg. an environment variable
+	Name string
+}
+
+func (o Origin) String() string {
+	if o.Name == "" {
+		return o.From
+	}
+	return o.From + " " + o.Name
+}
+
+// Option describes an option which can be set through the CLI,
+// environment variables or config files
+type Option struct {
+	// Name of the option, as a CLI argument and a key of config files
+	Name  string
+	Short string
+	Env   string
+
+	// Field of Options holding the option
+	Field string
+}
+
+// OptionsList lists the options which can be set, in order
+func OptionsList() []Option {
+	var list []Option
+	t := reflect.TypeOf(Options{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, found := field.Tag.Lookup("arg")
+		if !found || tag == "-" || strings.Contains(tag, "subcommand") {
+			continue
+		}
+
+		option := Option{Field: field.Name}
+		for _, part := range strings.Split(tag, ",") {
+			part = strings.TrimSpace(part)
+			switch {
+			case strings.HasPrefix(part, "--"):
+				option.Name = strings.TrimPrefix(part, "--")
+			case strings.HasPrefix(part, "-"):
+				option.Short = strings.TrimPrefix(part, "-")
+			case strings.HasPrefix(part, "env:"):
+				option.Env = strings.TrimPrefix(part, "env:")
+			}
+		}
+		if option.Name != "" {
+			list = append(list, option)
+		}
+	}
+	return list
+}
+
+// Origins tells where the values of options came from
+type Origins struct {
+	// Origins of options, by name
+	Options map[string]Origin
+
+	// Origins of the options of websites, by website and key
+	Sites map[string]map[string]Origin
+}
+
+// Resolve layers the options of the config file, if any, between the
+// default values and those set with environment variables or CLI
+// arguments, as parsed into the options already. The options of
+// websites given with --site-opt are set on top of the config file too.
+func (o *Options) Resolve(args []string, lookupEnv func(string) (string, bool)) (*Origins, error) {
+	origins := &Origins{Options: make(map[string]Origin), Sites: make(map[string]map[string]Origin)}
+	for _, option := range OptionsList() {
+		origins.Options[option.Name] = Origin{From: FromDefault}
+		if option.Env != "" {
+			if _, found := lookupEnv(option.Env); found {
+				origins.Options[option.Name] = Origin{From: FromEnv, Name: option.Env}
+			}
+		}
+		if flag, found := findFlag(args, option); found {
+			origins.Options[option.Name] = Origin{From: FromCLI, Name: flag}
+		}
+	}
+
+	if o.ConfigFile != "" {
+		file, err := ReadFile(o.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+
+		options := reflect.ValueOf(o).Elem()
+		for _, option := range OptionsList() {
+			value, found := file.Options[option.Name]
+			if !found || origins.Options[option.Name].From != FromDefault {
+				continue
+			}
+			if err := setOption(options.FieldByName(option.Field), value); err != nil {
+				return nil, fmt.Errorf("%s: option %s: %w", o.ConfigFile, option.Name, err)
+			}
+			origins.Options[option.Name] = Origin{From: FromFile, Name: o.ConfigFile}
+		}
+
+		o.Sites = file.Sites
+		for site, keys := range file.keys {
+			for _, key := range keys {
+				origins.setSite(site, key, Origin{From: FromFile, Name: o.ConfigFile})
+			}
+		}
+	}
+
+	if err := o.ParseSiteOpts(); err != nil {
+		return nil, err
+	}
+	for _, opt := range o.SiteOpts {
+		site, key, _, _ := splitSiteOpt(opt)
+		origins.setSite(site, key, origins.Options["site-opt"])
+	}
+
+	return origins, nil
+}
+
+// setSite records where an option of a website came from
+func (o *Origins) setSite(site, key string, origin Origin) {
+	if o.Sites[site] == nil {
+		o.Sites[site] = make(map[string]Origin)
+	}
+	o.Sites[site][key] = origin
+}
+
+// findFlag tells if an option is given as a CLI argument, and how
+func findFlag(args []string, option Option) (string, bool) {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, _, _ := strings.Cut(arg, "=")
+		if name == "--"+option.Name || (option.Short != "" && name == "-"+option.Short) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// File holds the options of a config file, by option name,
+// and the options of websites.
+type File struct {
+	Options map[string]interface{}
+	Sites   map[string]SiteOptions
+
+	// Keys of the options set for every website
+	keys map[string][]string
+}
+
+// ReadFile reads a YAML config file, made of options named after
+// their CLI argument, and of a section of options by website.
+func ReadFile(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file := &File{Options: make(map[string]interface{})}
+	known := make(map[string]bool)
+	for _, option := range OptionsList() {
+		known[option.Name] = true
+	}
+
+	var errs []error
+	for key, value := range values {
+		switch {
+		case key == SitesKey:
+			file.Sites, file.keys, err = readSites(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		case key == "config":
+			errs = append(errs, fmt.Errorf("%s: config files can't include other config files", path))
+		case !known[key]:
+			errs = append(errs, fmt.Errorf("%s: unknown option %q", path, key))
+		default:
+			file.Options[key] = value
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errors.Join(errs...)
+	}
+
+	return file, nil
+}
+
+// readSites reads the options of websites of a config file,
+// and the keys set for every website
+func readSites(value interface{}) (map[string]SiteOptions, map[string][]string, error) {
+	sites, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("sites must be a map of options by website")
+	}
+
+	result := make(map[string]SiteOptions, len(sites))
+	keys := make(map[string][]string, len(sites))
+	for site, value := range sites {
+		options, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("options of website %s must be a map", site)
+		}
+		var siteOptions SiteOptions
+		for key, value := range options {
+			if err := siteOptions.Set(key, fmt.Sprint(value)); err != nil {
+				return nil, nil, fmt.Errorf("options of website %s: %w", site, err)
+			}
+			keys[site] = append(keys[site], key)
+		}
+		result[site] = siteOptions
+	}
+
+	return result, keys, nil
+}
+
+// setOption sets the field of an option from a value of a config file,
+// a list for options which can be specified multiple times.
+func setOption(field reflect.Value, value interface{}) error {
+	if field.Kind() != reflect.Slice {
+		if _, isList := value.([]interface{}); isList {
+			return errors.New("a single value is expected, not a list")
+		}
+		return scalar.ParseValue(field, fmt.Sprint(value))
+	}
+
+	values, isList := value.([]interface{})
+	if !isList {
+		values = []interface{}{value}
+	}
+	list := reflect.MakeSlice(field.Type(), len(values), len(values))
+	for i, v := range values {
+		if err := scalar.ParseValue(list.Index(i), fmt.Sprint(v)); err != nil {
+			return err
+		}
+	}
+	field.Set(list)
+
+	return nil
+}
+
+// OptionValue is the value of an option, as written in config files
+func (o *Options) OptionValue(option Option) interface{} {
+	field := reflect.ValueOf(o).Elem().FieldByName(option.Field)
+	if field.Kind() == reflect.Slice {
+		values := make([]interface{}, field.Len())
+		for i := range values {
+			values[i] = formatValue(field.Index(i))
+		}
+		return values
+	}
+	return formatValue(field)
+}
+
+// formatValue writes durations as text, eg. 15s, other values as they are
+func formatValue(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return v.Interface()
+}
+
+// Marshal writes the options of the config file as YAML, in the order
+// of the CLI arguments, with values as parsed.
+func (f *File) Marshal() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value interface{}) error {
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &node)
+		return nil
+	}
+
+	var parsed Options
+	for _, option := range OptionsList() {
+		value, found := f.Options[option.Name]
+		if !found {
+			continue
+		}
+		if err := setOption(reflect.ValueOf(&parsed).Elem().FieldByName(option.Field), value); err != nil {
+			return nil, fmt.Errorf("option %s: %w", option.Name, err)
+		}
+		if err := add(option.Name, parsed.OptionValue(option)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(f.Sites) > 0 {
+		sites := make(map[string]map[string]interface{}, len(f.Sites))
+		for site, options := range f.Sites {
+			sites[site] = options.Values()
+		}
+		if err := add(SitesKey, sites); err != nil {
+			return nil, err
+		}
+	}
+
+	return yaml.Marshal(root)
+}
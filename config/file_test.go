@@ -0,0 +1,183 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+const testConfig = `
+website: [dvdbeaver, film-grab]
+parallel: 3
+delay: 2s
+timeout: 30s
+placeholder: abc
+retry-on: [timeout, throttled]
+sites:
+  dvdbeaver:
+    parallel: 1
+    timeout: 1m
+`
+
+// writeConfig writes a config file to a temporary folder
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "moviestills.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// parseOptions parses the options as the CLI does, then resolves them
+func parseOptions(t *testing.T, args []string, env map[string]string) (*Options, *Origins) {
+	t.Helper()
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	var options Options
+	parser, err := arg.NewParser(arg.Config{}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	origins, err := options.Resolve(args, os.LookupEnv)
+	if err != nil {
+		t.Fatalf("Resolve() unexpected error: %v", err)
+	}
+	return &options, origins
+}
+
+func TestResolve(t *testing.T) {
+	path := writeConfig(t, testConfig)
+
+	options, origins := parseOptions(t,
+		[]string{"--config", path, "--delay=5s", "--site-opt", "dvdbeaver.timeout=2m"},
+		map[string]string{"TIMEOUT": "45s"},
+	)
+
+	tests := []struct {
+		option string
+		got    interface{}
+		want   interface{}
+		origin Origin
+	}{
+		{"website", strings.Join(options.Website, ","), "dvdbeaver,film-grab", Origin{From: FromFile, Name: path}},
+		{"parallel", options.Parallel, 3, Origin{From: FromFile, Name: path}},
+		{"delay", options.RandomDelay, 5 * time.Second, Origin{From: FromCLI, Name: "--delay"}},
+		{"timeout", options.TimeOut, 45 * time.Second, Origin{From: FromEnv, Name: "TIMEOUT"}},
+		{"placeholder", strings.Join(options.Placeholders, ","), "abc", Origin{From: FromFile, Name: path}},
+		{"retry-on", strings.Join(options.RetryPolicy().Classes, ","), "timeout,throttled", Origin{From: FromFile, Name: path}},
+		{"cache-dir", options.CacheDir, "cache", Origin{From: FromDefault}},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Option %s = %v, want %v", tt.option, tt.got, tt.want)
+		}
+		if got := origins.Options[tt.option]; got != tt.origin {
+			t.Errorf("Option %s came from %v, want %v", tt.option, got, tt.origin)
+		}
+	}
+
+	// Options of websites given with --site-opt override the config file
+	site := options.ForSite("dvdbeaver")
+	if site.Parallel != 1 || site.TimeOut != 2*time.Minute {
+		t.Errorf("ForSite(dvdbeaver) = %d, %v, want 1, 2m", site.Parallel, site.TimeOut)
+	}
+	if got, want := origins.Sites["dvdbeaver"]["parallel"], (Origin{From: FromFile, Name: path}); got != want {
+		t.Errorf("Option dvdbeaver.parallel came from %v, want %v", got, want)
+	}
+	if got, want := origins.Sites["dvdbeaver"]["timeout"], (Origin{From: FromCLI, Name: "--site-opt"}); got != want {
+		t.Errorf("Option dvdbeaver.timeout came from %v, want %v", got, want)
+	}
+}
+
+func TestResolveWithoutFile(t *testing.T) {
+	options, origins := parseOptions(t, []string{"-p", "2"}, nil)
+
+	if options.Parallel != 2 || options.Sites != nil {
+		t.Errorf("Resolve() = %d, %v, want 2 and no options of websites", options.Parallel, options.Sites)
+	}
+	if got, want := origins.Options["parallel"], (Origin{From: FromCLI, Name: "-p"}); got != want {
+		t.Errorf("Option parallel came from %v, want %v", got, want)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", testConfig, ""},
+		{"empty", "", ""},
+		{"unknown option", "speed: 1", `unknown option "speed"`},
+		{"nested config", "config: other.yaml", "can't include other config files"},
+		{"invalid sites", "sites: [dvdbeaver]", "sites must be a map"},
+		{"invalid site option", "sites: {dvdbeaver: {parallel: 0}}", "parallel must be a number"},
+		{"unknown site option", "sites: {dvdbeaver: {speed: 1}}", `unknown option "speed"`},
+		{"invalid YAML", "parallel: [", "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFile(writeConfig(t, tt.content))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("ReadFile() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ReadFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveInvalidValue(t *testing.T) {
+	path := writeConfig(t, "parallel: many")
+
+	options := &Options{ConfigFile: path}
+	if _, err := options.Resolve(nil, os.LookupEnv); err == nil || !strings.Contains(err.Error(), "parallel") {
+		t.Errorf("Resolve() error = %v, want an error about parallel", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	file, err := ReadFile(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := file.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `website:
+    - dvdbeaver
+    - film-grab
+parallel: 3
+delay: 2s
+timeout: 30s
+retry-on:
+    - timeout
+    - throttled
+placeholder:
+    - abc
+sites:
+    dvdbeaver:
+        parallel: 1
+        timeout: 1m0s
+`
+	if string(content) != want {
+		t.Errorf("Marshal() = %s, want %s", content, want)
+	}
+}
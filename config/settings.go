@@ -1,22 +1,202 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"moviestills/hostlimit"
+	"moviestills/pagecache"
+	"moviestills/proxypool"
+	"moviestills/retry"
+	"path/filepath"
+	"time"
+)
 
 // Options which can be set through the CLI or environment variables
 type Options struct {
-	Website      []string      `arg:"-w, --website,separate,env:WEBSITE" help:"Website(s) to scrape movie stills from (can be specified multiple times)"`
-	All          bool          `arg:"-A, --all,env:ALL" help:"Scrape all available websites" default:"false"`
-	ListScrapers bool          `arg:"-l, --list,env:LIST" help:"List all available scrapers implemented" default:"false"`
-	Parallel     int           `arg:"-p, --parallel,env:PARALLEL" help:"Limit the maximum parallelism" default:"5"`
-	RandomDelay  time.Duration `arg:"-r, --delay,env:RANDOM_DELAY" help:"Add some random delay between requests" default:"0s"`
-	Async        bool          `arg:"-a, --async,env:ASYNC" help:"Enable asynchronous running jobs" default:"false"`
-	Sequential   bool          `arg:"-s, --sequential,env:SEQUENTIAL" help:"Run multiple websites sequentially instead of concurrently" default:"false"`
-	TimeOut      time.Duration `arg:"-t, --timeout,env:TIMEOUT" help:"Set the default request timeout for the scraper" default:"15s"`
-	Proxy        string        `arg:"-x, --proxy,env:PROXY" help:"The proxy URL to use for scraping"`
-	CacheDir     string        `arg:"-c, --cache-dir,env:CACHE_DIR" help:"Where to cache scraped websites pages" default:"cache"`
-	DataDir      string        `arg:"-f, --data-dir,env:DATA_DIR" help:"Where to store movie snapshots" default:"data"`
-	Hash         bool          `arg:"--hash,env:HASH" help:"Hash image filenames with md5" default:"false"`
-	Debug        bool          `arg:"-d, --debug,env:DEBUG" help:"Set Log Level to Debug to see everything" default:"false"`
-	NoColors     bool          `arg:"--no-colors,env:NO_COLORS" help:"Disable colors from output" default:"false"`
-	NoStyle      bool          `arg:"--no-style,env:NO_STYLE" help:"Disable styling and colors entirely from output" default:"false"`
+	ConfigFile       string        `arg:"--config,env:CONFIG" help:"Read options from this YAML file, environment variables and CLI arguments taking precedence"`
+	Website          []string      `arg:"-w, --website,separate,env:WEBSITE" help:"Website(s) to scrape movie stills from (can be specified multiple times)"`
+	All              bool          `arg:"-A, --all,env:ALL" help:"Scrape all available websites" default:"false"`
+	ListScrapers     bool          `arg:"-l, --list,env:LIST" help:"List all available scrapers implemented" default:"false"`
+	Parallel         int           `arg:"-p, --parallel,env:PARALLEL" help:"Limit the maximum number of requests at once to each host, for all websites" default:"5"`
+	RandomDelay      time.Duration `arg:"-r, --delay,env:RANDOM_DELAY" help:"Add some random delay between requests" default:"0s"`
+	Rate             float64       `arg:"--rate,env:RATE" help:"Limit the number of requests per second to each host, for all websites (0 for no limit)" default:"0"`
+	HostLimits       []string      `arg:"--host-limit,separate,env:HOST_LIMITS" help:"Limit requests to hosts matching a pattern, eg. '*.imgur.com:parallel=2,rate=1,delay=2s' (can be specified multiple times)"`
+	Async            bool          `arg:"-a, --async,env:ASYNC" help:"Enable asynchronous running jobs" default:"false"`
+	Resume           bool          `arg:"--resume,env:RESUME" help:"Resume interrupted scraping jobs, skipping movies already scraped" default:"false"`
+	Sequential       bool          `arg:"-s, --sequential,env:SEQUENTIAL" help:"Run multiple websites sequentially instead of concurrently" default:"false"`
+	TimeOut          time.Duration `arg:"-t, --timeout,env:TIMEOUT" help:"Set the default request timeout for the scraper, for every attempt" default:"15s"`
+	MaxAttempts      int           `arg:"--max-attempts,env:MAX_ATTEMPTS" help:"How many times requests are tried, retrying the errors of --retry-on (1 to never retry)" default:"3"`
+	RetryOn          []string      `arg:"--retry-on,separate,env:RETRY_ON" help:"Class of errors to retry: timeout, connection, throttled, server, client or other (can be specified multiple times, default: timeout, connection, throttled and server)"`
+	RetryDelay       time.Duration `arg:"--retry-delay,env:RETRY_DELAY" help:"Delay before retrying a request, doubled for every other retry" default:"1s"`
+	RetryMaxDelay    time.Duration `arg:"--retry-max-delay,env:RETRY_MAX_DELAY" help:"Maximum delay before retrying a request, websites asking to wait longer are not retried" default:"1m"`
+	MaxDuration      time.Duration `arg:"--max-duration,env:MAX_DURATION" help:"Stop scraping after this duration, eg. 2h (0 for no limit)" default:"0s"`
+	Proxies          []string      `arg:"-x, --proxy,separate,env:PROXY" help:"Proxy URL to use for scraping: http, https or socks5, with credentials if needed (can be specified multiple times, to rotate between them)"`
+	ProxyFile        string        `arg:"--proxy-file,env:PROXY_FILE" help:"Read proxy URLs to rotate between from this file, one per line"`
+	ProxyRotation    string        `arg:"--proxy-rotation,env:PROXY_ROTATION" help:"How to pick the proxy of every request: round-robin or random" default:"round-robin"`
+	ProxyMaxFailures int           `arg:"--proxy-max-failures,env:PROXY_MAX_FAILURES" help:"Set proxies aside after this many failed requests in a row (0 to never set them aside)" default:"3"`
+	ProxyEjectFor    time.Duration `arg:"--proxy-eject,env:PROXY_EJECT" help:"How long proxies failing too many requests are set aside" default:"5m"`
+	SiteOpts         []string      `arg:"--site-opt,separate,env:SITE_OPTS" help:"Override an option for a website as site.key=value, with the keys parallel (capped by the host limits), delay, timeout and proxy, eg. highdefdiscnews.timeout=2m (can be specified multiple times)"`
+	RespectRobots    bool          `arg:"--respect-robots,env:RESPECT_ROBOTS" help:"Follow robots.txt files: skip what they disallow, and wait their crawl delay between requests (--respect-robots=false to opt out)" default:"true"`
+	CacheDir         string        `arg:"-c, --cache-dir,env:CACHE_DIR" help:"Where to cache scraped websites pages" default:"cache"`
+	IndexTTL         time.Duration `arg:"--index-ttl,env:INDEX_TTL" help:"How long cached index and listing pages are used before checking them again (0 for forever)" default:"24h"`
+	MovieTTL         time.Duration `arg:"--movie-ttl,env:MOVIE_TTL" help:"How long cached movie pages are used before checking them again (0 for forever)" default:"0s"`
+	Refresh          bool          `arg:"--refresh,env:REFRESH" help:"Check cached index and listing pages again, whatever their age" default:"false"`
+	DataDir          string        `arg:"-f, --data-dir,env:DATA_DIR" help:"Where to store movie snapshots" default:"data"`
+	SitesDir         string        `arg:"--sites-dir,env:SITES_DIR" help:"Where to find website definition files (YAML or JSON)" default:"sites"`
+	PathTemplate     string        `arg:"--path-template,env:PATH_TEMPLATE" help:"Where to save movies in the data directory, as a Go template using .Site, .Title and .Year" default:"{{.Site}}/{{.Title}}"`
+	FileNameTemplate string        `arg:"--filename-template,env:FILENAME_TEMPLATE" help:"How to name images, as a Go template using .Site, .Title, .Year, .Index, .Hash, .Name and .Ext" default:"{{.Name}}{{.Ext}}"`
+	Naming           string        `arg:"--naming,env:NAMING" help:"How to name images: url (following the filename template) or content (SHA-256 hash of the image)" default:"url"`
+	Hash             bool          `arg:"--hash,env:HASH" help:"Hash image filenames with md5" default:"false"`
+	MinWidth         int           `arg:"--min-width,env:MIN_WIDTH" help:"Discard images narrower than this, in pixels" default:"500"`
+	MinHeight        int           `arg:"--min-height,env:MIN_HEIGHT" help:"Discard images shorter than this, in pixels" default:"265"`
+	VerifyImages     bool          `arg:"--verify-images,env:VERIFY_IMAGES" help:"Check the SHA-256 hash of images already saved, not only their size, before skipping them" default:"false"`
+	Placeholders     []string      `arg:"--placeholder,separate,env:PLACEHOLDERS" help:"SHA-256 hash of a placeholder served instead of removed images, to discard (can be specified multiple times)"`
+	Dedup            string        `arg:"--dedup,env:DEDUP" help:"What to do with near-identical stills of a movie: move (the smaller to a duplicates folder), skip (the ones found after the first) or off" default:"off"`
+	DedupDistance    int           `arg:"--dedup-distance,env:DEDUP_DISTANCE" help:"Maximum number of different bits between perceptual hashes of near-identical stills (0-64)" default:"4"`
+	LinkIdentical    bool          `arg:"--link-identical,env:LINK_IDENTICAL" help:"Replace images identical to one saved before, for any website, with links to it" default:"false"`
+	LinkMode         string        `arg:"--link-mode,env:LINK_MODE" help:"How to link identical images: auto (reflinks where supported, hardlinks otherwise), reflink or hardlink" default:"auto"`
+	CatalogFile      string        `arg:"--catalog,env:CATALOG" help:"Where to store the SQLite catalog of scraped movies and stills (default: catalog.db in the data directory)"`
+	NoCatalog        bool          `arg:"--no-catalog,env:NO_CATALOG" help:"Don't record scraped movies and stills in the catalog" default:"false"`
+	Report           string        `arg:"--report,env:REPORT" help:"Write a JSON report of the run to this file"`
+	ReportJUnit      string        `arg:"--report-junit,env:REPORT_JUNIT" help:"Write a JUnit XML report of the run to this file"`
+	MetricsAddr      string        `arg:"--metrics-addr,env:METRICS_ADDR" help:"Serve Prometheus metrics on this address, eg. :9090"`
+	LogFormat        string        `arg:"--log-format,env:LOG_FORMAT" help:"Format of logs: text or json (structured records, one per line)" default:"text"`
+	LogFile          string        `arg:"--log-file,env:LOG_FILE" help:"Write logs to this file, the terminal keeping its pretty output"`
+	Debug            bool          `arg:"-d, --debug,env:DEBUG" help:"Set Log Level to Debug to see everything" default:"false"`
+	NoColors         bool          `arg:"--no-colors,env:NO_COLORS" help:"Disable colors from output" default:"false"`
+	NoStyle          bool          `arg:"--no-style,env:NO_STYLE" help:"Disable styling and colors entirely from output" default:"false"`
+
+	// Options overridden for websites, by website
+	Sites map[string]SiteOptions `arg:"-"`
+
+	// Subcommands
+	Catalog *CatalogCommand `arg:"subcommand:catalog" help:"Show or rebuild the catalog of scraped movies and stills"`
+	Cache   *CacheCommand   `arg:"subcommand:cache" help:"Manage the cache of scraped websites pages"`
+	Data    *DataCommand    `arg:"subcommand:data" help:"Manage the data directory of movie stills"`
+	Config  *ConfigCommand  `arg:"subcommand:config" help:"Check or show the options in effect"`
+}
+
+// CatalogCommand shows what the catalog holds, or rebuilds it
+type CatalogCommand struct {
+	Rebuild *CatalogRebuildCommand `arg:"subcommand:rebuild" help:"Index again every movie and still found in the data directory"`
+}
+
+// CatalogRebuildCommand rebuilds the catalog from the data directory
+type CatalogRebuildCommand struct{}
+
+// CacheCommand manages the cache folder
+type CacheCommand struct {
+	Migrate *CacheMigrateCommand `arg:"subcommand:migrate" help:"Remove images cached by older versions from the cache folder"`
+}
+
+// CacheMigrateCommand removes images from the cache folder
+type CacheMigrateCommand struct{}
+
+// ConfigCommand checks or shows the options in effect
+type ConfigCommand struct {
+	Validate *ConfigValidateCommand `arg:"subcommand:validate" help:"Check the config file and the other options"`
+	Print    *ConfigPrintCommand    `arg:"subcommand:print" help:"Show the options of the config file"`
+}
+
+// ConfigValidateCommand checks the options
+type ConfigValidateCommand struct{}
+
+// ConfigPrintCommand shows the options
+type ConfigPrintCommand struct {
+	Effective bool `arg:"--effective" help:"Show every option in effect, and where its value came from" default:"false"`
+}
+
+// DataCommand manages the data directory
+type DataCommand struct {
+	Migrate *DataMigrateCommand `arg:"subcommand:migrate" help:"Move and rename movies and stills saved before to follow the path and filename templates"`
+	Dedupe  *DataDedupeCommand  `arg:"subcommand:dedupe" help:"Replace identical stills, for any movie of any website, with links to a single file"`
+}
+
+// DataMigrateCommand lays out the data directory again
+type DataMigrateCommand struct {
+	DryRun bool `arg:"--dry-run" help:"Only show what would be moved" default:"false"`
+}
+
+// CatalogPath is where the catalog is stored
+func (o *Options) CatalogPath() string {
+	if o.CatalogFile != "" {
+		return o.CatalogFile
+	}
+	return filepath.Join(o.DataDir, "catalog.db")
+}
+
+// CachePolicy tells how long cached pages are used
+func (o *Options) CachePolicy() pagecache.Policy {
+	return pagecache.Policy{
+		IndexTTL: o.IndexTTL,
+		MovieTTL: o.MovieTTL,
+		Refresh:  o.Refresh,
+	}
+}
+
+// HostLimitRules tells how requests to hosts are limited: the rules
+// of --host-limit first, then the default one for any host.
+func (o *Options) HostLimitRules() ([]hostlimit.Rule, error) {
+	defaults := hostlimit.Rule{
+		Pattern:     "*",
+		Parallelism: o.Parallel,
+		Rate:        o.Rate,
+		RandomDelay: o.RandomDelay,
+	}
+
+	rules := make([]hostlimit.Rule, 0, len(o.HostLimits)+1)
+	for _, limit := range o.HostLimits {
+		rule, err := hostlimit.ParseRule(limit, defaults)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return append(rules, defaults), nil
+}
+
+// ProxyList lists the proxies to rotate between: those of --proxy,
+// then those of the proxy file.
+func (o *Options) ProxyList() ([]string, error) {
+	proxies := append([]string(nil), o.Proxies...)
+	if o.ProxyFile != "" {
+		fromFile, err := proxypool.ReadFile(o.ProxyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read proxy file: %w", err)
+		}
+		proxies = append(proxies, fromFile...)
+	}
+
+	for _, proxy := range proxies {
+		if _, err := proxypool.Parse(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	return proxies, nil
+}
+
+// ProxyPolicy tells how proxies are picked, and set aside
+func (o *Options) ProxyPolicy() proxypool.Policy {
+	return proxypool.Policy{
+		Rotation:    o.ProxyRotation,
+		MaxFailures: o.ProxyMaxFailures,
+		EjectFor:    o.ProxyEjectFor,
+	}
+}
+
+// RetryPolicy tells how failed requests are retried
+func (o *Options) RetryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts: o.MaxAttempts,
+		Timeout:     o.TimeOut,
+		BaseDelay:   o.RetryDelay,
+		MaxDelay:    o.RetryMaxDelay,
+		Classes:     o.RetryOn,
+	}
+}
+
+// DataDedupeCommand links identical images of the data directory
+type DataDedupeCommand struct {
+	DryRun bool `arg:"--dry-run" help:"Only show the space that would be reclaimed" default:"false"`
 }
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"moviestills/proxypool"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SiteOptions override options for a website, when set
+type SiteOptions struct {
+	Parallel    *int           `json:"parallel,omitempty"`
+	RandomDelay *time.Duration `json:"delay,omitempty"`
+	TimeOut     *time.Duration `json:"timeout,omitempty"`
+	Proxy       *string        `json:"proxy,omitempty"`
+}
+
+// SiteOptionKeys are the options which can be set for a website
+var SiteOptionKeys = []string{"parallel", "delay", "timeout", "proxy"}
+
+// Set sets an option of the website from its text value
+func (s *SiteOptions) Set(key, value string) error {
+	switch key {
+	case "parallel":
+		parallel, err := strconv.Atoi(value)
+		if err != nil || parallel < 1 {
+			return fmt.Errorf("parallel must be a number of requests, at least 1: %q", value)
+		}
+		s.Parallel = &parallel
+	case "delay", "timeout":
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("%s must be a duration, eg. 30s: %q", key, value)
+		}
+		if key == "delay" {
+			s.RandomDelay = &duration
+		} else {
+			s.TimeOut = &duration
+		}
+	case "proxy":
+		if value != "" {
+			if _, err := proxypool.Parse(value); err != nil {
+				return err
+			}
+		}
+		s.Proxy = &value
+	default:
+		return fmt.Errorf("unknown option %q, expected one of: %s", key, strings.Join(SiteOptionKeys, ", "))
+	}
+	return nil
+}
+
+// Values returns the options set for the website, by key
+func (s SiteOptions) Values() map[string]interface{} {
+	values := make(map[string]interface{})
+	if s.Parallel != nil {
+		values["parallel"] = *s.Parallel
+	}
+	if s.RandomDelay != nil {
+		values["delay"] = s.RandomDelay.String()
+	}
+	if s.TimeOut != nil {
+		values["timeout"] = s.TimeOut.String()
+	}
+	if s.Proxy != nil {
+		values["proxy"] = *s.Proxy
+	}
+	return values
+}
+
+// ParseSiteOpts reads the options of websites given with --site-opt,
+// as site.key=value, on top of those already set, eg. by a config file.
+func (o *Options) ParseSiteOpts() error {
+	for _, opt := range o.SiteOpts {
+		site, key, value, err := splitSiteOpt(opt)
+		if err != nil {
+			return err
+		}
+
+		if o.Sites == nil {
+			o.Sites = make(map[string]SiteOptions)
+		}
+		siteOptions := o.Sites[site]
+		if err := siteOptions.Set(key, value); err != nil {
+			return fmt.Errorf("invalid --site-opt %q: %w", opt, err)
+		}
+		o.Sites[site] = siteOptions
+	}
+	return nil
+}
+
+// splitSiteOpt splits an option of a website given as site.key=value
+func splitSiteOpt(opt string) (site, key, value string, err error) {
+	site, option, found := strings.Cut(opt, ".")
+	key, value, hasValue := strings.Cut(option, "=")
+	if !found || !hasValue || site == "" || key == "" {
+		return "", "", "", fmt.Errorf("invalid --site-opt %q, expected site.key=value", opt)
+	}
+	return site, key, value, nil
+}
+
+// ForSite returns the options in effect for a website,
+// with the options set for it overriding the others.
+func (o *Options) ForSite(site string) *Options {
+	effective := *o
+	overrides := o.Sites[site]
+	if overrides.Parallel != nil {
+		effective.Parallel = *overrides.Parallel
+	}
+	if overrides.RandomDelay != nil {
+		effective.RandomDelay = *overrides.RandomDelay
+	}
+	if overrides.TimeOut != nil {
+		effective.TimeOut = *overrides.TimeOut
+	}
+	// A website can use a proxy of its own, or none
+	if overrides.Proxy != nil {
+		effective.Proxies = nil
+		effective.ProxyFile = ""
+		if *overrides.Proxy != "" {
+			effective.Proxies = []string{*overrides.Proxy}
+		}
+	}
+	return &effective
+}
+
+// Redacted returns the options without the passwords of proxies,
+// to be shown or written in reports
+func (o *Options) Redacted() *Options {
+	redacted := *o
+
+	redacted.Proxies = make([]string, 0, len(o.Proxies))
+	for _, proxy := range o.Proxies {
+		redacted.Proxies = append(redacted.Proxies, proxypool.Redact(proxy))
+	}
+
+	redacted.SiteOpts = make([]string, 0, len(o.SiteOpts))
+	for _, opt := range o.SiteOpts {
+		if site, key, value, err := splitSiteOpt(opt); err == nil && key == "proxy" {
+			opt = site + "." + key + "=" + proxypool.Redact(value)
+		}
+		redacted.SiteOpts = append(redacted.SiteOpts, opt)
+	}
+
+	if o.Sites != nil {
+		redacted.Sites = make(map[string]SiteOptions, len(o.Sites))
+		for site, siteOptions := range o.Sites {
+			if siteOptions.Proxy != nil {
+				proxy := proxypool.Redact(*siteOptions.Proxy)
+				siteOptions.Proxy = &proxy
+			}
+			redacted.Sites[site] = siteOptions
+		}
+	}
+
+	return &redacted
+}
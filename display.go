@@ -2,9 +2,10 @@ package main
 
 import (
 	"moviestills/config"
+	"moviestills/proxypool"
+	"moviestills/scraper"
 	"os"
 	"reflect"
-	"sort"
 	"strings"
 
 	"github.com/pterm/pterm"
@@ -12,6 +13,9 @@ import (
 
 // printConfiguration prints configuration as a bullet list.
 func printConfiguration(options *config.Options, websitesToScrape []string) {
+	// Passwords of proxies are not shown
+	options = options.Redacted()
+
 	// Get fields and its values from the config struct
 	values := reflect.ValueOf(*options)
 	fields := values.Type()
@@ -29,8 +33,12 @@ func printConfiguration(options *config.Options, websitesToScrape []string) {
 
 	for i := 0; i < values.NumField(); i++ {
 		fieldName := fields.Field(i).Name
-		// Skip Website and All fields as we're showing them differently
-		if fieldName == "Website" || fieldName == "All" {
+		// Skip Website, All and Sites fields as we're showing them differently
+		if fieldName == "Website" || fieldName == "All" || fieldName == "Sites" {
+			continue
+		}
+		// Skip subcommands, they are not settings
+		if strings.Contains(fields.Field(i).Tag.Get("arg"), "subcommand") {
 			continue
 		}
 		configuration = append(configuration,
@@ -43,30 +51,81 @@ func printConfiguration(options *config.Options, websitesToScrape []string) {
 		)
 	}
 
+	// Add the options in effect for every website
+	for _, website := range websitesToScrape {
+		effective := options.ForSite(website)
+		configuration = append(configuration, pterm.BulletListItem{
+			Level:       0,
+			Text:        pterm.Yellow("Site "+website) + ":",
+			TextStyle:   pterm.NewStyle(pterm.FgBlue),
+			BulletStyle: pterm.NewStyle(pterm.FgRed),
+		})
+		for _, option := range []struct {
+			name  string
+			value interface{}
+		}{
+			{"Parallel", effective.Parallel},
+			{"RandomDelay", effective.RandomDelay},
+			{"TimeOut", effective.TimeOut},
+			{"Proxies", effective.Proxies},
+			{"ProxyFile", effective.ProxyFile},
+		} {
+			configuration = append(configuration, pterm.BulletListItem{
+				Level:       1,
+				Text:        pterm.Yellow(option.name) + ": " + pterm.Blue(option.value),
+				TextStyle:   pterm.NewStyle(pterm.FgBlue),
+				BulletStyle: pterm.NewStyle(pterm.FgRed),
+			})
+		}
+	}
+
 	// Print the configuration as a bullet list
 	if err := pterm.DefaultBulletList.WithItems(configuration).Render(); err != nil {
 		pterm.Error.Println("Could not print configuration", pterm.Red(err))
 	}
 }
 
+// printProxyStats prints how requests went through every proxy, if any
+func printProxyStats(stats []proxypool.Stats) {
+	if len(stats) == 0 {
+		return
+	}
+
+	pterm.DefaultSection.WithLevel(2).Println("Proxies")
+	items := make([]pterm.BulletListItem, 0, len(stats))
+	for _, proxy := range stats {
+		bullet := pterm.NewStyle(pterm.FgGreen)
+		if proxy.Ejections > 0 {
+			bullet = pterm.NewStyle(pterm.FgYellow)
+		}
+		if proxy.Successes == 0 && proxy.Failures > 0 {
+			bullet = pterm.NewStyle(pterm.FgRed)
+		}
+		items = append(items, pterm.BulletListItem{
+			Level: 0,
+			Text: pterm.Sprintf("%s: %s succeeded, %s failed, set aside %s time(s)", proxy.URL,
+				pterm.White(proxy.Successes), pterm.White(proxy.Failures), pterm.White(proxy.Ejections)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: bullet,
+		})
+	}
+
+	if err := pterm.DefaultBulletList.WithItems(items).Render(); err != nil {
+		pterm.Error.Println("Could not print proxies", pterm.Red(err))
+	}
+}
+
 // listAvailableScrapers prints list of available scrapers.
 func listAvailableScrapers() {
 	pterm.DefaultSection.Println("Scrapers available")
 
-	// Get sorted list of scrapers
-	scraperNames := make([]string, 0, len(sites))
-	for name := range sites {
-		scraperNames = append(scraperNames, name)
-	}
-	sort.Strings(scraperNames)
-
-	// Create bullet lists with available scrapers
+	// Create bullet lists with available scrapers, sorted by name
 	availableScrapers := []pterm.BulletListItem{}
-	for _, site := range scraperNames {
+	for _, site := range scraper.Sites() {
 		availableScrapers = append(availableScrapers,
 			pterm.BulletListItem{
 				Level:       0,
-				Text:        pterm.Yellow(site),
+				Text:        pterm.Yellow(site.Name()) + ": " + site.Description() + " " + pterm.Gray("("+site.IndexURL()+")"),
 				TextStyle:   pterm.NewStyle(pterm.FgBlue),
 				BulletStyle: pterm.NewStyle(pterm.FgRed),
 			},
@@ -0,0 +1,234 @@
+// Package hostlimit limits the requests made to every host, whichever
+// website they are made for: how many at once, how many per second, and
+// a random delay before each of them. Websites scraped together share
+// the limits of the hosts they have in common, eg. an image host they
+// all link to.
+package hostlimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"moviestills/utils"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule limits the requests made to every host matching a pattern
+type Rule struct {
+	// Hosts limited, as a pattern where * matches any part of a
+	// host name, eg. *.imgur.com. * matches any host.
+	Pattern string
+
+	// Maximum number of requests at once, 0 for no limit
+	Parallelism int
+
+	// Maximum number of requests per second, 0 for no limit
+	Rate float64
+
+	// Random delay waited before each request, up to this one
+	RandomDelay time.Duration
+}
+
+// Matches tells if the rule applies to a host
+func (r Rule) Matches(host string) bool {
+	matched, err := path.Match(r.Pattern, host)
+	return err == nil && matched
+}
+
+// Match returns the first rule matching a host, if any
+func Match(rules []Rule, host string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(host) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// ParseRule reads a rule written as pattern:key=value,..., with the keys
+// parallel, rate and delay, eg. *.imgur.com:parallel=2,rate=1,delay=2s.
+// Limits left out are taken from the default rule.
+func ParseRule(s string, defaults Rule) (Rule, error) {
+	pattern, limits, found := strings.Cut(s, ":")
+	if !found || pattern == "" || limits == "" {
+		return Rule{}, fmt.Errorf("invalid host limit %q, expected pattern:key=value,... with keys parallel, rate and delay", s)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern in host limit %q: %w", s, err)
+	}
+
+	rule := defaults
+	rule.Pattern = pattern
+	for _, limit := range strings.Split(limits, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(limit), "=")
+
+		var err error
+		switch key {
+		case "parallel":
+			rule.Parallelism, err = strconv.Atoi(value)
+			if err == nil && rule.Parallelism < 0 {
+				err = fmt.Errorf("%d is negative", rule.Parallelism)
+			}
+		case "rate":
+			rule.Rate, err = strconv.ParseFloat(value, 64)
+			if err == nil && rule.Rate < 0 {
+				err = fmt.Errorf("%g is negative", rule.Rate)
+			}
+		case "delay":
+			rule.RandomDelay, err = time.ParseDuration(value)
+			if err == nil && rule.RandomDelay < 0 {
+				err = fmt.Errorf("%s is negative", rule.RandomDelay)
+			}
+		default:
+			err = fmt.Errorf("unknown limit %q, expected parallel, rate or delay", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid host limit %q: %w", s, err)
+		}
+	}
+
+	return rule, nil
+}
+
+// Limiter limits the requests made to every host, following the first
+// rule matching the host. Hosts matching no rule are not limited.
+// Every host has limits of its own, even when matched by a pattern.
+type Limiter struct {
+	rules []Rule
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+// New limits the requests made to hosts following the rules, in order
+func New(rules ...Rule) *Limiter {
+	return &Limiter{rules: rules, hosts: make(map[string]*hostLimiter)}
+}
+
+// hostLimiter keeps track of the requests made to a host
+type hostLimiter struct {
+	rule Rule
+
+	// Slots of requests at once, nil for no limit
+	slots chan struct{}
+
+	// When the next request can be made, for the rate limit
+	mu   sync.Mutex
+	next time.Time
+}
+
+// host returns the limits of a host, nil if not limited
+func (l *Limiter) host(name string) *hostLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if h, found := l.hosts[name]; found {
+		return h
+	}
+
+	var h *hostLimiter
+	if rule, found := Match(l.rules, name); found {
+		h = &hostLimiter{rule: rule}
+		if rule.Parallelism > 0 {
+			h.slots = make(chan struct{}, rule.Parallelism)
+		}
+	}
+	l.hosts[name] = h
+
+	return h
+}
+
+// Wait waits until a request can be made to a host, or the context is
+// done. The request is counted until release is called.
+func (l *Limiter) Wait(ctx context.Context, host string) (release func(), err error) {
+	h := l.host(host)
+	if h == nil {
+		return func() {}, nil
+	}
+
+	release = func() {}
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-h.slots }) }
+	}
+
+	if err := utils.Sleep(ctx, h.reserve()); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// reserve takes the next turn of the host, and returns how long until it
+func (h *hostLimiter) reserve() time.Duration {
+	var delay time.Duration
+	if h.rule.RandomDelay > 0 {
+		delay = rand.N(h.rule.RandomDelay)
+	}
+	if h.rule.Rate <= 0 {
+		return delay
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	turn := now
+	if h.next.After(now) {
+		turn = h.next
+	}
+	h.next = turn.Add(time.Duration(float64(time.Second) / h.rule.Rate))
+
+	return turn.Sub(now) + delay
+}
+
+// Transport limits the requests of the next transport. A request is
+// counted until the body of its response is closed.
+func (l *Limiter) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{limiter: l, next: next}
+}
+
+type transport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Wait(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
+
+// releaseBody releases the slot of a request once its body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
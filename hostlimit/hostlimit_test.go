@@ -0,0 +1,192 @@
+package hostlimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	defaults := Rule{Pattern: "*", Parallelism: 5, RandomDelay: time.Second}
+
+	tests := []struct {
+		value   string
+		want    Rule
+		wantErr bool
+	}{
+		{"*.imgur.com:parallel=2", Rule{Pattern: "*.imgur.com", Parallelism: 2, RandomDelay: time.Second}, false},
+		{"www.dvdbeaver.com:parallel=1,rate=0.5,delay=2s", Rule{Pattern: "www.dvdbeaver.com", Parallelism: 1, Rate: 0.5, RandomDelay: 2 * time.Second}, false},
+		{"example.com: rate=2, delay=0s", Rule{Pattern: "example.com", Parallelism: 5, Rate: 2}, false},
+		{"example.com", Rule{}, true},
+		{":parallel=2", Rule{}, true},
+		{"example.com:", Rule{}, true},
+		{"example.com:parallel=two", Rule{}, true},
+		{"example.com:parallel=-1", Rule{}, true},
+		{"example.com:rate=-1", Rule{}, true},
+		{"example.com:speed=1", Rule{}, true},
+		{"[:parallel=1", Rule{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRule(tt.value, defaults)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRule(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"*", "www.dvdbeaver.com", true},
+		{"*.imgur.com", "i.imgur.com", true},
+		{"*.imgur.com", "imgur.com", false},
+		{"www.dvdbeaver.com", "www.dvdbeaver.com", true},
+		{"www.dvdbeaver.com", "dvdbeaver.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := (Rule{Pattern: tt.pattern}).Matches(tt.host); got != tt.want {
+			t.Errorf("Rule{%q}.Matches(%q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules := []Rule{
+		{Pattern: "*.imgur.com", Parallelism: 2},
+		{Pattern: "i.imgur.com", Parallelism: 1},
+		{Pattern: "*", Parallelism: 5},
+	}
+
+	// The first rule matching a host applies
+	if rule, found := Match(rules, "i.imgur.com"); !found || rule.Parallelism != 2 {
+		t.Errorf("Match(i.imgur.com) = %+v, %v, want the rule of *.imgur.com", rule, found)
+	}
+	if rule, found := Match(rules, "www.dvdbeaver.com"); !found || rule.Parallelism != 5 {
+		t.Errorf("Match(www.dvdbeaver.com) = %+v, %v, want the default rule", rule, found)
+	}
+	if _, found := Match(rules[:2], "www.dvdbeaver.com"); found {
+		t.Error("Match() of a host matching no rule, want none")
+	}
+}
+
+// newServer counts the requests it handles at once, at most
+func newServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var current, most atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server, &most
+}
+
+// getAll gets a URL many times at once, through transports of different websites
+func getAll(t *testing.T, limiter *Limiter, u string, count int) {
+	t.Helper()
+
+	clients := []*http.Client{
+		{Transport: limiter.Transport(http.DefaultTransport)},
+		{Transport: limiter.Transport(http.DefaultTransport)},
+	}
+
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func(client *http.Client) {
+			defer wg.Done()
+			resp, err := client.Get(u)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+}
+
+func TestLimiterParallelism(t *testing.T) {
+	server, most := newServer(t)
+	host := mustHost(t, server.URL)
+
+	getAll(t, New(Rule{Pattern: host, Parallelism: 2}, Rule{Pattern: "*", Parallelism: 10}), server.URL, 10)
+	if got := most.Load(); got > 2 {
+		t.Errorf("Server handled %d requests at once, want at most 2", got)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	server, most := newServer(t)
+
+	getAll(t, New(Rule{Pattern: "example.com", Parallelism: 1}), server.URL, 6)
+	if got := most.Load(); got < 2 {
+		t.Errorf("Server handled %d request at once, want hosts matching no rule not to be limited", got)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	server, _ := newServer(t)
+
+	start := time.Now()
+	getAll(t, New(Rule{Pattern: "*", Rate: 50}), server.URL, 6)
+
+	// The first request is made right away, the others every 20ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("6 requests at 50 per second took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestLimiterCancelled(t *testing.T) {
+	limiter := New(Rule{Pattern: "*", Parallelism: 1})
+
+	release, err := limiter.Wait(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Wait(ctx, "example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Other hosts have limits of their own
+	if _, err := limiter.Wait(context.Background(), "example.org"); err != nil {
+		t.Errorf("Wait() for another host unexpected error: %v", err)
+	}
+}
+
+func mustHost(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname()
+}
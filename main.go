@@ -1,50 +1,64 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"moviestills/config"
 	"moviestills/scraper"
-	"moviestills/websites"
 	"os"
-	"sort"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
-	"github.com/gocolly/colly/v2"
 	"github.com/pterm/pterm"
 )
 
-// ScraperFunc is the function signature for all website scrapers
-type ScraperFunc func(*colly.Collector, *config.Options, *scraper.Stats)
-
-// Available scrapers
-var sites = map[string]ScraperFunc{
-	"blubeaver":        websites.BluBeaverScraper,
-	"blusscreens":      websites.BlusScraper,
-	"dvdbeaver":        websites.DVDBeaverScraper,
-	"evanerichards":    websites.EvanERichardsScraper,
-	"film-grab":        websites.FilmGrabScraper,
-	"highdefdiscnews":  websites.HighDefDiscNewsScraper,
-	"movie-screencaps": websites.ScreenCapsScraper,
-	"screenmusings":    websites.ScreenMusingsScraper,
-	"stillsfrmfilms":   websites.StillsFrmFilmsScraper,
-}
-
 func main() {
 	// Start by cleaning the Terminal Screen
 	clearScreen()
 
-	// Stop program when user is pressing CTRL+C
-	handleShutdown()
+	// Stop scrapers when user is pressing CTRL+C
+	ctx := handleShutdown()
 
-	// Handle arguments passed through the CLI or environment variables
+	// Handle arguments passed through the CLI, environment variables
+	// or a config file, in this order of precedence
 	var options config.Options
-	arg.MustParse(&options)
+	parser := arg.MustParse(&options)
+	origins := resolveOptions(parser, &options)
+	validateOptions(parser, &options)
+
+	// Adjust logging styles and outputs
+	setupLogging(&options)
 
 	// Interface of the app
 	pterm.DefaultHeader.Println("Movie Stills", config.VERSION)
 
-	// Adjust logging styles
-	setupLogging(&options)
+	// Add websites defined in definition files
+	loadDefinitions(&options)
+
+	// Manage the catalog of scraped movies and stills
+	if options.Catalog != nil {
+		runCatalogCommand(&options)
+		return
+	}
+
+	// Manage the cache of scraped pages
+	if options.Cache != nil {
+		runCacheCommand(parser, &options)
+		return
+	}
+
+	// Manage the data directory
+	if options.Data != nil {
+		runDataCommand(parser, &options)
+		return
+	}
+
+	// Check or show the options in effect
+	if options.Config != nil {
+		runConfigCommand(parser, &options, origins)
+		return
+	}
 
 	// Display available scrapers implemented
 	if options.ListScrapers {
@@ -62,41 +76,98 @@ func main() {
 
 	// Validate all specified websites exist
 	for _, website := range websitesToScrape {
-		if _, exists := sites[website]; !exists {
+		if _, exists := scraper.Lookup(website); !exists {
 			pterm.Error.Println("We don't have a scraper for:", pterm.White(website))
 			listAvailableScrapers()
 			os.Exit(1)
 		}
 	}
 
+	// Options of websites we don't have are likely misspelled
+	for website := range options.Sites {
+		if _, exists := scraper.Lookup(website); !exists {
+			pterm.Warning.Println("Options set for an unknown website:", pterm.White(website))
+		}
+	}
+
+	// Websites can't make more requests at once than their hosts allow
+	warnCappedParallel(&options, websitesToScrape)
+
+	// Movies of several websites would share folders and manifests
+	if len(websitesToScrape) > 1 && !scraper.LayoutFor(&options).BySite() {
+		pterm.Warning.Println("The path template doesn't use", pterm.White("{{.Site}}"), "so movies of several websites can share a folder")
+	}
+
 	pterm.DefaultSection.Println("Configuration")
 	printConfiguration(&options, websitesToScrape)
 
 	// Create the necessary directories (cache and data)
 	setupDirectories(&options)
 
+	// Stop scrapers once the maximum duration is reached
+	if options.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.MaxDuration)
+		defer cancel()
+	}
+
+	// Record scraped movies and stills in the catalog
+	recorder, closeCatalog := openCatalog(&options, websitesToScrape)
+
+	// Link images identical to one saved before, for any website
+	linker, closeLinker := openLinker(&options)
+	if linker != nil {
+		recorder = scraper.Recorders{recorder, linker}
+	}
+
+	// Expose metrics of the scrapers while they run
+	m, stopMetrics := serveMetrics(&options)
+
+	// Limit requests to hosts, whichever website they are made for,
+	// following their robots.txt, and rotate them between proxies
+	sh := &shared{
+		recorder: recorder,
+		metrics:  m,
+		limiter:  newHostLimiter(&options),
+		proxies:  newProxyPool(&options),
+		robots:   newRobots(&options),
+	}
+
 	// Run scrapers
 	aggStats := scraper.NewAggregatedStats()
+	started := time.Now()
 
 	if len(websitesToScrape) == 1 || options.Sequential {
-		runSequential(websitesToScrape, &options, aggStats)
+		runSequential(ctx, websitesToScrape, &options, sh, aggStats)
 	} else {
-		runConcurrent(websitesToScrape, &options, aggStats)
+		runConcurrent(ctx, websitesToScrape, &options, sh, aggStats)
 	}
 
+	closeCatalog()
+	closeLinker()
+	stopMetrics()
+
 	// Print final summary
-	pterm.Info.Println("Finished scraping", pterm.White(len(websitesToScrape)), "website(s)")
+	if ctx.Err() != nil {
+		pterm.Warning.Println("Scraping was stopped before the end, use", pterm.Blue("--resume"), "to pick it up later")
+	} else {
+		pterm.Info.Println("Finished scraping", pterm.White(len(websitesToScrape)), "website(s)")
+	}
 	scraper.PrintAggregatedSummary(aggStats)
+	printProxyStats(sh.proxies.Stats())
+
+	// Let scripts know how the run went
+	writeReports(&options, aggStats, started, ctx.Err() != nil)
+
+	// Let scripts know we were interrupted
+	if errors.Is(ctx.Err(), context.Canceled) {
+		os.Exit(130)
+	}
 }
 
 func determineWebsites(options *config.Options) []string {
 	if options.All {
-		all := make([]string, 0, len(sites))
-		for name := range sites {
-			all = append(all, name)
-		}
-		sort.Strings(all)
-		return all
+		return scraper.Names()
 	}
 
 	// Normalize and deduplicate website list
@@ -0,0 +1,297 @@
+// Package metrics exposes how scraping goes as Prometheus metrics, in the
+// text format: the stats of every website, and the requests made by their
+// collectors, followed through the events of the collector debugger, which
+// clones of a collector share.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"moviestills/scraper"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2/debug"
+)
+
+// LatencyBuckets are the upper bounds of the response latency histograms,
+// in seconds. Images of some websites take a while to download.
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Label of the status code of requests failing without response
+const noResponse = "none"
+
+// Metrics of the websites being scraped, served over HTTP.
+// Methods do nothing on a nil Metrics.
+type Metrics struct {
+	mu sync.Mutex
+
+	// Stats of the websites scraped so far
+	stats map[string]*scraper.Stats
+
+	// Number of requests, by website, domain and status code
+	requests map[requestKey]int64
+
+	// Requests waiting for their response, by website
+	inFlight map[string]int64
+
+	// Response latencies, by website
+	latency map[string]*histogram
+
+	// When requests in flight were sent, by collector and request
+	pending map[pendingKey]time.Time
+}
+
+type requestKey struct {
+	site   string
+	domain string
+	code   string
+}
+
+type pendingKey struct {
+	collector uint32
+	request   uint32
+}
+
+// New creates metrics without any website yet
+func New() *Metrics {
+	return &Metrics{
+		stats:    make(map[string]*scraper.Stats),
+		requests: make(map[requestKey]int64),
+		inFlight: make(map[string]int64),
+		latency:  make(map[string]*histogram),
+		pending:  make(map[pendingKey]time.Time),
+	}
+}
+
+// Track exposes the stats of a website, while and after it's scraped
+func (m *Metrics) Track(stats *scraper.Stats) {
+	if m == nil || stats == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats[stats.Website] = stats
+}
+
+// Debugger follows the requests made by the collectors of a website.
+// Events are passed on to the next debugger, if not nil, eg. to print them.
+func (m *Metrics) Debugger(site string, next debug.Debugger) debug.Debugger {
+	if m == nil {
+		return next
+	}
+	return &collectorDebugger{metrics: m, site: site, next: next}
+}
+
+// collectorDebugger measures requests from the events of a collector
+type collectorDebugger struct {
+	metrics *Metrics
+	site    string
+	next    debug.Debugger
+}
+
+// Init initializes the next debugger
+func (d *collectorDebugger) Init() error {
+	if d.next == nil {
+		return nil
+	}
+	return d.next.Init()
+}
+
+// Event receives the events of the collector and its clones
+func (d *collectorDebugger) Event(e *debug.Event) {
+	key := pendingKey{collector: e.CollectorID, request: e.RequestID}
+
+	switch e.Type {
+	case "requestHeaders":
+		// Sent once allowed by the limits, and not aborted before
+		d.metrics.sent(d.site, key)
+	case "response", "error":
+		d.metrics.received(d.site, key, e.Values["url"], e.Values["status"])
+	}
+
+	if d.next != nil {
+		d.next.Event(e)
+	}
+}
+
+// sent counts a request in flight
+func (m *Metrics) sent(site string, key pendingKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[key] = time.Now()
+	m.inFlight[site]++
+}
+
+// received counts a request done, once. Requests can fail after their
+// response, eg. when their page can't be parsed.
+func (m *Metrics) received(site string, key pendingKey, requestURL, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start, found := m.pending[key]
+	if !found {
+		return
+	}
+	delete(m.pending, key)
+	m.inFlight[site]--
+
+	var domain string
+	if u, err := url.Parse(requestURL); err == nil {
+		domain = u.Hostname()
+	}
+	m.requests[requestKey{site: site, domain: domain, code: statusCode(status)}]++
+
+	if _, found := m.latency[site]; !found {
+		m.latency[site] = newHistogram(LatencyBuckets)
+	}
+	m.latency[site].observe(time.Since(start).Seconds())
+}
+
+// Colly only tells the text of HTTP status in its events
+var statusCodes = func() map[string]string {
+	codes := make(map[string]string)
+	for code := 100; code < 600; code++ {
+		if text := http.StatusText(code); text != "" {
+			codes[text] = strconv.Itoa(code)
+		}
+	}
+	return codes
+}()
+
+// statusCode is the label of the status code of a response
+func statusCode(status string) string {
+	if code, found := statusCodes[status]; found {
+		return code
+	}
+	return noResponse
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text format
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	sites := sortedKeys(m.stats)
+	counters := []struct {
+		name, help string
+		value      func(*scraper.Stats) int64
+	}{
+		{"moviestills_movies_found_total", "Movies found on the website.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.MoviesFound) }},
+		{"moviestills_images_downloaded_total", "Images downloaded and saved.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.ImagesDownloaded) }},
+		{"moviestills_images_failed_total", "Images that failed to download or were rejected.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.ImagesFailed) }},
+		{"moviestills_images_skipped_total", "Images skipped as already saved.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.ImagesSkipped) }},
+		{"moviestills_images_duplicated_total", "Near-duplicate images found.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.ImagesDuplicated) }},
+		{"moviestills_retries_total", "Requests retried after failing for a transient reason.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.Retries) }},
+		{"moviestills_robots_disallowed_total", "Requests skipped as disallowed by robots.txt.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.RobotsDisallowed) }},
+		{"moviestills_bytes_downloaded_total", "Size of the responses received, pages and images.", func(s *scraper.Stats) int64 { return atomic.LoadInt64(&s.BytesDownloaded) }},
+	}
+	for _, counter := range counters {
+		writeHeader(&b, counter.name, counter.help, "counter")
+		for _, site := range sites {
+			fmt.Fprintf(&b, "%s{site=%s} %d\n", counter.name, quote(site), counter.value(m.stats[site]))
+		}
+	}
+
+	requests := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.site != b.site {
+			return a.site < b.site
+		}
+		if a.domain != b.domain {
+			return a.domain < b.domain
+		}
+		return a.code < b.code
+	})
+	writeHeader(&b, "moviestills_requests_total", "Requests made, by domain and status code.", "counter")
+	for _, key := range requests {
+		fmt.Fprintf(&b, "moviestills_requests_total{site=%s,domain=%s,code=%s} %d\n", quote(key.site), quote(key.domain), quote(key.code), m.requests[key])
+	}
+
+	writeHeader(&b, "moviestills_requests_in_flight", "Requests waiting for their response.", "gauge")
+	for _, site := range sortedKeys(m.inFlight) {
+		fmt.Fprintf(&b, "moviestills_requests_in_flight{site=%s} %d\n", quote(site), m.inFlight[site])
+	}
+
+	writeHeader(&b, "moviestills_response_duration_seconds", "How long it took to get responses.", "histogram")
+	for _, site := range sortedKeys(m.latency) {
+		m.latency[site].write(&b, "moviestills_response_duration_seconds", fmt.Sprintf("site=%s", quote(site)))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeHeader describes a metric
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Label values only escape backslashes, quotes and line feeds
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote quotes a label value
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// sortedKeys returns the keys of a map by website, sorted
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// histogram counts observations in cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+// observe adds an observation
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// write writes the buckets, sum and count of the histogram
+func (h *histogram) write(b *strings.Builder, name, labels string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(b, "%s_bucket{%s,le=%s} %d\n", name, labels, quote(strconv.FormatFloat(bound, 'g', -1, 64)), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(b, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+}
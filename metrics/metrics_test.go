@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"io"
+	"moviestills/scraper"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, `<html><body><a href="/movie.html">Movie</a><a href="/missing.html">Gone</a></body></html>`)
+		case "/movie.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body>Stills</body></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	m := New()
+	stats := &scraper.Stats{Website: "site", MoviesFound: 2, ImagesDownloaded: 3, BytesDownloaded: 1024}
+	m.Track(stats)
+
+	// Clones share the debugger, not the callbacks
+	c := colly.NewCollector()
+	c.SetDebugger(m.Debugger("site", nil))
+	movies := c.Clone()
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		_ = movies.Visit(e.Request.AbsoluteURL(e.Attr("href")))
+	})
+
+	// Requests aborted before being sent are not counted
+	movies.OnRequest(func(r *colly.Request) {
+		if strings.HasSuffix(r.URL.Path, "skipped.html") {
+			r.Abort()
+		}
+	})
+	if err := c.Visit(server.URL + "/index.html"); err != nil {
+		t.Fatal(err)
+	}
+	_ = movies.Visit(server.URL + "/skipped.html")
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	output := b.String()
+
+	domain := serverURL.Hostname()
+	for _, want := range []string{
+		`# TYPE moviestills_movies_found_total counter`,
+		`moviestills_movies_found_total{site="site"} 2`,
+		`moviestills_images_downloaded_total{site="site"} 3`,
+		`moviestills_images_failed_total{site="site"} 0`,
+		`moviestills_bytes_downloaded_total{site="site"} 1024`,
+		`moviestills_requests_total{site="site",domain="` + domain + `",code="200"} 2`,
+		`moviestills_requests_total{site="site",domain="` + domain + `",code="404"} 1`,
+		`moviestills_requests_in_flight{site="site"} 0`,
+		`# TYPE moviestills_response_duration_seconds histogram`,
+		`moviestills_response_duration_seconds_bucket{site="site",le="+Inf"} 3`,
+		`moviestills_response_duration_seconds_count{site="site"} 3`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Metrics miss %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"OK", "200"},
+		{"Not Found", "404"},
+		{"Too Many Requests", "429"},
+		{"", noResponse},
+	}
+
+	for _, tt := range tests {
+		if got := statusCode(tt.status); got != tt.want {
+			t.Errorf("statusCode(%q) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got, want := quote("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Errorf("quote() = %s, want %s", got, want)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Track(&scraper.Stats{Website: "site"})
+	if m.Debugger("site", nil) != nil {
+		t.Error("Debugger() of nil metrics should be the next debugger")
+	}
+}
@@ -0,0 +1,43 @@
+package pagecache
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Cache entries are named after the SHA-1 hash of their URL
+var entryName = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// StripImages removes the images cached by older versions, when the
+// whole responses of websites were cached. Returns the number of
+// entries removed and the number of bytes freed.
+func StripImages(dir string) (int, int64, error) {
+	removed, freed := 0, int64(0)
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !entryName.MatchString(d.Name()) {
+			return err
+		}
+
+		// Files that are not cache entries are left alone
+		entry, err := Load(path)
+		if err != nil || !IsImage(*entry.Headers) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		removed++
+		freed += info.Size()
+		return nil
+	})
+
+	return removed, freed, err
+}
@@ -0,0 +1,238 @@
+// Package pagecache caches the pages of websites being scraped, but not
+// their images, which are saved in the data directory anyway. Entries are
+// stored the same way Colly does, so existing cache folders can be reused.
+// As Colly does, the age of a cached page is the age of its file.
+package pagecache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// KindHeader is set on requests by scrapers to tell the kind of page
+// requested, KindIndex or KindMovie. It is removed before sending them.
+const KindHeader = "X-Moviestills-Page"
+
+// Kinds of pages, cached for different durations
+const (
+	KindIndex = "index"
+	KindMovie = "movie"
+)
+
+// Policy tells how long cached pages are fresh, by kind of page.
+// Pages that are not fresh anymore are revalidated with the website.
+// A TTL of zero means pages never expire.
+type Policy struct {
+	// Index and listing pages, finding movies
+	IndexTTL time.Duration
+
+	// Movie pages, and any page requested without kind
+	MovieTTL time.Duration
+
+	// Refresh index pages whatever their age
+	Refresh bool
+}
+
+// Transport is an HTTP transport caching pages in a directory.
+// Images are never cached, they go straight to the scraper.
+type Transport struct {
+	dir    string
+	next   http.RoundTripper
+	policy Policy
+}
+
+// New creates a transport caching pages in dir according to the
+// policy, making requests that are not cached with the next transport.
+func New(dir string, next http.RoundTripper, policy Policy) *Transport {
+	return &Transport{dir: dir, next: next, policy: policy}
+}
+
+// RoundTrip returns the cached page for the request, if fresh.
+// Otherwise, the page is requested, or revalidated, and cached.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind := req.Header.Get(KindHeader)
+	if kind != "" {
+		req = req.Clone(req.Context())
+		req.Header.Del(KindHeader)
+	}
+
+	if req.Method != http.MethodGet || req.Header.Get("Cache-Control") == "no-cache" {
+		return t.next.RoundTrip(req)
+	}
+
+	path := EntryPath(t.dir, req.URL.String())
+	entry, err := Load(path)
+	if err != nil || entry.StatusCode >= 500 {
+		return t.fetch(req, path)
+	}
+
+	if t.isFresh(path, kind) {
+		return response(req, entry), nil
+	}
+
+	return t.revalidate(req, path, entry)
+}
+
+// isFresh tells if a cached page can be used without asking the website
+func (t *Transport) isFresh(path, kind string) bool {
+	ttl := t.policy.MovieTTL
+	if kind == KindIndex {
+		if t.policy.Refresh {
+			return false
+		}
+		ttl = t.policy.IndexTTL
+	}
+	if ttl <= 0 {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) <= ttl
+}
+
+// revalidate asks the website if a cached page changed, using its
+// ETag and Last-Modified headers. The cached page is kept if not.
+func (t *Transport) revalidate(req *http.Request, path string, entry *colly.Response) (*http.Response, error) {
+	etag, lastModified := entry.Headers.Get("ETag"), entry.Headers.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return t.fetch(req, path)
+	}
+
+	conditional := req.Clone(req.Context())
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := t.next.RoundTrip(conditional)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return t.store(req, path, resp)
+	}
+	_ = resp.Body.Close()
+
+	// The cached page is fresh again
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, fmt.Errorf("can't refresh cached page: %w", err)
+	}
+
+	return response(req, entry), nil
+}
+
+// fetch requests a page and caches it
+func (t *Transport) fetch(req *http.Request, path string) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(req, path, resp)
+}
+
+// store caches the response of a page. Images and
+// server errors are returned as is, without caching them.
+func (t *Transport) store(req *http.Request, path string, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode >= 500 || IsImage(resp.Header) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry := &colly.Response{StatusCode: resp.StatusCode, Body: body, Headers: &resp.Header}
+	if err := Store(path, entry); err != nil {
+		return nil, fmt.Errorf("can't cache page: %w", err)
+	}
+
+	return resp, nil
+}
+
+// IsImage tells if a response is an image, not to be cached
+func IsImage(header http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(header.Get("Content-Type")), "image/")
+}
+
+// EntryPath is where the page of an URL is cached, as Colly does
+func EntryPath(dir, rawURL string) string {
+	sum := sha1.Sum([]byte(rawURL))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, hash[:2], hash)
+}
+
+// Load reads a cached page
+func Load(path string) (*colly.Response, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entry := new(colly.Response)
+	if err := gob.NewDecoder(file).Decode(entry); err != nil {
+		return nil, err
+	}
+	if entry.Headers == nil {
+		entry.Headers = &http.Header{}
+	}
+
+	return entry, nil
+}
+
+// Store caches a page. The file is written under a temporary name of
+// its own and replaces the previous one once written, so a page is
+// never cached half-written, even when stored concurrently.
+func Store(path string, entry *colly.Response) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	if err := gob.NewEncoder(file).Encode(entry); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// response rebuilds the HTTP response of a cached page
+func response(req *http.Request, entry *colly.Response) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.StatusCode, http.StatusText(entry.StatusCode)),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Headers.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}
+}
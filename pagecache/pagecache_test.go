@@ -0,0 +1,284 @@
+package pagecache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// newServer serves a page and an image, counting the requests received
+func newServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body>Movies</body></html>")
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = io.WriteString(w, "not really an image")
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, hits
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s unexpected error: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTransport(t *testing.T) {
+	server, hits := newServer(t)
+	client := &http.Client{Transport: New(t.TempDir(), http.DefaultTransport, Policy{})}
+
+	for i := 0; i < 3; i++ {
+		if body := get(t, client, server.URL+"/page.html"); body != "<html><body>Movies</body></html>" {
+			t.Errorf("Page body is wrong: %s", body)
+		}
+		if body := get(t, client, server.URL+"/image.jpg"); body != "not really an image" {
+			t.Errorf("Image body is wrong: %s", body)
+		}
+		get(t, client, server.URL+"/error")
+	}
+
+	// Only pages are cached, server errors are not
+	expected := map[string]int{"/page.html": 1, "/image.jpg": 3, "/error": 3}
+	for path, count := range expected {
+		if hits[path] != count {
+			t.Errorf("%s requested %d times, expected %d", path, hits[path], count)
+		}
+	}
+}
+
+// Cache folders created by Colly are still used, and images
+// cached in them by older versions are removed by the migration.
+func TestCollyCache(t *testing.T) {
+	server, hits := newServer(t)
+	dir := t.TempDir()
+
+	c := colly.NewCollector(colly.CacheDir(dir))
+	for _, path := range []string{"/page.html", "/image.jpg"} {
+		if err := c.Visit(server.URL + path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, freed, err := StripImages(dir)
+	if err != nil {
+		t.Fatalf("StripImages() unexpected error: %v", err)
+	}
+	if removed != 1 || freed == 0 {
+		t.Errorf("StripImages() removed %d entries, freeing %d bytes, expected 1 image", removed, freed)
+	}
+
+	client := &http.Client{Transport: New(dir, http.DefaultTransport, Policy{})}
+	if body := get(t, client, server.URL+"/page.html"); body != "<html><body>Movies</body></html>" {
+		t.Errorf("Page cached by Colly is wrong: %s", body)
+	}
+	get(t, client, server.URL+"/image.jpg")
+
+	if hits["/page.html"] != 1 || hits["/image.jpg"] != 2 {
+		t.Errorf("Page cached by Colly was requested again: %v", hits)
+	}
+
+	// Nothing left to remove
+	if removed, _, _ := StripImages(dir); removed != 0 {
+		t.Errorf("StripImages() removed %d entries the second time", removed)
+	}
+}
+
+// revalidationServer serves a page with an ETag and a Last-Modified
+// date, which can be changed, and honors conditional requests.
+type revalidationServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	version     string
+	requests    int
+	notModified int
+}
+
+func newRevalidationServer(t *testing.T) *revalidationServer {
+	s := &revalidationServer{version: "v1"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+
+		if r.Header.Get(KindHeader) != "" {
+			t.Errorf("Kind of page sent to the website: %s", r.Header.Get(KindHeader))
+		}
+
+		etag := `"` + s.version + `"`
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") != "" {
+			s.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		_, _ = io.WriteString(w, s.version)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// getKind requests a page of the given kind
+func getKind(t *testing.T, client *http.Client, url, kind string) string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(KindHeader, kind)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s unexpected error: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// age makes a cached page older
+func age(t *testing.T, dir, url string, d time.Duration) {
+	t.Helper()
+	old := time.Now().Add(-d)
+	if err := os.Chtimes(EntryPath(dir, url), old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	server := newRevalidationServer(t)
+	dir := t.TempDir()
+	client := &http.Client{Transport: New(dir, http.DefaultTransport, Policy{IndexTTL: time.Hour})}
+	index, movie := server.URL+"/index", server.URL+"/movie"
+
+	getKind(t, client, index, KindIndex)
+	getKind(t, client, movie, KindMovie)
+	age(t, dir, index, 2*time.Hour)
+	age(t, dir, movie, 48*time.Hour)
+
+	// Movie pages never expire, the index page is revalidated
+	// once and is fresh again afterwards.
+	for i := 0; i < 2; i++ {
+		if body := getKind(t, client, index, KindIndex); body != "v1" {
+			t.Errorf("Index page is %s, expected v1", body)
+		}
+		if body := getKind(t, client, movie, KindMovie); body != "v1" {
+			t.Errorf("Movie page is %s, expected v1", body)
+		}
+	}
+	if server.requests != 3 || server.notModified != 1 {
+		t.Errorf("Website got %d requests, %d not modified, expected 3 and 1", server.requests, server.notModified)
+	}
+
+	// A page that changed is cached again
+	server.version = "v2"
+	age(t, dir, index, 2*time.Hour)
+	for i := 0; i < 2; i++ {
+		if body := getKind(t, client, index, KindIndex); body != "v2" {
+			t.Errorf("Index page is %s, expected v2", body)
+		}
+	}
+	if server.requests != 4 {
+		t.Errorf("Website got %d requests, expected 4", server.requests)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	server := newRevalidationServer(t)
+	dir := t.TempDir()
+	index, movie := server.URL+"/index", server.URL+"/movie"
+
+	client := &http.Client{Transport: New(dir, http.DefaultTransport, Policy{})}
+	getKind(t, client, index, KindIndex)
+	getKind(t, client, movie, KindMovie)
+	server.version = "v2"
+
+	// Only index pages are refreshed
+	client = &http.Client{Transport: New(dir, http.DefaultTransport, Policy{Refresh: true})}
+	if body := getKind(t, client, index, KindIndex); body != "v2" {
+		t.Errorf("Index page is %s, expected v2", body)
+	}
+	if body := getKind(t, client, movie, KindMovie); body != "v1" {
+		t.Errorf("Movie page is %s, expected v1", body)
+	}
+	if server.requests != 3 {
+		t.Errorf("Website got %d requests, expected 3", server.requests)
+	}
+}
+
+// Pages stored concurrently are never cached half-written
+func TestStoreConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	path := EntryPath(dir, "https://example.com/")
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := []byte(strings.Repeat("page", 1000*(i+1)))
+			if err := Store(path, &colly.Response{StatusCode: 200, Body: body, Headers: &http.Header{}}); err != nil {
+				t.Errorf("Store() unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entry, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if len(entry.Body)%4000 != 0 || strings.Trim(string(entry.Body), "page") != "" {
+		t.Errorf("Page cached half-written: %d bytes", len(entry.Body))
+	}
+
+	// Temporary files are all gone
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Cache folder holds %d files, want the page only", len(files))
+	}
+}
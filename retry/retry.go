@@ -0,0 +1,253 @@
+// Package retry retries requests failing for a transient reason, such as
+// a timeout, a reset connection or a website asking to slow down, with an
+// exponential backoff. Websites telling when to come back with Retry-After
+// are waited for. Requests time out by attempt, not for all of them.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"moviestills/utils"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Classes of errors, retried or not depending on the policy
+const (
+	// Requests that timed out, or responses 408 Request Timeout
+	Timeout = "timeout"
+
+	// Connections refused, reset or closed before the end of a response
+	Connection = "connection"
+
+	// Responses 429 Too Many Requests and 503 Service Unavailable
+	Throttled = "throttled"
+
+	// Responses 500 Internal Server Error, 502 Bad Gateway and 504 Gateway Timeout
+	Server = "server"
+
+	// Other error responses, such as 404 Not Found
+	Client = "client"
+
+	// Any other error, eg. an unknown host or an invalid certificate
+	Other = "other"
+)
+
+// Classes lists every class of errors
+var Classes = []string{Timeout, Connection, Throttled, Server, Client, Other}
+
+// DefaultClasses are the classes of errors retried by default
+var DefaultClasses = []string{Timeout, Connection, Throttled, Server}
+
+// Body of failed responses read before retrying, so connections can be reused
+const drainLimit = 64 << 10
+
+// Policy tells which requests are retried, and when
+type Policy struct {
+	// How many times requests are tried, 1 to never retry them
+	MaxAttempts int
+
+	// How long each attempt can take, 0 for no limit
+	Timeout time.Duration
+
+	// Delay before the first retry, doubled for every other retry,
+	// up to MaxDelay. Websites asking for a longer delay with
+	// Retry-After than MaxDelay are not retried.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Classes of errors retried, DefaultClasses if nil
+	Classes []string
+}
+
+// retries tells if errors of a class are retried
+func (p Policy) retries(class string) bool {
+	classes := p.Classes
+	if classes == nil {
+		classes = DefaultClasses
+	}
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff is the delay before retrying after the given attempt: an
+// exponential delay, of which a random half is left out so clients
+// failing together don't retry together.
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Retry describes a request about to be retried
+type Retry struct {
+	Request *http.Request
+
+	// Attempt that failed, from 1
+	Attempt int
+
+	// Why it failed: the class of error, the HTTP status of the
+	// response if any, or the error
+	Class  string
+	Status int
+	Err    error
+
+	// How long until the next attempt
+	Delay time.Duration
+}
+
+// Transport retries the requests of the next transport following the
+// policy. Requests with a body that can't be read again are not retried.
+type Transport struct {
+	next   http.RoundTripper
+	policy Policy
+
+	// OnRetry is called before waiting for every retry, if not nil
+	OnRetry func(Retry)
+}
+
+// New retries the requests of the next transport following the policy
+func New(next http.RoundTripper, policy Policy) *Transport {
+	return &Transport{next: next, policy: policy}
+}
+
+// RoundTrip sends a request until it succeeds, fails for good, or the
+// policy gives up on it. The last response or error is returned then.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+
+		// Requests cancelled by the client, eg. on shutdown, are over
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+
+		class, retryAfter := Classify(resp, err)
+		if class == "" || !t.policy.retries(class) || attempt >= t.policy.MaxAttempts {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := t.policy.Backoff(attempt)
+		if retryAfter > 0 {
+			if retryAfter > t.policy.MaxDelay {
+				return resp, err
+			}
+			delay = retryAfter
+		}
+
+		retry := Retry{Request: req, Attempt: attempt, Class: class, Err: err, Delay: delay}
+		if resp != nil {
+			retry.Status = resp.StatusCode
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, drainLimit))
+			_ = resp.Body.Close()
+		}
+		if t.OnRetry != nil {
+			t.OnRetry(retry)
+		}
+
+		if err := utils.Sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// attempt sends a request once, within the timeout of an attempt.
+// The timeout covers reading the body of the response too.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	if t.policy.Timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.policy.Timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelBody ends the attempt of a response once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Classify tells the class of error of a failed request, and how long
+// the website asked to wait before retrying, if it did. The class is
+// empty when the request succeeded.
+func Classify(resp *http.Response, err error) (string, time.Duration) {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			return Timeout, 0
+		case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE),
+			errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return Connection, 0
+		}
+		return Other, 0
+	}
+
+	switch status := resp.StatusCode; {
+	case status < 400:
+		return "", 0
+	case status == http.StatusTooManyRequests, status == http.StatusServiceUnavailable:
+		return Throttled, RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case status == http.StatusRequestTimeout:
+		return Timeout, 0
+	case status == http.StatusInternalServerError, status == http.StatusBadGateway, status == http.StatusGatewayTimeout:
+		return Server, 0
+	default:
+		return Client, 0
+	}
+}
+
+// RetryAfter parses the value of a Retry-After header, a number of
+// seconds or a date, into a delay from now. Returns 0 if missing or
+// invalid.
+func RetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now))
+	}
+	return 0
+}
@@ -0,0 +1,273 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newServer answers with the given handlers in turn, the last one
+// answering every other request, and counts the requests received
+func newServer(t *testing.T, handlers ...http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(hits.Add(1)) - 1
+		handlers[min(i, len(handlers)-1)](w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func status(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+		_, _ = io.WriteString(w, http.StatusText(code))
+	}
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(w, r.Body)
+}
+
+func sleep(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}
+}
+
+var policy = Policy{MaxAttempts: 3, Timeout: time.Second, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		policy   Policy
+		status   int
+		hits     int32
+		retries  []string
+	}{
+		{
+			name:     "success",
+			handlers: []http.HandlerFunc{status(http.StatusOK)},
+			status:   http.StatusOK,
+			hits:     1,
+		},
+		{
+			name:     "not found is never retried",
+			handlers: []http.HandlerFunc{status(http.StatusNotFound)},
+			status:   http.StatusNotFound,
+			hits:     1,
+		},
+		{
+			name:     "service unavailable then success",
+			handlers: []http.HandlerFunc{status(http.StatusServiceUnavailable, "Retry-After", "0"), status(http.StatusOK)},
+			status:   http.StatusOK,
+			hits:     2,
+			retries:  []string{Throttled},
+		},
+		{
+			name:     "server errors until the last attempt",
+			handlers: []http.HandlerFunc{status(http.StatusBadGateway)},
+			status:   http.StatusBadGateway,
+			hits:     3,
+			retries:  []string{Server, Server},
+		},
+		{
+			name:     "retries disabled",
+			handlers: []http.HandlerFunc{status(http.StatusBadGateway)},
+			policy:   Policy{MaxAttempts: 1},
+			status:   http.StatusBadGateway,
+			hits:     1,
+		},
+		{
+			name:     "waiting longer than the maximum delay",
+			handlers: []http.HandlerFunc{status(http.StatusTooManyRequests, "Retry-After", "3600"), status(http.StatusOK)},
+			status:   http.StatusTooManyRequests,
+			hits:     1,
+		},
+		{
+			name:     "timeout then success",
+			handlers: []http.HandlerFunc{sleep(time.Second), status(http.StatusOK)},
+			policy:   Policy{MaxAttempts: 3, Timeout: 50 * time.Millisecond, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			status:   http.StatusOK,
+			hits:     2,
+			retries:  []string{Timeout},
+		},
+		{
+			name:     "classes not retried",
+			handlers: []http.HandlerFunc{status(http.StatusBadGateway), status(http.StatusOK)},
+			policy:   Policy{MaxAttempts: 3, Classes: []string{Timeout}},
+			status:   http.StatusBadGateway,
+			hits:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, hits := newServer(t, tt.handlers...)
+			p := tt.policy
+			if p.MaxAttempts == 0 {
+				p = policy
+			}
+
+			var retries []string
+			transport := New(http.DefaultTransport, p)
+			transport.OnRetry = func(r Retry) {
+				retries = append(retries, r.Class)
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.status || string(body) != http.StatusText(tt.status) {
+				t.Errorf("Get() = %d %q, want %d", resp.StatusCode, body, tt.status)
+			}
+			if got := hits.Load(); got != tt.hits {
+				t.Errorf("Server got %d requests, want %d", got, tt.hits)
+			}
+			if strings.Join(retries, ",") != strings.Join(tt.retries, ",") {
+				t.Errorf("Retries = %v, want %v", retries, tt.retries)
+			}
+		})
+	}
+}
+
+func TestTransportBody(t *testing.T) {
+	server, hits := newServer(t, status(http.StatusServiceUnavailable), echo)
+
+	resp, err := (&http.Client{Transport: New(http.DefaultTransport, policy)}).Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "body" || hits.Load() != 2 {
+		t.Errorf("Post() = %q after %d requests, want the body sent again", body, hits.Load())
+	}
+}
+
+func TestTransportCancelled(t *testing.T) {
+	server, hits := newServer(t, status(http.StatusServiceUnavailable, "Retry-After", "1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := New(http.DefaultTransport, Policy{MaxAttempts: 3, MaxDelay: time.Minute})
+	transport.OnRetry = func(Retry) { cancel() }
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip() error = %v, want %v", err, context.Canceled)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("Server got %d requests, want 1", hits.Load())
+	}
+}
+
+func TestClassify(t *testing.T) {
+	response := func(code int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: code, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		resp       *http.Response
+		err        error
+		class      string
+		retryAfter time.Duration
+	}{
+		{"ok", response(http.StatusOK, ""), nil, "", 0},
+		{"redirect", response(http.StatusNotModified, ""), nil, "", 0},
+		{"not found", response(http.StatusNotFound, ""), nil, Client, 0},
+		{"too many requests", response(http.StatusTooManyRequests, "120"), nil, Throttled, 2 * time.Minute},
+		{"service unavailable", response(http.StatusServiceUnavailable, ""), nil, Throttled, 0},
+		{"request timeout", response(http.StatusRequestTimeout, ""), nil, Timeout, 0},
+		{"bad gateway", response(http.StatusBadGateway, ""), nil, Server, 0},
+		{"not implemented", response(http.StatusNotImplemented, ""), nil, Client, 0},
+		{"deadline", nil, context.DeadlineExceeded, Timeout, 0},
+		{"connection reset", nil, &wrappedError{syscall.ECONNRESET}, Connection, 0},
+		{"unexpected EOF", nil, io.ErrUnexpectedEOF, Connection, 0},
+		{"other", nil, errors.New("x509: certificate signed by unknown authority"), Other, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, retryAfter := Classify(tt.resp, tt.err)
+			if class != tt.class || retryAfter != tt.retryAfter {
+				t.Errorf("Classify() = %q, %v, want %q, %v", class, retryAfter, tt.class, tt.retryAfter)
+			}
+		})
+	}
+}
+
+type wrappedError struct{ err error }
+
+func (e *wrappedError) Error() string { return "read: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{"soon", 0},
+		{"Mon, 01 Jan 2024 12:01:30 GMT", 90 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("RetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 5 * time.Second, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			if got := p.Backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("Backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
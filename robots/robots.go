@@ -0,0 +1,210 @@
+// Package robots follows the robots.txt files of hosts: requests to
+// paths they disallow are not made, and requests to a host are spaced
+// by its crawl delay. Robots.txt files are fetched once, when the first
+// request is made to a host, and shared by all the websites scraped.
+package robots
+
+import (
+	"errors"
+	"io"
+	"moviestills/utils"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+// UserAgent is the name we go by in robots.txt files. Groups of rules
+// for other user agents are left out, in favor of those for any.
+const UserAgent = "moviestills"
+
+// Identify is the User-Agent header of requests following robots.txt
+// files. It starts with our name, so hosts can tell us apart and the
+// rules meant for us apply to what we request.
+func Identify(version string) string {
+	return UserAgent + "/" + version + " (+https://github.com/kinoute/moviestills)"
+}
+
+// Robots.txt files bigger than this are cut
+const maxSize = 512 << 10
+
+// ErrDisallowed is returned for requests disallowed by robots.txt files
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// Robots holds the robots.txt files of hosts
+type Robots struct {
+	userAgent string
+
+	// OnUnreachable is called when the robots.txt of a host can't be
+	// read, everything being allowed then, if set
+	OnUnreachable func(host string, err error)
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// New follows the robots.txt files of hosts for a user agent, sent
+// when reading them and matched with their groups of rules
+func New(userAgent string) *Robots {
+	return &Robots{userAgent: userAgent, hosts: make(map[string]*host)}
+}
+
+// host holds the rules of a host for our user agent
+type host struct {
+	// Closed once the robots.txt of the host is read
+	ready chan struct{}
+
+	// Whether the request reading the robots.txt was stopped before,
+	// so it is read again for the next one
+	stopped bool
+
+	// Rules of the host, nil when everything is allowed
+	group *robotstxt.Group
+
+	// When the next request can be made, for the crawl delay
+	mu   sync.Mutex
+	next time.Time
+}
+
+// host returns the rules of the host of a request, reading its
+// robots.txt through a transport first if needed
+func (r *Robots) host(req *http.Request, transport http.RoundTripper) (*host, error) {
+	key := req.URL.Scheme + "://" + req.URL.Host
+
+	for {
+		r.mu.Lock()
+		h, found := r.hosts[key]
+		if !found {
+			h = &host{ready: make(chan struct{})}
+			r.hosts[key] = h
+		}
+		r.mu.Unlock()
+
+		if !found {
+			group, err := r.fetch(req, transport)
+			if ctxErr := req.Context().Err(); ctxErr != nil {
+				r.mu.Lock()
+				delete(r.hosts, key)
+				r.mu.Unlock()
+				h.stopped = true
+				close(h.ready)
+				return nil, ctxErr
+			}
+			if err != nil && r.OnUnreachable != nil {
+				r.OnUnreachable(req.URL.Host, err)
+			}
+			h.group = group
+			close(h.ready)
+		}
+
+		select {
+		case <-h.ready:
+			if !h.stopped {
+				return h, nil
+			}
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// fetch reads the rules of the robots.txt of the host of a request.
+// Robots.txt files missing, unreachable or invalid allow everything.
+func (r *Robots) fetch(req *http.Request, transport http.RoundTripper) (*robotstxt.Group, error) {
+	robotsURL := *req.URL
+	robotsURL.Path, robotsURL.RawPath, robotsURL.RawQuery, robotsURL.Fragment = "/robots.txt", "", "", ""
+
+	// Robots.txt files can be moved, eg. to HTTPS
+	client := &http.Client{Transport: transport}
+	fetchReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	fetchReq.Header.Set("User-Agent", r.userAgent)
+
+	resp, err := client.Do(fetchReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Servers failing to serve their robots.txt would disallow
+	// everything, we would rather not skip a website for a hiccup
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	data, err := robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.FindGroup(r.userAgent), nil
+}
+
+// allowed tells if a request can be made
+func (h *host) allowed(req *http.Request) bool {
+	if h.group == nil {
+		return true
+	}
+
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	return h.group.Test(path)
+}
+
+// reserve takes the next turn of the host, and returns how long until
+// it, following its crawl delay
+func (h *host) reserve() time.Duration {
+	if h.group == nil || h.group.CrawlDelay <= 0 {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	turn := now
+	if h.next.After(now) {
+		turn = h.next
+	}
+	h.next = turn.Add(h.group.CrawlDelay)
+
+	return turn.Sub(now)
+}
+
+// Transport makes the requests robots.txt files allow through the next
+// transport, spaced by the crawl delay of their host. Robots.txt files
+// are read through the next transport too. Requests disallowed fail
+// with ErrDisallowed.
+func (r *Robots) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{robots: r, next: next}
+}
+
+type transport struct {
+	robots *Robots
+	next   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h, err := t.robots.host(req, t.next)
+	if err != nil {
+		return nil, err
+	}
+	if !h.allowed(req) {
+		return nil, ErrDisallowed
+	}
+
+	if err := utils.Sleep(req.Context(), h.reserve()); err != nil {
+		return nil, err
+	}
+
+	return t.next.RoundTrip(req)
+}
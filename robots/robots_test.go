@@ -0,0 +1,198 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newServer serves a robots.txt file and pages, and counts the
+// robots.txt requests
+func newServer(t *testing.T, status int, robotsTxt string) (*httptest.Server, *atomic.Int32) {
+	var fetched atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetched.Add(1)
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, robotsTxt)
+			return
+		}
+		_, _ = io.WriteString(w, "page")
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetched
+}
+
+// get gets a path of a server through a transport
+func get(ctx context.Context, transport http.RoundTripper, server *httptest.Server, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func TestDisallow(t *testing.T) {
+	server, fetched := newServer(t, http.StatusOK, `
+User-agent: *
+Disallow: /private/
+Disallow: /*?print=
+
+User-agent: moviestills
+Disallow: /film/hidden/
+Allow: /film/hidden/shown.htm
+
+User-agent: otherbot
+Disallow: /
+`)
+
+	transport := New(UserAgent).Transport(http.DefaultTransport)
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/film/reviews.htm", true},
+		{"/film/hidden/movie.htm", false},
+		{"/film/hidden/shown.htm", true},
+		{"/film/review.htm?print=1", true},
+
+		// Rules for any user agent are left out, ours being there
+		{"/private/page.htm", true},
+	}
+
+	for _, tt := range tests {
+		err := get(context.Background(), transport, server, tt.path)
+		if tt.allowed && err != nil {
+			t.Errorf("Getting %s unexpected error: %v", tt.path, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrDisallowed) {
+			t.Errorf("Getting %s error = %v, want %v", tt.path, err, ErrDisallowed)
+		}
+	}
+
+	if got := fetched.Load(); got != 1 {
+		t.Errorf("Robots.txt fetched %d times, want once", got)
+	}
+}
+
+func TestAnyUserAgent(t *testing.T) {
+	server, _ := newServer(t, http.StatusOK, "User-agent: *\nDisallow: /private/\n")
+
+	transport := New(UserAgent).Transport(http.DefaultTransport)
+	if err := get(context.Background(), transport, server, "/private/page.htm"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("Getting a path disallowed for any user agent, error = %v, want %v", err, ErrDisallowed)
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		robotsTxt string
+		wantCalls int
+	}{
+		{"missing", http.StatusNotFound, "", 0},
+		{"empty", http.StatusOK, "", 0},
+		{"server error", http.StatusServiceUnavailable, "User-agent: *\nDisallow: /\n", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newServer(t, tt.status, tt.robotsTxt)
+
+			calls := 0
+			r := New(UserAgent)
+			r.OnUnreachable = func(string, error) { calls++ }
+
+			if err := get(context.Background(), r.Transport(http.DefaultTransport), server, "/film/reviews.htm"); err != nil {
+				t.Errorf("Getting a page unexpected error: %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("OnUnreachable called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	server, _ := newServer(t, http.StatusOK, "User-agent: *\nCrawl-delay: 0.05\n")
+
+	// Websites share the crawl delay of a host
+	r := New(UserAgent)
+	transports := []http.RoundTripper{r.Transport(http.DefaultTransport), r.Transport(http.DefaultTransport)}
+
+	start := time.Now()
+	for i := range 4 {
+		if err := get(context.Background(), transports[i%2], server, "/film/reviews.htm"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first request is made right away, the others every 50ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 requests with a crawl delay of 50ms took %v, want at least 150ms", elapsed)
+	}
+}
+
+func TestStopped(t *testing.T) {
+	server, fetched := newServer(t, http.StatusOK, "User-agent: *\nDisallow: /private/\n")
+	transport := New(UserAgent).Transport(http.DefaultTransport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := get(ctx, transport, server, "/private/page.htm"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Getting a page with a stopped request, error = %v, want %v", err, context.Canceled)
+	}
+
+	// The robots.txt is read again for the next request
+	if err := get(context.Background(), transport, server, "/private/page.htm"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("Getting a disallowed page, error = %v, want %v", err, ErrDisallowed)
+	}
+	if got := fetched.Load(); got != 1 {
+		t.Errorf("Robots.txt fetched %d times, want once", got)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	var mu sync.Mutex
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			mu.Lock()
+			userAgent = r.UserAgent()
+			mu.Unlock()
+			_, _ = io.WriteString(w, "User-agent: *\nDisallow: /\n\nUser-agent: moviestills\nDisallow: /private/\n")
+			return
+		}
+		_, _ = io.WriteString(w, "page")
+	}))
+	t.Cleanup(server.Close)
+
+	// Our full user agent is matched with the rules for our name
+	identity := Identify("1.0.0")
+	transport := New(identity).Transport(http.DefaultTransport)
+	if err := get(context.Background(), transport, server, "/film/reviews.htm"); err != nil {
+		t.Errorf("Getting a page allowed for %s, unexpected error: %v", identity, err)
+	}
+	if err := get(context.Background(), transport, server, "/private/page.htm"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("Getting a page disallowed for %s, error = %v, want %v", identity, err, ErrDisallowed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if userAgent != identity {
+		t.Errorf("Robots.txt requested as %q, want %q", userAgent, identity)
+	}
+}
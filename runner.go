@@ -1,26 +1,58 @@
 package main
 
 import (
+	"context"
 	"moviestills/config"
 	"moviestills/debug"
+	"moviestills/hostlimit"
+	"moviestills/metrics"
+	"moviestills/pagecache"
+	"moviestills/proxypool"
+	"moviestills/retry"
+	"moviestills/robots"
 	"moviestills/scraper"
+	"net/http"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/gocolly/colly/v2"
+	collydebug "github.com/gocolly/colly/v2/debug"
 	"github.com/gocolly/colly/v2/extensions"
 	"github.com/pterm/pterm"
 )
 
-func runSequential(websitesToScrape []string, options *config.Options, aggStats *scraper.AggregatedStats) {
+// shared holds what the scrapers of all websites share
+type shared struct {
+	// Recorder is given every image saved, can be nil
+	recorder scraper.Recorder
+
+	// Metrics of the scrapers, nil if not served
+	metrics *metrics.Metrics
+
+	// Limits of the requests made to every host
+	limiter *hostlimit.Limiter
+
+	// Proxies of all websites, with their health
+	proxies *proxypool.Pool
+
+	// Robots.txt files of hosts, nil if not followed
+	robots *robots.Robots
+}
+
+func runSequential(ctx context.Context, websitesToScrape []string, options *config.Options, sh *shared, aggStats *scraper.AggregatedStats) {
 	for _, website := range websitesToScrape {
+		// Don't start other websites when stopping
+		if ctx.Err() != nil {
+			return
+		}
 		pterm.DefaultSection.Println("Scraping", website)
-		stats := runScraper(website, options)
+		stats := runScraper(ctx, website, options, sh)
 		aggStats.Add(stats)
 	}
 }
 
-func runConcurrent(websitesToScrape []string, options *config.Options, aggStats *scraper.AggregatedStats) {
+func runConcurrent(ctx context.Context, websitesToScrape []string, options *config.Options, sh *shared, aggStats *scraper.AggregatedStats) {
 	pterm.Info.Println("Running", pterm.White(len(websitesToScrape)), "scrapers concurrently...")
 
 	var wg sync.WaitGroup
@@ -28,43 +60,87 @@ func runConcurrent(websitesToScrape []string, options *config.Options, aggStats
 		wg.Add(1)
 		go func(site string) {
 			defer wg.Done()
-			stats := runScraper(site, options)
+			stats := runScraper(ctx, site, options, sh)
 			aggStats.Add(stats)
 		}(website)
 	}
 	wg.Wait()
 }
 
-func runScraper(website string, options *config.Options) *scraper.Stats {
+func runScraper(ctx context.Context, website string, options *config.Options, sh *shared) *scraper.Stats {
+	// Some websites need options of their own
+	options = options.ForSite(website)
+
 	// Create and configure scraper for this website
-	c := colly.NewCollector(
-		colly.CacheDir(filepath.Join(options.CacheDir, website)),
-	)
+	c := colly.NewCollector()
 
-	configureScraper(c, options)
+	configureScraper(c, website, options, sh.metrics)
 
 	// Initialize stats tracking
 	stats := &scraper.Stats{Website: website}
+	sh.metrics.Track(stats)
+
+	// Retry requests failing for a transient reason, every attempt
+	// following the limits of its host shared with other websites,
+	// and its robots.txt, through the next proxy of the website if any
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	limited := sh.limiter.Transport(rotateProxies(transport, options, sh.proxies))
+	if sh.robots != nil {
+		limited = sh.robots.Transport(limited)
+	}
+	retries := retry.New(limited, options.RetryPolicy())
+	retries.OnRetry = logRetry(website, stats)
+
+	// Cache pages of the website, but not its images
+	c.WithTransport(pagecache.New(filepath.Join(options.CacheDir, website), retries, options.CachePolicy()))
 
 	// Run the scraper
-	siteFunc := sites[website]
-	siteFunc(c, options, stats)
+	site, _ := scraper.Lookup(website)
+	scraper.Run(ctx, site, c, options, stats, sh.recorder)
 
-	pterm.Success.Println("Finished scraping", pterm.White(website))
+	if ctx.Err() != nil {
+		pterm.Warning.Println("Stopped scraping", pterm.White(website))
+	} else {
+		pterm.Success.Println("Finished scraping", pterm.White(website))
+	}
 
 	return stats
 }
 
-func configureScraper(c *colly.Collector, options *config.Options) {
-	// Set up a proxy
-	if options.Proxy != "" {
-		if err := c.SetProxy(options.Proxy); err != nil {
-			pterm.Error.Println("Could not set proxy", pterm.White(options.Proxy), pterm.Red(err))
+// logRetry logs and counts the requests of a website retried
+func logRetry(website string, stats *scraper.Stats) func(retry.Retry) {
+	log := scraper.NewLogger(website)
+	return func(r retry.Retry) {
+		stats.IncrRetries()
+
+		delay := pterm.White(r.Delay.Round(time.Millisecond))
+		if r.Status != 0 {
+			log.Warning("Retrying", scraper.URLField(r.Request.URL.String()), "in", delay, "after", scraper.StatusField(r.Status))
+		} else {
+			log.Warning("Retrying", scraper.URLField(r.Request.URL.String()), "in", delay, "after", pterm.White(r.Class), "error:", r.Err)
 		}
 	}
+}
+
+// rotateProxies makes requests through the proxies of a website, in turn
+func rotateProxies(transport *http.Transport, options *config.Options, pool *proxypool.Pool) http.RoundTripper {
+	// Proxies were checked when starting
+	proxies, err := options.ProxyList()
+	if err == nil {
+		var rotation *proxypool.Rotation
+		if rotation, err = pool.Rotation(proxies); err == nil {
+			return rotation.Transport(transport)
+		}
+	}
+
+	pterm.Error.Println("Could not set proxies", pterm.Red(err))
+	return transport
+}
 
-	// Set request timeout
-	c.SetRequestTimeout(options.TimeOut)
+func configureScraper(c *colly.Collector, website string, options *config.Options, m *metrics.Metrics) {
+	// Requests time out by attempt in the retry transport,
+	// not for all of their attempts
+	c.SetRequestTimeout(0)
 
 	// Enable asynchronous jobs if asked
 	if options.Async {
@@ -72,21 +148,36 @@ func configureScraper(c *colly.Collector, options *config.Options) {
 	}
 
 	// Enable Debugging level if asked through the CLI
+	var debugger collydebug.Debugger
 	if options.Debug {
 		pterm.EnableDebugMessages()
-		c.SetDebugger(&debug.PTermDebugger{})
+		debugger = &debug.PTermDebugger{}
 	}
 
-	// Use random user agent and referer to avoid getting banned
-	extensions.RandomUserAgent(c)
+	// Measure requests through the debugger, shared with clones of the
+	// collector, unlike callbacks
+	if m != nil {
+		debugger = m.Debugger(website, debugger)
+	}
+	if debugger != nil {
+		c.SetDebugger(debugger)
+	}
+
+	// Go by our name when following robots.txt files, or use
+	// random user agents, and referers to avoid getting banned
+	scraper.SetUserAgent(c, options)
 	extensions.Referer(c)
 
-	// Limit parallelism and add random delay to avoid getting IP banned
-	if err := c.Limit(&colly.LimitRule{
-		DomainGlob:  "*",
-		Parallelism: options.Parallel,
-		RandomDelay: options.RandomDelay,
-	}); err != nil {
-		pterm.Warning.Println("Can't change scraper limit options:", pterm.Red(err))
+	// Parallelism and random delays are limited by host in the
+	// transport, for all websites, to avoid getting IP banned.
+	// Websites can be limited further on their own.
+	if overrides := options.Sites[website]; overrides.Parallel != nil || overrides.RandomDelay != nil {
+		if err := c.Limit(&colly.LimitRule{
+			DomainGlob:  "*",
+			Parallelism: options.Parallel,
+			RandomDelay: options.RandomDelay,
+		}); err != nil {
+			pterm.Warning.Println("Can't change scraper limit options:", pterm.Red(err))
+		}
 	}
 }
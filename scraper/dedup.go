@@ -0,0 +1,300 @@
+package scraper
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"moviestills/config"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pterm/pterm"
+)
+
+// DuplicatesFolder is where near-identical stills of a movie are moved
+const DuplicatesFolder string = "duplicates"
+
+// What to do with near-identical stills of a movie
+const (
+	DedupMove = "move"
+	DedupSkip = "skip"
+	DedupOff  = "off"
+)
+
+// Deduplicating tells if near-identical stills of a movie are looked for
+func Deduplicating(options *config.Options) bool {
+	return options.Dedup == DedupMove || options.Dedup == DedupSkip
+}
+
+// PerceptualHash computes the difference hash of an encoded image,
+// as 16 hexadecimal digits.
+func PerceptualHash(content []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	return perceptualHash(img), nil
+}
+
+// perceptualHash is the difference hash of an image, as 16 hexadecimal digits
+func perceptualHash(img image.Image) string {
+	return fmt.Sprintf("%016x", DHash(img))
+}
+
+// DHash computes the difference hash (dHash) of an image: the image is
+// reduced to 9x8 gray pixels, and each bit of the hash tells if a pixel
+// is darker than the next one on its row. Near-identical images, even
+// resized or encoded differently, have hashes with few different bits.
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+
+	var gray [height][width]float64
+	bounds := img.Bounds()
+	for y := 0; y < height; y++ {
+		y0, y1 := cell(bounds.Min.Y, bounds.Dy(), y, height)
+		for x := 0; x < width; x++ {
+			x0, x1 := cell(bounds.Min.X, bounds.Dx(), x, width)
+			gray[y][x] = luminance(img, x0, y0, x1, y1)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HammingDistance is the number of different bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// cell returns the range of pixels of the nth cell out of count,
+// never empty even for tiny images.
+func cell(start, size, n, count int) (int, int) {
+	from, to := start+n*size/count, start+(n+1)*size/count
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// luminance is the average luminance of a rectangle of an image.
+// Large rectangles are sampled, no need to read every pixel.
+func luminance(img image.Image, x0, y0, x1, y1 int) float64 {
+	const samples = 16
+	stepX, stepY := max(1, (x1-x0)/samples), max(1, (y1-y0)/samples)
+
+	var sum float64
+	var count int
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			count++
+		}
+	}
+
+	return sum / float64(count)
+}
+
+// Similar finds an image of the manifest near-identical to the given
+// one, with perceptual hashes within the maximum Hamming distance. The
+// earliest saved is found first, in the order of the manifest.
+func (m *Manifest) Similar(image ManifestImage, distance int) (ManifestImage, bool) {
+	hash, err := strconv.ParseUint(image.DHash, 16, 64)
+	if err != nil {
+		return ManifestImage{}, false
+	}
+
+	for _, saved := range m.Images {
+		if saved.FileName == image.FileName || saved.DHash == "" {
+			continue
+		}
+		savedHash, err := strconv.ParseUint(saved.DHash, 16, 64)
+		if err == nil && HammingDistance(hash, savedHash) <= distance {
+			return saved, true
+		}
+	}
+
+	return ManifestImage{}, false
+}
+
+// SaveResult tells what happened to an image saved for a movie
+type SaveResult struct {
+	// Image as recorded in the manifest, with its final filename
+	Image ManifestImage
+
+	// Saved is true when the image was written in the movie folder
+	Saved bool
+
+	// Identical is true when the same image was already saved
+	Identical bool
+
+	// Renamed is true when the filename was taken by a different
+	// image, the image was saved under a suffixed name instead.
+	Renamed bool
+
+	// Duplicate is true when the image is a near-duplicate of
+	// an image saved before, and was not saved.
+	Duplicate bool
+
+	// Replaced is the smaller near-duplicate moved aside, if any
+	Replaced *ManifestImage
+}
+
+// SaveUniqueImage saves an image for a movie, unless the same image or
+// a near-identical one was saved before. The image is described with
+// its raw filename, named after its URL, and is saved following the
+// filename template, or after its content. Different images sharing a
+// filename are both kept, under different names.
+//
+// For near-identical images, only the larger of the two is kept in the
+// movie folder when moving duplicates, the other one being moved to the
+// duplicates folder. When skipping them, the image saved first is kept
+// and the new one is not saved.
+func SaveUniqueImage(movie Movie, image ManifestImage, body []byte, options *config.Options, log *Logger) (SaveResult, error) {
+	unlock := lockManifest(movie.Path)
+	defer unlock()
+
+	manifest, err := openManifest(movie.Path)
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	// The manifest in memory may not match the one saved after
+	// a failure, it is read again next time
+	result, err := saveUniqueImage(movie, manifest, image, body, options, log)
+	if err != nil {
+		openManifests.Delete(movie.Path)
+	}
+	return result, err
+}
+
+// saveUniqueImage saves an image with the manifest of its movie,
+// see SaveUniqueImage.
+func saveUniqueImage(movie Movie, manifest *Manifest, image ManifestImage, body []byte, options *config.Options, log *Logger) (SaveResult, error) {
+	var err error
+	manifest.describe(movie)
+	image.Site = movie.Site
+
+	// An image downloaded again from the same URL keeps its name,
+	// new images are named following the filename template. Images
+	// named by content get a new name when their content changed.
+	saved, found := manifest.ImageByURL(image.URL)
+	if found && options.Naming != NamingContent {
+		image.FileName, image.OriginalName = saved.FileName, saved.OriginalName
+	} else {
+		layout := LayoutFor(options)
+		index := len(manifest.Images) + len(manifest.Duplicates) + 1
+		image.OriginalName = image.FileName
+		image.FileName, err = layout.FileName(layout.ImageFields(movie, image.FileName, index, image.SHA256))
+		if err != nil {
+			return SaveResult{}, err
+		}
+	}
+
+	// The older version of an image changed at its URL is kept,
+	// only the URL now leads to the new one
+	changed := found && options.Naming == NamingContent && saved.SHA256 != image.SHA256
+	if changed {
+		log.Info("Image changed at its URL for", MovieField(movie.Name), "keeping the older version", pterm.White(saved.FileName))
+		manifest.DetachURL(saved.FileName, image.URL)
+	}
+
+	fileName, status := uniqueFileName(movie.Path, manifest, image)
+	image.FileName = fileName
+	result := SaveResult{Image: image}
+
+	switch status {
+	case nameIdentical:
+		log.Debug("Same image already saved for", MovieField(movie.Name), pterm.White(fileName))
+		result.Identical = true
+
+		// Images saved before manifests existed are recorded now,
+		// the same image found at another URL is recorded as such.
+		if _, recorded := manifest.Image(fileName); recorded {
+			if !manifest.AddURL(fileName, image.URL) && !changed {
+				return result, nil
+			}
+			return result, manifest.Write(movie.Path)
+		}
+		manifest.AddImage(image)
+		return result, manifest.Write(movie.Path)
+
+	case nameCollision:
+		log.Warning("Filename taken by a different image for", MovieField(movie.Name), "saving as", pterm.White(fileName))
+		result.Renamed = true
+	}
+
+	if Deduplicating(options) && image.DHash != "" {
+		if similar, found := manifest.Similar(image, options.DedupDistance); found {
+			// Skipping near-duplicates never touches images saved
+			// before: the earliest saved is kept, whatever its size
+			if options.Dedup == DedupSkip || image.Width*image.Height <= similar.Width*similar.Height {
+				log.Info("Near-duplicate of", pterm.White(similar.FileName), "for", MovieField(movie.Name), pterm.White(fileName))
+
+				image.DuplicateOf = similar.FileName
+				if err := saveDuplicate(movie, fileName, body, options.Dedup); err != nil {
+					return SaveResult{}, err
+				}
+				manifest.AddDuplicate(image)
+
+				result.Image, result.Duplicate = image, true
+				return result, manifest.Write(movie.Path)
+			}
+
+			// The image saved before is a smaller version of this one
+			log.Info("Replacing near-duplicate", pterm.White(similar.FileName), "for", MovieField(movie.Name), pterm.White(fileName))
+
+			if err := moveDuplicate(movie, similar.FileName); err != nil {
+				return SaveResult{}, err
+			}
+			manifest.RemoveImage(similar.FileName)
+			similar.DuplicateOf = fileName
+			manifest.AddDuplicate(similar)
+			result.Replaced = &similar
+		}
+	}
+
+	if err := SaveImage(movie, fileName, body, log); err != nil {
+		return SaveResult{}, err
+	}
+	manifest.AddImage(image)
+	result.Saved = true
+
+	return result, manifest.Write(movie.Path)
+}
+
+// saveDuplicate saves a new duplicate in the duplicates folder, if asked to
+func saveDuplicate(movie Movie, fileName string, body []byte, mode string) error {
+	if mode != DedupMove {
+		return nil
+	}
+
+	duplicatesPath := filepath.Join(movie.Path, DuplicatesFolder)
+	if err := os.MkdirAll(duplicatesPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(duplicatesPath, fileName), body)
+}
+
+// moveDuplicate moves an image saved before to the duplicates folder
+func moveDuplicate(movie Movie, fileName string) error {
+	duplicatesPath := filepath.Join(movie.Path, DuplicatesFolder)
+	if err := os.MkdirAll(duplicatesPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(movie.Path, fileName), filepath.Join(duplicatesPath, fileName))
+}
@@ -0,0 +1,219 @@
+package scraper
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"moviestills/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tiledImage draws an image of random gray tiles. The same seed
+// gives the same picture, whatever its size.
+func tiledImage(seed int64, width, height int) image.Image {
+	const tiles = 12
+	random := rand.New(rand.NewSource(seed))
+	var shades [tiles][tiles]uint8
+	for y := range shades {
+		for x := range shades[y] {
+			shades[y][x] = uint8(random.Intn(256))
+		}
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{shades[y*tiles/height][x*tiles/width]})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDHash(t *testing.T) {
+	original := DHash(tiledImage(1, 1280, 720))
+
+	if distance := HammingDistance(original, DHash(tiledImage(1, 1280, 720))); distance != 0 {
+		t.Errorf("Same images are %d bits apart", distance)
+	}
+	if distance := HammingDistance(original, DHash(tiledImage(1, 500, 281))); distance > 4 {
+		t.Errorf("Resized images are %d bits apart", distance)
+	}
+	if distance := HammingDistance(original, DHash(tiledImage(2, 1280, 720))); distance <= 10 {
+		t.Errorf("Different images are only %d bits apart", distance)
+	}
+
+	// Tiny images must not crash
+	DHash(tiledImage(1, 3, 2))
+}
+
+func TestSaveUniqueImage(t *testing.T) {
+	large := encodePNG(t, tiledImage(1, 1280, 720))
+	small := encodePNG(t, tiledImage(1, 640, 360))
+	other := encodePNG(t, tiledImage(2, 640, 360))
+
+	describe := func(fileName string, body []byte) ManifestImage {
+		hash, err := PerceptualHash(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		width, height := ImageSize(body)
+		return ManifestImage{URL: "https://example.com/" + fileName, FileName: fileName, Size: int64(len(body)), Width: width, Height: height, SHA256: SHA256(body), DHash: hash}
+	}
+
+	for _, mode := range []string{DedupMove, DedupSkip} {
+		t.Run(mode, func(t *testing.T) {
+			movie := Movie{Name: "Alien", Path: t.TempDir()}
+			options := &config.Options{Dedup: mode, DedupDistance: 4}
+			log := NewLogger("test")
+
+			save := func(fileName string, body []byte) (bool, *ManifestImage) {
+				result, err := SaveUniqueImage(movie, describe(fileName, body), body, options, log)
+				if err != nil {
+					t.Fatalf("Can't save %s: %v", fileName, err)
+				}
+				return result.Saved, result.Replaced
+			}
+
+			if saved, _ := save("small.png", small); !saved {
+				t.Fatal("First image not saved")
+			}
+			if saved, _ := save("other.png", other); !saved {
+				t.Fatal("Different image not saved")
+			}
+
+			// The larger version replaces the smaller one when moving
+			// duplicates. Skipping them, the image saved first stays.
+			saved, replaced := save("large.png", large)
+			kept, duplicates := "large.png", []string{"small.png", "again.png"}
+			if mode == DedupSkip {
+				kept, duplicates = "small.png", []string{"large.png", "again.png"}
+				if saved || replaced != nil {
+					t.Fatalf("Larger image saved: %v, replacing %+v", saved, replaced)
+				}
+			} else if !saved || replaced == nil || replaced.FileName != "small.png" {
+				t.Fatalf("Larger image saved: %v, replacing %+v", saved, replaced)
+			}
+
+			// Smaller versions are not saved anymore
+			if saved, _ := save("again.png", small); saved {
+				t.Fatal("Smaller image saved")
+			}
+
+			for _, fileName := range []string{kept, "other.png"} {
+				if !HasImage(movie, "https://example.com/"+fileName, fileName, true) {
+					t.Errorf("%s not in the movie folder", fileName)
+				}
+			}
+			for _, fileName := range duplicates {
+				if _, err := os.Stat(filepath.Join(movie.Path, fileName)); !os.IsNotExist(err) {
+					t.Errorf("Duplicate %s left in the movie folder", fileName)
+				}
+				if !HasImage(movie, "https://example.com/"+fileName, fileName, true) {
+					t.Errorf("Duplicate %s would be downloaded again", fileName)
+				}
+
+				_, err := os.Stat(filepath.Join(movie.Path, DuplicatesFolder, fileName))
+				if moved := err == nil; moved != (mode == DedupMove) {
+					t.Errorf("Duplicate %s moved: %v", fileName, moved)
+				}
+			}
+
+			manifest, err := ReadManifest(movie.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Images) != 2 || len(manifest.Duplicates) != 2 {
+				t.Fatalf("Manifest lists %d images and %d duplicates", len(manifest.Images), len(manifest.Duplicates))
+			}
+			for _, duplicate := range manifest.Duplicates {
+				if duplicate.DuplicateOf != kept {
+					t.Errorf("%s is a duplicate of %q, expected %s", duplicate.FileName, duplicate.DuplicateOf, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveUniqueImageByContent(t *testing.T) {
+	movie := Movie{Name: "Alien", Path: t.TempDir()}
+	options := &config.Options{Naming: NamingContent, Dedup: DedupOff}
+	log := NewLogger("test")
+
+	first := encodePNG(t, tiledImage(1, 640, 360))
+	second := encodePNG(t, tiledImage(2, 640, 360))
+	third := encodePNG(t, tiledImage(3, 640, 360))
+
+	save := func(imageURL string, body []byte) SaveResult {
+		t.Helper()
+		image := ManifestImage{URL: imageURL, FileName: filepath.Base(imageURL), Size: int64(len(body)), SHA256: SHA256(body)}
+		result, err := SaveUniqueImage(movie, image, body, options, log)
+		if err != nil {
+			t.Fatalf("Can't save %s: %v", imageURL, err)
+		}
+		return result
+	}
+	exists := func(body []byte) bool {
+		_, err := os.Stat(filepath.Join(movie.Path, SHA256(body)+".png"))
+		return err == nil
+	}
+
+	result := save("https://example.com/a.png", first)
+	if !result.Saved || result.Image.FileName != SHA256(first)+".png" || result.Image.OriginalName != "a.png" {
+		t.Fatalf("First image saved as %+v", result.Image)
+	}
+
+	// The same image from another URL is not saved twice
+	if result := save("https://example.com/b.png", first); result.Saved || !result.Identical {
+		t.Fatalf("Same image from another URL: %+v", result)
+	}
+	if !HasImage(movie, "https://example.com/b.png", "", true) {
+		t.Error("Same image from another URL would be downloaded again")
+	}
+
+	// A URL serving another image gets a new file, the older
+	// version is kept, even once no URL serves it anymore.
+	if result := save("https://example.com/a.png", second); !result.Saved || !exists(first) {
+		t.Fatalf("Image changed at its URL: %+v", result)
+	}
+	if result := save("https://example.com/b.png", third); !result.Saved || !exists(first) {
+		t.Fatalf("Image changed at its last URL: %+v", result)
+	}
+
+	manifest, err := ReadManifest(movie.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Images) != 3 || !exists(second) || !exists(third) {
+		t.Fatalf("Manifest lists %+v", manifest.Images)
+	}
+	older, _ := manifest.Image(SHA256(first) + ".png")
+	if older.URL != "" || len(older.OtherURLs) != 0 || !reflect.DeepEqual(older.FormerURLs, []string{"https://example.com/a.png", "https://example.com/b.png"}) {
+		t.Errorf("Older version recorded as %+v", older)
+	}
+	if current, _ := manifest.ImageByURL("https://example.com/a.png"); current.SHA256 != SHA256(second) {
+		t.Errorf("URL leads to %+v, want the new image", current)
+	}
+
+	// A URL serving the older version again leads back to it
+	if result := save("https://example.com/a.png", first); result.Saved || !result.Identical {
+		t.Fatalf("Older version served again: %+v", result)
+	}
+	manifest, _ = ReadManifest(movie.Path)
+	if older, _ := manifest.Image(SHA256(first) + ".png"); older.URL != "https://example.com/a.png" || len(older.FormerURLs) != 1 {
+		t.Errorf("Older version recorded as %+v", older)
+	}
+}
@@ -1,11 +1,20 @@
 package scraper
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/gocolly/colly/v2"
 	"github.com/pterm/pterm"
 )
 
@@ -16,33 +25,140 @@ func MD5(text string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// SaveImage saves a movie image to the correct folder.
-// Filenames can be hashed with MD5 if the option is set.
-func SaveImage(moviePath, movieName, rawFileName string, body []byte, toHash bool, log *Logger) error {
-	fileName := rawFileName
-	extension := filepath.Ext(rawFileName)
+// ImageSize returns the dimensions of an encoded image,
+// or zeros if the format is unknown.
+func ImageSize(content []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// RequestFileName is the raw filename of an image, worked out from its
+// URL before requesting it, the same way Colly does once downloaded.
+func RequestFileName(u *url.URL) string {
+	if u.RawQuery != "" {
+		return colly.SanitizeFileName(fmt.Sprintf("%s_%s", u.Path, u.RawQuery))
+	}
+	return colly.SanitizeFileName(strings.TrimPrefix(u.Path, "/"))
+}
+
+// HasImage tells if the image found at a URL was already saved for a
+// movie, reading its manifest. See Manifest.HasImage.
+func HasImage(movie Movie, imageURL, fileName string, verify bool) bool {
+	if movie.Path == "" {
+		return false
+	}
+
+	manifest, err := ReadManifest(movie.Path)
+	if err != nil {
+		return false
+	}
+	return manifest.HasImage(movie.Path, imageURL, fileName, verify)
+}
+
+// HasImage tells if the image found at a URL was saved in the movie
+// folder of the manifest, under the given filename if it's not
+// recorded (empty if unknown before downloading the image). Images
+// recorded must still have the same size, so truncated images are
+// downloaded again. With verify, their hash is checked too, for
+// images modified since. Known near-duplicates count as saved.
+func (m *Manifest) HasImage(moviePath, imageURL, fileName string, verify bool) bool {
+	if _, duplicate := m.DuplicateByURL(imageURL); duplicate {
+		return true
+	}
+
+	image, recorded := m.ImageByURL(imageURL)
+	if !recorded {
+		// Images saved before manifests existed can't be checked,
+		// unless their name is taken by an image from another URL.
+		if _, taken := m.Image(fileName); taken || fileName == "" {
+			return false
+		}
+		info, err := os.Stat(filepath.Join(moviePath, fileName))
+		return err == nil && info.Mode().IsRegular() && info.Size() > 0
+	}
+
+	imagePath := filepath.Join(moviePath, image.FileName)
+	info, err := os.Stat(imagePath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != image.Size {
+		return false
+	}
+	if !verify {
+		return true
+	}
 
-	// Hash image filename with MD5 if asked to
-	if toHash {
-		fileName = MD5(rawFileName) + extension
+	content, err := os.ReadFile(imagePath)
+	return err == nil && SHA256(content) == image.SHA256
+}
+
+// What was found under the filename of an image in a movie folder
+type nameStatus int
+
+const (
+	// Nothing, the image can be saved
+	nameFree nameStatus = iota
+
+	// The same image, no need to save it again
+	nameIdentical
+
+	// An older version of the image from the same URL, to replace
+	nameReplaced
+
+	// A different image, the image is saved under a suffixed name
+	nameCollision
+)
+
+// uniqueFileName picks the filename of an image in a movie folder, so
+// different images sharing a basename, eg. "1.jpg" from different
+// hosts, don't overwrite each other. The second image is saved under
+// a name suffixed with its content hash, the same every time.
+func uniqueFileName(moviePath string, manifest *Manifest, image ManifestImage) (string, nameStatus) {
+	suffixed := suffixedFileName(image.FileName, image.SHA256)
+
+	for _, candidate := range []string{image.FileName, suffixed} {
+		content, err := os.ReadFile(filepath.Join(moviePath, candidate))
+		if err != nil {
+			if candidate == image.FileName {
+				return candidate, nameFree
+			}
+			return candidate, nameCollision
+		}
+
+		if SHA256(content) == image.SHA256 {
+			return candidate, nameIdentical
+		}
+		if saved, recorded := manifest.Image(candidate); recorded && saved.URL == image.URL {
+			return candidate, nameReplaced
+		}
 	}
 
-	outputImgPath := filepath.Join(moviePath, fileName)
+	// Extremely unlikely, the suffixed name is taken as well
+	return suffixed, nameCollision
+}
 
+// suffixedFileName adds the beginning of a content hash to a filename,
+// eg. "1-0a1b2c3d.jpg"
+func suffixedFileName(fileName, hash string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "-" + hash[:min(8, len(hash))] + ext
+}
+
+// SaveImage saves a movie image under the given filename in the movie
+// folder. An image already saved with the same filename is replaced.
+func SaveImage(movie Movie, fileName string, body []byte, log *Logger) error {
 	// Create nested folders, if needed
-	if err := os.MkdirAll(moviePath, os.ModePerm); err != nil {
+	if err := os.MkdirAll(movie.Path, os.ModePerm); err != nil {
 		return err
 	}
 
-	// Don't save again if we already downloaded it
-	if _, err := os.Stat(outputImgPath); os.IsNotExist(err) {
-		if err = os.WriteFile(outputImgPath, body, 0644); err != nil {
-			return err
-		}
+	if err := writeFileAtomic(filepath.Join(movie.Path, fileName), body); err != nil {
+		return err
 	}
 
 	// If we're here, image was successfully downloaded
-	log.Success("Saved image for", pterm.Blue(movieName), pterm.White(rawFileName))
+	log.Success("Saved image for", MovieField(movie.Name), pterm.White(fileName))
 
 	return nil
 }
@@ -0,0 +1,143 @@
+package scraper
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Filenames worked out before requesting images must
+// be the same as the ones given by Colly once downloaded.
+func TestRequestFileName(t *testing.T) {
+	urls := []string{
+		"https://example.com/image.jpg",
+		"https://example.com/film3/blu-ray_reviews53/large/10_1.jpg",
+		"https://example.com/wp-content/uploads/2014/02/Brazil 001.jpg",
+		"https://example.com/image.php?id=42&size=large",
+		"https://example.com/",
+	}
+
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := &colly.Response{Request: &colly.Request{URL: u}, Headers: &http.Header{}}
+		if got, expected := RequestFileName(u), response.FileName(); got != expected {
+			t.Errorf("RequestFileName(%s) = %s, expected %s", rawURL, got, expected)
+		}
+	}
+}
+
+func TestHasImage(t *testing.T) {
+	movie := Movie{Name: "Alien", Path: t.TempDir(), Site: "example"}
+	content := []byte("not really an image")
+
+	write := func(fileName string, content []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(movie.Path, fileName), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record := func(imageURL, fileName string) {
+		t.Helper()
+		image := ManifestImage{URL: imageURL, FileName: fileName, Size: int64(len(content)), SHA256: SHA256(content)}
+		if err := RecordImage(movie, image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("saved.jpg", content)
+	record("https://example.com/saved.jpg", "saved.jpg")
+
+	// Saved before manifests existed
+	write("old.jpg", content)
+
+	write("empty.jpg", nil)
+
+	write("truncated.jpg", content[:5])
+	record("https://example.com/truncated.jpg", "truncated.jpg")
+
+	// Same size but not the same content
+	write("modified.jpg", []byte("not really an IMAGE"))
+	record("https://example.com/modified.jpg", "modified.jpg")
+
+	// Name taken by an image from another URL
+	write("taken.jpg", content)
+	record("https://other.example.com/taken.jpg", "taken.jpg")
+
+	// Saved under another name after a collision
+	write("renamed-0a1b2c3d.jpg", content)
+	record("https://example.com/renamed.jpg", "renamed-0a1b2c3d.jpg")
+
+	// Images modified since are only found by checking their hash
+	cases := []struct {
+		fileName   string
+		expected   bool
+		unverified bool
+	}{
+		{"saved.jpg", true, true},
+		{"old.jpg", true, true},
+		{"missing.jpg", false, false},
+		{"empty.jpg", false, false},
+		{"truncated.jpg", false, false},
+		{"modified.jpg", false, true},
+		{"taken.jpg", false, false},
+		{"renamed.jpg", true, true},
+	}
+
+	for _, c := range cases {
+		if got := HasImage(movie, "https://example.com/"+c.fileName, c.fileName, true); got != c.expected {
+			t.Errorf("HasImage(%s) = %v, expected %v", c.fileName, got, c.expected)
+		}
+		if got := HasImage(movie, "https://example.com/"+c.fileName, c.fileName, false); got != c.unverified {
+			t.Errorf("HasImage(%s) without verifying = %v, expected %v", c.fileName, got, c.unverified)
+		}
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	moviePath := t.TempDir()
+	saved := []byte("saved image")
+	other := []byte("other image")
+
+	if err := os.WriteFile(filepath.Join(moviePath, "1.jpg"), saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{}
+	manifest.AddImage(ManifestImage{URL: "https://imgur.com/1.jpg", FileName: "1.jpg", SHA256: SHA256(saved)})
+
+	suffixed := "1-" + SHA256(other)[:8] + ".jpg"
+	cases := []struct {
+		name     string
+		image    ManifestImage
+		fileName string
+		status   nameStatus
+	}{
+		{"free", ManifestImage{URL: "https://imgur.com/2.jpg", FileName: "2.jpg", SHA256: SHA256(other)}, "2.jpg", nameFree},
+		{"identical", ManifestImage{URL: "https://postimg.cc/1.jpg", FileName: "1.jpg", SHA256: SHA256(saved)}, "1.jpg", nameIdentical},
+		{"updated", ManifestImage{URL: "https://imgur.com/1.jpg", FileName: "1.jpg", SHA256: SHA256(other)}, "1.jpg", nameReplaced},
+		{"collision", ManifestImage{URL: "https://postimg.cc/1.jpg", FileName: "1.jpg", SHA256: SHA256(other)}, suffixed, nameCollision},
+	}
+
+	for _, c := range cases {
+		fileName, status := uniqueFileName(moviePath, manifest, c.image)
+		if fileName != c.fileName || status != c.status {
+			t.Errorf("%s: uniqueFileName() = %s, %d, expected %s, %d", c.name, fileName, status, c.fileName, c.status)
+		}
+	}
+
+	// The suffixed name is the same next time
+	if err := os.WriteFile(filepath.Join(moviePath, suffixed), other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := ManifestImage{URL: "https://postimg.cc/1.jpg", FileName: "1.jpg", SHA256: SHA256(other)}
+	if fileName, status := uniqueFileName(moviePath, manifest, image); fileName != suffixed || status != nameIdentical {
+		t.Errorf("uniqueFileName() = %s, %d once saved, expected %s to be identical", fileName, status, suffixed)
+	}
+}
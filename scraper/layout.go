@@ -0,0 +1,208 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"moviestills/config"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Default layout of the data directory: <site>/<movie title>/<image>,
+// images being named after their URL.
+const (
+	DefaultPathTemplate     string = "{{.Site}}/{{.Title}}"
+	DefaultFileNameTemplate string = "{{.Name}}{{.Ext}}"
+)
+
+// How images are named: after their URL, following the filename
+// template, or after the SHA-256 hash of their content.
+const (
+	NamingURL     = "url"
+	NamingContent = "content"
+)
+
+// ContentFileNameTemplate names images after their content, so the
+// same image always gets the same name, wherever it comes from.
+const ContentFileNameTemplate string = "{{.Hash}}{{.Ext}}"
+
+// MovieFields can be used in path templates
+type MovieFields struct {
+	Site  string
+	Title string
+	Year  string
+}
+
+// ImageFields can be used in filename templates
+type ImageFields struct {
+	MovieFields
+
+	// Index is the position of the image in the movie, from 1
+	Index int
+
+	// Hash is the SHA-256 hash of the image content
+	Hash string
+
+	// Name is the original filename of the image, without its
+	// extension, or its MD5 hash if filenames are hashed.
+	Name string
+
+	// Ext is the extension of the original filename, eg. ".jpg"
+	Ext string
+}
+
+// Layout tells where movies and their images are saved in the data
+// directory, from the path and filename templates of the options.
+type Layout struct {
+	path     *template.Template
+	fileName *template.Template
+	hash     bool
+}
+
+// Layouts parsed from the options, by templates
+var layouts sync.Map
+
+// NewLayout parses the path and filename templates. Filenames can be
+// hashed with MD5, as the default template does with --hash.
+func NewLayout(pathTemplate, fileNameTemplate string, hash bool) (*Layout, error) {
+	path, err := template.New("path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+	fileName, err := template.New("filename").Option("missingkey=error").Parse(fileNameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename template: %w", err)
+	}
+
+	layout := &Layout{path: path, fileName: fileName, hash: hash}
+
+	// Make sure the templates only use the available fields
+	sample := ImageFields{
+		MovieFields: MovieFields{Site: "site", Title: "Title", Year: "2000"},
+		Index:       1,
+		Hash:        SHA256(nil),
+		Name:        "name",
+		Ext:         ".jpg",
+	}
+	if _, err := layout.MoviePath(sample.MovieFields); err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+	if _, err := layout.FileName(sample); err != nil {
+		return nil, fmt.Errorf("invalid filename template: %w", err)
+	}
+
+	return layout, nil
+}
+
+// LayoutFor returns the layout set in the options. Templates are
+// checked when the app starts, the default layout is used if they
+// are invalid anyway. Images named by content ignore the filename
+// template.
+func LayoutFor(options *config.Options) *Layout {
+	pathTemplate, fileNameTemplate := options.PathTemplate, options.FileNameTemplate
+	if pathTemplate == "" {
+		pathTemplate = DefaultPathTemplate
+	}
+	if fileNameTemplate == "" {
+		fileNameTemplate = DefaultFileNameTemplate
+	}
+	if options.Naming == NamingContent {
+		fileNameTemplate = ContentFileNameTemplate
+	}
+
+	key := fmt.Sprint(pathTemplate, "\x00", fileNameTemplate, "\x00", options.Hash)
+	if layout, found := layouts.Load(key); found {
+		return layout.(*Layout)
+	}
+
+	layout, err := NewLayout(pathTemplate, fileNameTemplate, options.Hash)
+	if err != nil {
+		layout, _ = NewLayout(DefaultPathTemplate, DefaultFileNameTemplate, options.Hash)
+	}
+	layouts.Store(key, layout)
+
+	return layout
+}
+
+// BySite tells if movies of different websites are saved in different
+// folders. Otherwise, movies of several websites can share a folder.
+func (l *Layout) BySite() bool {
+	first, err := l.MoviePath(MovieFields{Site: "first", Title: "Title", Year: "2000"})
+	if err != nil {
+		return false
+	}
+	second, err := l.MoviePath(MovieFields{Site: "second", Title: "Title", Year: "2000"})
+	return err == nil && first != second
+}
+
+// MoviePath is the folder of a movie, relative to the data directory
+func (l *Layout) MoviePath(movie MovieFields) (string, error) {
+	var b strings.Builder
+	if err := l.path.Execute(&b, movie); err != nil {
+		return "", err
+	}
+
+	path := filepath.Clean(filepath.FromSlash(b.String()))
+	if path == "." || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("movie folder %q is not inside the data directory", b.String())
+	}
+
+	return path, nil
+}
+
+// FileName is the filename of an image in its movie folder
+func (l *Layout) FileName(image ImageFields) (string, error) {
+	var b strings.Builder
+	if err := l.fileName.Execute(&b, image); err != nil {
+		return "", err
+	}
+
+	// Images are saved in the movie folder itself
+	fileName := strings.NewReplacer("/", "_", "\\", "_").Replace(b.String())
+	if fileName == "" || fileName == "." || fileName == ".." {
+		return "", errors.New("empty filename")
+	}
+
+	return fileName, nil
+}
+
+// ImageFields describes an image of a movie, named rawFileName
+// after its URL, to pick its filename.
+func (l *Layout) ImageFields(movie Movie, rawFileName string, index int, hash string) ImageFields {
+	ext := filepath.Ext(rawFileName)
+	name := strings.TrimSuffix(rawFileName, ext)
+	if l.hash {
+		name = MD5(rawFileName)
+	}
+
+	return ImageFields{
+		MovieFields: movie.Fields(),
+		Index:       index,
+		Hash:        hash,
+		Name:        name,
+		Ext:         ext,
+	}
+}
+
+// RequestFileName is the filename of an image worked out before
+// downloading it, if the filename doesn't depend on its content
+// or on the images saved before.
+func (l *Layout) RequestFileName(movie Movie, rawFileName string) (string, bool) {
+	first, err := l.FileName(l.ImageFields(movie, rawFileName, 1, SHA256([]byte("first"))))
+	if err != nil {
+		return "", false
+	}
+	second, err := l.FileName(l.ImageFields(movie, rawFileName, 2, SHA256([]byte("second"))))
+	if err != nil || first != second {
+		return "", false
+	}
+
+	return first, true
+}
+
+// Fields describes the movie for path and filename templates
+func (m Movie) Fields() MovieFields {
+	return MovieFields{Site: m.Site, Title: m.Name, Year: m.Year}
+}
@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"moviestills/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	movie := Movie{Name: "Alien", Year: "1979", Site: "blubeaver"}
+	hash := SHA256([]byte("content"))
+
+	cases := []struct {
+		name             string
+		pathTemplate     string
+		fileNameTemplate string
+		hash             bool
+		path             string
+		fileName         string
+		beforeDownload   bool
+		bySite           bool
+	}{
+		{"default", DefaultPathTemplate, DefaultFileNameTemplate, false, filepath.Join("blubeaver", "Alien"), "alien_1.jpg", true, true},
+		{"hashed", DefaultPathTemplate, DefaultFileNameTemplate, true, filepath.Join("blubeaver", "Alien"), MD5("alien_1.jpg") + ".jpg", true, true},
+		{
+			"index", "{{.Title}} ({{.Year}})", `{{.Site}}-{{printf "%04d" .Index}}{{.Ext}}`, false,
+			"Alien (1979)", "blubeaver-0003.jpg", false, false,
+		},
+		{"content", "{{.Site}}/{{.Title}}", "{{.Hash}}{{.Ext}}", false, filepath.Join("blubeaver", "Alien"), hash + ".jpg", false, true},
+		{"no subfolders", "{{.Site}}", "{{.Title}}/{{.Name}}{{.Ext}}", false, "blubeaver", "Alien_alien_1.jpg", true, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			layout, err := NewLayout(c.pathTemplate, c.fileNameTemplate, c.hash)
+			if err != nil {
+				t.Fatalf("NewLayout() unexpected error: %v", err)
+			}
+
+			if path, err := layout.MoviePath(movie.Fields()); err != nil || path != c.path {
+				t.Errorf("MoviePath() = %s, %v, expected %s", path, err, c.path)
+			}
+
+			fileName, err := layout.FileName(layout.ImageFields(movie, "alien_1.jpg", 3, hash))
+			if err != nil || fileName != c.fileName {
+				t.Errorf("FileName() = %s, %v, expected %s", fileName, err, c.fileName)
+			}
+
+			requestFileName, known := layout.RequestFileName(movie, "alien_1.jpg")
+			if known != c.beforeDownload || (known && requestFileName != c.fileName) {
+				t.Errorf("RequestFileName() = %s, %v, expected to be known: %v", requestFileName, known, c.beforeDownload)
+			}
+
+			if bySite := layout.BySite(); bySite != c.bySite {
+				t.Errorf("BySite() = %v, expected %v", bySite, c.bySite)
+			}
+		})
+	}
+}
+
+func TestInvalidLayout(t *testing.T) {
+	cases := []struct {
+		name             string
+		pathTemplate     string
+		fileNameTemplate string
+	}{
+		{"syntax", "{{.Site", DefaultFileNameTemplate},
+		{"unknown field", "{{.Director}}", DefaultFileNameTemplate},
+		{"outside the data directory", "../{{.Title}}", DefaultFileNameTemplate},
+		{"absolute", "/tmp/{{.Title}}", DefaultFileNameTemplate},
+		{"empty filename", DefaultPathTemplate, `{{if false}}{{.Name}}{{end}}`},
+	}
+
+	for _, c := range cases {
+		if _, err := NewLayout(c.pathTemplate, c.fileNameTemplate, false); err == nil {
+			t.Errorf("%s: NewLayout() expected an error", c.name)
+		}
+	}
+
+	// Movies are saved with the default layout anyway
+	options := &config.Options{DataDir: "data", PathTemplate: "{{.Site"}
+	movie := NewMovie("Alien", "", "", "blubeaver", options)
+	if expected := filepath.Join("data", "blubeaver", "Alien"); movie.Path != expected {
+		t.Errorf("NewMovie() path = %s, expected %s", movie.Path, expected)
+	}
+}
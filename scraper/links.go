@@ -0,0 +1,344 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// ContentIndexFile lists the images of the data directory by content
+// hash, to find identical images saved for different movies or websites
+const ContentIndexFile string = ".content-index"
+
+// How identical images are linked to each other
+const (
+	LinkAuto     = "auto"
+	LinkHardlink = "hardlink"
+	LinkReflink  = "reflink"
+)
+
+// ErrReflinkUnsupported is returned when the filesystem can't share
+// the content of files
+var ErrReflinkUnsupported = errors.New("reflinks are not supported by this filesystem")
+
+// contentIndexEntry is a line of the content index
+type contentIndexEntry struct {
+	SHA256 string `json:"sha256"`
+	Path   string `json:"path"`
+}
+
+// ContentIndex keeps track of an image for every content hash found in
+// the data directory. Entries are appended to the index file as images
+// are saved, the last entry of a hash being the one used. Entries may
+// be outdated, eg. once images are moved, so files must be checked
+// before being trusted.
+type ContentIndex struct {
+	dataDir string
+	mu      sync.Mutex
+	paths   map[string]string
+	file    *os.File
+}
+
+// OpenContentIndex reads the content index of the data directory,
+// starting a new one if it doesn't exist yet or if reset is set.
+func OpenContentIndex(dataDir string, reset bool) (*ContentIndex, error) {
+	index := &ContentIndex{dataDir: dataDir, paths: make(map[string]string)}
+	path := filepath.Join(dataDir, ContentIndexFile)
+
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if reset {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry contentIndexEntry
+		// Lines cut short by a crash are ignored
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil && entry.SHA256 != "" {
+			index.paths[entry.SHA256] = entry.Path
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	index.file = file
+
+	return index, nil
+}
+
+// Lookup returns the path of an image with the given content hash
+func (i *ContentIndex) Lookup(hash string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	path, found := i.paths[hash]
+	if !found {
+		return "", false
+	}
+	return filepath.Join(i.dataDir, filepath.FromSlash(path)), true
+}
+
+// Add records the image found at path for the given content hash
+func (i *ContentIndex) Add(hash, path string) error {
+	rel, err := filepath.Rel(i.dataDir, path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.paths[hash] == rel {
+		return nil
+	}
+	i.paths[hash] = rel
+
+	// Indexes of dry runs are not saved
+	if i.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(contentIndexEntry{SHA256: hash, Path: rel})
+	if err != nil {
+		return err
+	}
+	_, err = i.file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Close closes the index file
+func (i *ContentIndex) Close() error {
+	if i.file == nil {
+		return nil
+	}
+	return i.file.Close()
+}
+
+// LinkStats counts the files linked for a website, and the space reclaimed
+type LinkStats struct {
+	Site      string
+	Files     int
+	Reclaimed int64
+}
+
+// Linker replaces images identical to one saved before, for any movie
+// of any website, with links to it: reflinks where the filesystem
+// supports them, sharing the content of independent files, or hardlinks.
+// Images are never modified in place, so hardlinked images can't change
+// each other.
+//
+// Linker is a Recorder, so images can be linked as they are saved.
+type Linker struct {
+	index  *ContentIndex
+	mode   string
+	dryRun bool
+	log    *Logger
+
+	mu    sync.Mutex
+	stats map[string]*LinkStats
+}
+
+// NewLinker links identical images found with the index. Nothing is
+// linked on a dry run, only counted.
+func NewLinker(index *ContentIndex, mode string, dryRun bool) *Linker {
+	return &Linker{
+		index:  index,
+		mode:   mode,
+		dryRun: dryRun,
+		log:    NewLogger("links"),
+		stats:  make(map[string]*LinkStats),
+	}
+}
+
+// RecordImage links an image saved for a movie, if identical to another
+// one. Images that can't be linked are kept as they are.
+func (l *Linker) RecordImage(movie Movie, image ManifestImage) error {
+	if _, err := l.LinkFile(movie.Site, filepath.Join(movie.Path, image.FileName), image.SHA256); err != nil {
+		l.log.Warning("Can't link image", pterm.White(image.FileName), "for", MovieField(movie.Name), err)
+	}
+	return nil
+}
+
+// RemoveImage does nothing, outdated entries of the index are ignored
+func (l *Linker) RemoveImage(Movie, string) error {
+	return nil
+}
+
+// LinkFile replaces the file at path, with the given content hash,
+// with a link to an identical file of the index. The file is added to
+// the index otherwise. Returns true if the file was linked.
+func (l *Linker) LinkFile(site, path, hash string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	source, found := l.index.Lookup(hash)
+	if !found || source == path || !sameContent(source, hash, info.Size()) {
+		return false, l.index.Add(hash, path)
+	}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(sourceInfo, info) {
+		return false, nil
+	}
+
+	if !l.dryRun {
+		method, err := linkFile(source, path, l.mode)
+		if err != nil {
+			return false, err
+		}
+		l.log.Debug("Linked", pterm.White(path), "to", pterm.White(source), "with a", method)
+	}
+
+	// Space is only reclaimed once the last link to the file is gone
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats, ok := l.stats[site]
+	if !ok {
+		stats = &LinkStats{Site: site}
+		l.stats[site] = stats
+	}
+	stats.Files++
+	if linkCount(info) <= 1 {
+		stats.Reclaimed += info.Size()
+	}
+
+	return true, nil
+}
+
+// Stats returns what was linked for every website, sorted by website
+func (l *Linker) Stats() []LinkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make([]LinkStats, 0, len(l.stats))
+	for _, s := range l.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Site < stats[j].Site })
+
+	return stats
+}
+
+// sameContent makes sure a file of the index still has the given content
+func sameContent(path, hash string, size int64) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != size {
+		return false
+	}
+	content, err := os.ReadFile(path)
+	return err == nil && SHA256(content) == hash
+}
+
+// linkFile replaces target with a link to source, through a temporary
+// file renamed over target so it's never left missing. Returns the kind
+// of link made.
+func linkFile(source, target, mode string) (string, error) {
+	tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".link")
+	_ = os.Remove(tmp)
+
+	// Reflinks are tried first, as they keep files independent
+	var err error
+	method := LinkReflink
+	if mode == LinkHardlink {
+		err = ErrReflinkUnsupported
+	} else if err = reflink(source, tmp); err != nil {
+		_ = os.Remove(tmp)
+	}
+	if err != nil && mode != LinkReflink {
+		method = LinkHardlink
+		err = os.Link(source, tmp)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+
+	return method, nil
+}
+
+// Dedupe links identical images of the whole data directory, movie
+// folders and their near-duplicates, and indexes them all again.
+func Dedupe(dataDir, mode string, dryRun bool) ([]LinkStats, error) {
+	// The index is rebuilt from scratch, in memory only on a dry run
+	var err error
+	index := &ContentIndex{dataDir: dataDir, paths: make(map[string]string)}
+	if !dryRun {
+		if index, err = OpenContentIndex(dataDir, true); err != nil {
+			return nil, err
+		}
+	}
+	defer index.Close()
+
+	linker := NewLinker(index, mode, dryRun)
+	sites := make(map[string]string)
+	var errs []error
+	err = filepath.WalkDir(dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || !isImageFile(d.Name()) || filepath.Dir(path) == filepath.Clean(dataDir) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		folder := filepath.Dir(path)
+		if _, found := sites[folder]; !found {
+			sites[folder] = siteOf(dataDir, folder)
+		}
+		if _, err := linker.LinkFile(sites[folder], path, SHA256(content)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return linker.Stats(), errors.Join(errs...)
+}
+
+// siteOf tells which website the images of a folder were saved for,
+// from the manifest of their movie or from the path of the folder,
+// laid out as <website>/<movie> before manifests existed.
+func siteOf(dataDir, folder string) string {
+	if filepath.Base(folder) == DuplicatesFolder {
+		folder = filepath.Dir(folder)
+	}
+	if manifest, err := ReadManifest(folder); err == nil && manifest.Site != "" {
+		return manifest.Site
+	}
+
+	rel, err := filepath.Rel(dataDir, folder)
+	if err != nil {
+		return ""
+	}
+	site, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return site
+}
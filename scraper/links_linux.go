@@ -0,0 +1,42 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates target sharing the content of source, on filesystems
+// supporting it such as Btrfs or XFS
+func reflink(source, target string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+		return ErrReflinkUnsupported
+	}
+
+	return err
+}
+
+// linkCount is the number of hard links to a file
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package scraper
+
+import "os"
+
+// reflink is only supported on Linux
+func reflink(source, target string) error {
+	return ErrReflinkUnsupported
+}
+
+// linkCount is the number of hard links to a file, unknown here
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
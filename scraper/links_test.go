@@ -0,0 +1,95 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(path, content string) string {
+		t.Helper()
+		path = filepath.Join(dataDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sameFile := func(a, b string) bool {
+		t.Helper()
+		infoA, errA := os.Stat(a)
+		infoB, errB := os.Stat(b)
+		return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+	}
+
+	// The same still reviewed by two websites, and by the same one again
+	blubeaver := write("blubeaver/Alien/alien_1.jpg", "first still")
+	dvdbeaver := write("dvdbeaver/Alien/a1.jpg", "first still")
+	duplicate := write("dvdbeaver/Alien/duplicates/a1_small.jpg", "first still")
+	write("dvdbeaver/Alien/a2.jpg", "second still")
+	write("catalog.db", "first still")
+
+	stats, err := Dedupe(dataDir, LinkHardlink, true)
+	if err != nil || len(stats) != 1 || stats[0].Site != "dvdbeaver" || stats[0].Files != 2 {
+		t.Fatalf("Dedupe() dry run = %+v, %v", stats, err)
+	}
+	if sameFile(blubeaver, dvdbeaver) {
+		t.Fatal("Files linked on a dry run")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, ContentIndexFile)); !os.IsNotExist(err) {
+		t.Fatal("Index saved on a dry run")
+	}
+
+	stats, err = Dedupe(dataDir, LinkHardlink, false)
+	if err != nil || len(stats) != 1 || stats[0].Files != 2 || stats[0].Reclaimed != int64(2*len("first still")) {
+		t.Fatalf("Dedupe() = %+v, %v", stats, err)
+	}
+	if !sameFile(blubeaver, dvdbeaver) || !sameFile(blubeaver, duplicate) {
+		t.Fatal("Identical files not linked")
+	}
+
+	// Nothing left to link, new identical images are linked as they are saved
+	if stats, err := Dedupe(dataDir, LinkAuto, false); err != nil || len(stats) != 0 {
+		t.Fatalf("Dedupe() again = %+v, %v", stats, err)
+	}
+
+	index, err := OpenContentIndex(dataDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	linker := NewLinker(index, LinkAuto, false)
+
+	path := write("film-grab/Alien/alien-1.jpg", "first still")
+	movie := Movie{Name: "Alien", Site: "film-grab", Path: filepath.Dir(path)}
+	if err := linker.RecordImage(movie, ManifestImage{FileName: "alien-1.jpg", SHA256: SHA256([]byte("first still"))}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := linker.Stats(); len(stats) != 1 || stats[0].Site != "film-grab" || stats[0].Files != 1 {
+		t.Fatalf("Image not linked as it is saved: %+v", stats)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "first still" {
+		t.Fatalf("Linked image content = %q, %v", content, err)
+	}
+
+	// Outdated entries of the index are not trusted
+	write("blubeaver/Alien/alien_1.jpg", "changed")
+	if err := os.Remove(dvdbeaver); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(duplicate); err != nil {
+		t.Fatal(err)
+	}
+	other := write("blubeaver/Brazil/brazil.jpg", "second still")
+	if linked, err := linker.LinkFile("blubeaver", other, SHA256([]byte("second still"))); err != nil || !linked {
+		t.Fatalf("LinkFile() = %v, %v", linked, err)
+	}
+	stale := write("blubeaver/Brazil/first.jpg", "first still")
+	if linked, err := linker.LinkFile("blubeaver", stale, SHA256([]byte("first still"))); err != nil || linked {
+		t.Fatalf("Image linked to an outdated entry of the index: %v, %v", linked, err)
+	}
+}
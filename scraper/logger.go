@@ -1,44 +1,155 @@
 package scraper
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/gocolly/colly/v2"
 	"github.com/pterm/pterm"
 )
 
-// Logger provides website-prefixed logging
+// Log formats
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// Fields of structured log records
+const (
+	siteField     = "site"
+	movieField    = "movie"
+	urlField      = "url"
+	statusField   = "status"
+	errorField    = "error"
+	durationField = "duration"
+	successField  = "success"
+)
+
+// Where log records go besides the terminal, set once when the app starts
+var logging struct {
+	handler slog.Handler
+	quiet   bool
+}
+
+// SetupLogging sends log records to the handler too, eg. to a JSON log
+// file. The pretty terminal output is turned off if quiet is set.
+func SetupLogging(handler slog.Handler, quiet bool) {
+	logging.handler = handler
+	logging.quiet = quiet
+}
+
+// Logger provides website-prefixed logging. Movies, URLs, HTTP status,
+// errors and durations given to it are recorded as fields of structured
+// logs, and printed among the other arguments in the terminal.
 type Logger struct {
+	site   string
 	prefix string
 }
 
 // NewLogger creates a logger with a website prefix
 func NewLogger(website string) *Logger {
 	return &Logger{
+		site:   website,
 		prefix: fmt.Sprintf("[%s]", website),
 	}
 }
 
+// MovieField is the name of a movie to log
+func MovieField(name string) slog.Attr {
+	return slog.String(movieField, name)
+}
+
+// URLField is the URL of a page or image to log
+func URLField(u string) slog.Attr {
+	return slog.String(urlField, u)
+}
+
+// StatusField is the HTTP status of a response to log
+func StatusField(code int) slog.Attr {
+	return slog.Int(statusField, code)
+}
+
 // Info logs an info message with website prefix
 func (l *Logger) Info(args ...interface{}) {
-	pterm.Info.Println(append([]interface{}{pterm.Cyan(l.prefix)}, args...)...)
+	l.log(pterm.Info, slog.LevelInfo, args)
 }
 
 // Debug logs a debug message with website prefix
 func (l *Logger) Debug(args ...interface{}) {
-	pterm.Debug.Println(append([]interface{}{pterm.Cyan(l.prefix)}, args...)...)
+	l.log(pterm.Debug, slog.LevelDebug, args)
 }
 
 // Error logs an error message with website prefix
 func (l *Logger) Error(args ...interface{}) {
-	pterm.Error.Println(append([]interface{}{pterm.Cyan(l.prefix)}, args...)...)
+	l.log(pterm.Error, slog.LevelError, args)
 }
 
 // Success logs a success message with website prefix
 func (l *Logger) Success(args ...interface{}) {
-	pterm.Success.Println(append([]interface{}{pterm.Cyan(l.prefix)}, args...)...)
+	l.log(pterm.Success, slog.LevelInfo, args, slog.Bool(successField, true))
 }
 
 // Warning logs a warning message with website prefix
 func (l *Logger) Warning(args ...interface{}) {
-	pterm.Warning.Println(append([]interface{}{pterm.Cyan(l.prefix)}, args...)...)
+	l.log(pterm.Warning, slog.LevelWarn, args)
+}
+
+// log prints a message in the terminal and records it
+func (l *Logger) log(printer pterm.PrefixPrinter, level slog.Level, args []interface{}, extra ...slog.Attr) {
+	texts := make([]interface{}, 0, len(args)+1)
+	texts = append(texts, pterm.Cyan(l.prefix))
+	attrs := append([]slog.Attr{slog.String(siteField, l.site)}, extra...)
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case slog.Attr:
+			attrs = append(attrs, v)
+			if v.Key == movieField {
+				texts = append(texts, pterm.Blue(v.Value))
+			} else {
+				texts = append(texts, pterm.White(v.Value))
+			}
+		case error:
+			attrs = append(attrs, slog.String(errorField, v.Error()))
+			texts = append(texts, pterm.Red(v))
+		case time.Duration:
+			attrs = append(attrs, slog.Duration(durationField, v))
+			texts = append(texts, pterm.White(v))
+		default:
+			texts = append(texts, arg)
+		}
+	}
+
+	if !logging.quiet {
+		printer.Println(texts...)
+	}
+
+	handler := logging.handler
+	if handler == nil || !handler.Enabled(context.Background(), level) {
+		return
+	}
+	message := pterm.RemoveColorFromString(strings.TrimSpace(fmt.Sprintln(texts[1:]...)))
+	record := slog.NewRecord(time.Now(), level, message, 0)
+	record.AddAttrs(attrs...)
+	_ = handler.Handle(context.Background(), record)
+}
+
+// Context key of when requests were sent
+const requestStartKey = "request_start:"
+
+// startRequest remembers when a request was sent
+func startRequest(r *colly.Request) {
+	r.Ctx.Put(requestStartKey+r.URL.String(), time.Now())
+}
+
+// RequestDuration is how long it took to get a response, if known
+func RequestDuration(r *colly.Request) time.Duration {
+	start, ok := r.Ctx.GetAny(requestStartKey + r.URL.String()).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(start).Round(time.Millisecond)
 }
@@ -0,0 +1,54 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestLoggerRecords(t *testing.T) {
+	var buf bytes.Buffer
+	SetupLogging(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}), true)
+	t.Cleanup(func() { SetupLogging(nil, false) })
+
+	log := NewLogger("blubeaver")
+	log.Debug("not recorded", URLField("https://example.com/debug"))
+	log.Error("Can't get image", URLField("https://example.com/alien_1.jpg"), "for", MovieField("Alien"),
+		StatusField(404), 1500*time.Millisecond, errors.New("not found"))
+	log.Success("Saved image for", MovieField("Alien"), "alien_1.jpg")
+
+	var records []map[string]any
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Invalid JSON record: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Got %d records, expected 2: %v", len(records), records)
+	}
+
+	expected := map[string]any{
+		"level":    "ERROR",
+		"msg":      "Can't get image https://example.com/alien_1.jpg for Alien 404 1.5s not found",
+		"site":     "blubeaver",
+		"movie":    "Alien",
+		"url":      "https://example.com/alien_1.jpg",
+		"status":   float64(404),
+		"duration": float64(1500 * time.Millisecond),
+		"error":    "not found",
+	}
+	for key, value := range expected {
+		if records[0][key] != value {
+			t.Errorf("Error record %s = %v, expected %v", key, records[0][key], value)
+		}
+	}
+	if records[1]["level"] != "INFO" || records[1]["success"] != true || records[1]["movie"] != "Alien" {
+		t.Errorf("Unexpected success record: %v", records[1])
+	}
+}
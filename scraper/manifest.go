@@ -0,0 +1,360 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// ManifestFile is the name of the file describing a movie folder
+const ManifestFile string = "movie.json"
+
+// Manifest keeps track of where a movie and its images came from.
+// Movies of several websites share a folder when the path template
+// doesn't tell them apart: the manifest then describes the movie of
+// the first website, and each image tells which website it came from.
+type Manifest struct {
+	Site   string          `json:"site"`
+	Title  string          `json:"title"`
+	Year   string          `json:"year,omitempty"`
+	URL    string          `json:"url"`
+	Images []ManifestImage `json:"images"`
+
+	// Near-identical images of others, not kept in the movie folder
+	Duplicates []ManifestImage `json:"duplicates,omitempty"`
+}
+
+// ManifestImage describes an image saved in a movie folder
+type ManifestImage struct {
+	// Website the image was downloaded for, the site of the
+	// manifest for images saved before it was recorded.
+	Site string `json:"site,omitempty"`
+
+	URL          string    `json:"url"`
+	FileName     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	SHA256       string    `json:"sha256"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+
+	// Perceptual hash of the image, to find near-identical images
+	DHash string `json:"dhash,omitempty"`
+
+	// Filename of the image this one is a near-duplicate of
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	// Filename of the image named after its URL, before applying
+	// the filename template, eg. when naming images by content.
+	OriginalName string `json:"original_name,omitempty"`
+
+	// Other URLs the very same image was downloaded from
+	OtherURLs []string `json:"other_urls,omitempty"`
+
+	// URLs the image was downloaded from, serving another image since
+	FormerURLs []string `json:"former_urls,omitempty"`
+}
+
+// Images of a same movie can be saved concurrently in async
+// mode, so updates of a manifest are serialized by movie folder.
+var manifestLocks sync.Map
+
+// Manifests of the movies being scraped, read once and kept up to
+// date in memory until the movies are done.
+var openManifests sync.Map
+
+// SHA256 generates a SHA-256 hash for the given content
+func SHA256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewManifestImage describes an image downloaded with the given response
+func NewManifestImage(r *colly.Response, fileName string) ManifestImage {
+	width, height := ImageSize(r.Body)
+	return ManifestImage{
+		URL:          r.Request.URL.String(),
+		FileName:     fileName,
+		Size:         int64(len(r.Body)),
+		Width:        width,
+		Height:       height,
+		SHA256:       SHA256(r.Body),
+		LastModified: r.Headers.Get("Last-Modified"),
+		ETag:         r.Headers.Get("ETag"),
+		FetchedAt:    time.Now().UTC(),
+	}
+}
+
+// ReadManifest reads the manifest of a movie folder. An empty
+// manifest is returned if the folder doesn't have one yet.
+func ReadManifest(moviePath string) (*Manifest, error) {
+	manifest := &Manifest{}
+
+	content, err := os.ReadFile(filepath.Join(moviePath, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Write saves the manifest in a movie folder. The file is replaced
+// atomically so it's never left half-written.
+func (m *Manifest) Write(moviePath string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(moviePath, ManifestFile), append(content, '\n'))
+}
+
+// writeFileAtomic writes a file through a hidden temporary file renamed
+// once written, so a file is never left half-written, even when the
+// app is killed.
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Image returns the image saved with the given filename, if any
+func (m *Manifest) Image(fileName string) (ManifestImage, bool) {
+	for _, image := range m.Images {
+		if image.FileName == fileName {
+			return image, true
+		}
+	}
+	return ManifestImage{}, false
+}
+
+// ImageByURL returns the image saved from the given URL, if any
+func (m *Manifest) ImageByURL(imageURL string) (ManifestImage, bool) {
+	for _, image := range m.Images {
+		if image.HasURL(imageURL) {
+			return image, true
+		}
+	}
+	return ManifestImage{}, false
+}
+
+// HasURL tells if the image was downloaded from the given URL
+func (i ManifestImage) HasURL(imageURL string) bool {
+	return i.URL == imageURL || slices.Contains(i.OtherURLs, imageURL)
+}
+
+// AddURL records another URL the image saved with the given filename
+// was downloaded from. Returns false if the URL was already known.
+func (m *Manifest) AddURL(fileName, imageURL string) bool {
+	for i := range m.Images {
+		if m.Images[i].FileName != fileName {
+			continue
+		}
+		if imageURL == "" || m.Images[i].HasURL(imageURL) {
+			return false
+		}
+		m.Images[i].FormerURLs = slices.DeleteFunc(m.Images[i].FormerURLs, func(u string) bool { return u == imageURL })
+		if m.Images[i].URL == "" {
+			m.Images[i].URL = imageURL
+		} else {
+			m.Images[i].OtherURLs = append(m.Images[i].OtherURLs, imageURL)
+		}
+		return true
+	}
+	return false
+}
+
+// DetachURL records that the image saved with the given filename is no
+// longer served by a URL, eg. once the URL serves another image. The
+// image stays in the manifest, even without any URL left.
+func (m *Manifest) DetachURL(fileName, imageURL string) {
+	for i := range m.Images {
+		image := &m.Images[i]
+		if image.FileName != fileName || !image.HasURL(imageURL) {
+			continue
+		}
+
+		image.OtherURLs = slices.DeleteFunc(image.OtherURLs, func(u string) bool { return u == imageURL })
+		if image.URL == imageURL {
+			image.URL = ""
+			if len(image.OtherURLs) > 0 {
+				image.URL, image.OtherURLs = image.OtherURLs[0], image.OtherURLs[1:]
+			}
+		}
+		image.FormerURLs = append(image.FormerURLs, imageURL)
+		return
+	}
+}
+
+// AddImage adds an image to the manifest, replacing any
+// previous image saved with the same filename.
+func (m *Manifest) AddImage(image ManifestImage) {
+	for i := range m.Images {
+		if m.Images[i].FileName == image.FileName {
+			m.Images[i] = image
+			return
+		}
+	}
+	m.Images = append(m.Images, image)
+}
+
+// RemoveImage removes an image from the manifest
+func (m *Manifest) RemoveImage(fileName string) {
+	for i := range m.Images {
+		if m.Images[i].FileName == fileName {
+			m.Images = append(m.Images[:i], m.Images[i+1:]...)
+			return
+		}
+	}
+}
+
+// Duplicate returns the duplicate found with the given filename, if any
+func (m *Manifest) Duplicate(fileName string) (ManifestImage, bool) {
+	for _, image := range m.Duplicates {
+		if image.FileName == fileName {
+			return image, true
+		}
+	}
+	return ManifestImage{}, false
+}
+
+// DuplicateByURL returns the duplicate found at the given URL, if any
+func (m *Manifest) DuplicateByURL(imageURL string) (ManifestImage, bool) {
+	for _, image := range m.Duplicates {
+		if image.HasURL(imageURL) {
+			return image, true
+		}
+	}
+	return ManifestImage{}, false
+}
+
+// AddDuplicate adds a duplicate to the manifest, replacing any
+// previous duplicate found with the same filename.
+func (m *Manifest) AddDuplicate(image ManifestImage) {
+	for i := range m.Duplicates {
+		if m.Duplicates[i].FileName == image.FileName {
+			m.Duplicates[i] = image
+			return
+		}
+	}
+	m.Duplicates = append(m.Duplicates, image)
+}
+
+// SiteOf tells which website an image of the manifest came from
+func (m *Manifest) SiteOf(image ManifestImage) string {
+	if image.Site != "" {
+		return image.Site
+	}
+	return m.Site
+}
+
+// describe sets what the manifest knows about the movie, unless
+// it describes the movie of another website sharing the folder
+func (m *Manifest) describe(movie Movie) {
+	if m.Site != "" && m.Site != movie.Site {
+		return
+	}
+	m.Site = movie.Site
+	m.Title = movie.Name
+	m.Year = movie.Year
+	if movie.URL != "" {
+		m.URL = movie.URL
+	}
+}
+
+// lockManifest locks the manifest of a movie folder until
+// the returned function is called.
+func lockManifest(moviePath string) func() {
+	lock, _ := manifestLocks.LoadOrStore(moviePath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// openManifest returns the manifest of a movie being scraped, read
+// once and kept in memory until closed. The manifest must be locked.
+func openManifest(moviePath string) (*Manifest, error) {
+	if cached, found := openManifests.Load(moviePath); found {
+		return cached.(*Manifest), nil
+	}
+
+	manifest, err := ReadManifest(moviePath)
+	if err != nil {
+		return nil, err
+	}
+	openManifests.Store(moviePath, manifest)
+
+	return manifest, nil
+}
+
+// closeManifest forgets the manifest of a movie kept in memory, once
+// the movie is done. It is read again if the movie is scraped again.
+func closeManifest(moviePath string) {
+	unlock := lockManifest(moviePath)
+	defer unlock()
+
+	openManifests.Delete(moviePath)
+}
+
+// UpdateManifest safely reads, updates and writes back the manifest
+// of a movie folder, even when called concurrently. The manifest kept
+// in memory for a movie being scraped is replaced too.
+func UpdateManifest(moviePath string, update func(*Manifest)) error {
+	unlock := lockManifest(moviePath)
+	defer unlock()
+
+	manifest, err := ReadManifest(moviePath)
+	if err != nil {
+		return err
+	}
+
+	update(manifest)
+
+	if err := manifest.Write(moviePath); err != nil {
+		return err
+	}
+	if _, open := openManifests.Load(moviePath); open {
+		openManifests.Store(moviePath, manifest)
+	}
+	return nil
+}
+
+// RecordImage adds an image saved for a movie to its manifest
+func RecordImage(movie Movie, image ManifestImage) error {
+	image.Site = movie.Site
+	return UpdateManifest(movie.Path, func(m *Manifest) {
+		m.describe(movie)
+		m.AddImage(image)
+	})
+}
@@ -0,0 +1,112 @@
+package scraper
+
+import (
+	"fmt"
+	"moviestills/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Images of a same movie saved concurrently must all be recorded
+func TestRecordImageConcurrently(t *testing.T) {
+	movie := Movie{
+		Name: "Alien",
+		Year: "1979",
+		URL:  "https://example.com/alien",
+		Path: t.TempDir(),
+		Site: "example",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			image := ManifestImage{FileName: fmt.Sprintf("%02d.jpg", i), Size: int64(i)}
+			if err := RecordImage(movie, image); err != nil {
+				t.Errorf("RecordImage() unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	manifest, err := ReadManifest(movie.Path)
+	if err != nil {
+		t.Fatalf("ReadManifest() unexpected error: %v", err)
+	}
+	if len(manifest.Images) != 50 {
+		t.Errorf("Number of images recorded is different than 50: %d", len(manifest.Images))
+	}
+	if manifest.Site != "example" || manifest.Year != "1979" || manifest.URL != movie.URL {
+		t.Errorf("Manifest doesn't describe the movie: %+v", manifest)
+	}
+
+	// Saving an image again replaces it
+	if err := RecordImage(movie, ManifestImage{FileName: "01.jpg", Size: 42}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ = ReadManifest(movie.Path)
+	if image, _ := manifest.Image("01.jpg"); len(manifest.Images) != 50 || image.Size != 42 {
+		t.Errorf("Image saved again was not replaced: %d images, size %d", len(manifest.Images), image.Size)
+	}
+}
+
+// Movies of several websites sharing a folder keep track of their images
+func TestRecordImageSharedFolder(t *testing.T) {
+	path := t.TempDir()
+	first := Movie{Name: "Alien", URL: "https://blubeaver.example.com/alien.htm", Path: path, Site: "blubeaver"}
+	second := Movie{Name: "Alien", URL: "https://dvdbeaver.example.com/alien.htm", Path: path, Site: "dvdbeaver"}
+
+	if err := RecordImage(first, ManifestImage{FileName: "01.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordImage(second, ManifestImage{FileName: "02.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Site != first.Site || manifest.URL != first.URL {
+		t.Errorf("Manifest describes %s, %s, expected the movie of the first website", manifest.Site, manifest.URL)
+	}
+	if len(manifest.Images) != 2 || manifest.SiteOf(manifest.Images[0]) != first.Site || manifest.SiteOf(manifest.Images[1]) != second.Site {
+		t.Errorf("Images don't tell which website they came from: %+v", manifest.Images)
+	}
+}
+
+// Manifests of movies being scraped are read once, until the movie is done
+func TestOpenManifest(t *testing.T) {
+	movie := Movie{Name: "Alien", URL: "https://example.com/alien", Path: t.TempDir(), Site: "example"}
+	body := []byte("still")
+	image := ManifestImage{URL: "https://example.com/01.jpg", FileName: "01.jpg", Size: int64(len(body)), SHA256: SHA256(body)}
+
+	if _, err := SaveUniqueImage(movie, image, body, &config.Options{}, NewLogger("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest in memory is kept up to date, not read again
+	path := filepath.Join(movie.Path, ManifestFile)
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := openManifest(movie.Path)
+	if err != nil {
+		t.Fatalf("openManifest() read the manifest again: %v", err)
+	}
+	if !manifest.HasImage(movie.Path, image.URL, "", false) {
+		t.Error("Image saved is missing from the manifest in memory")
+	}
+
+	closeManifest(movie.Path)
+	if _, open := openManifests.Load(movie.Path); open {
+		t.Error("Manifest still in memory once closed")
+	}
+	if _, err := openManifest(movie.Path); err == nil {
+		t.Error("openManifest() of a closed manifest didn't read it again")
+	}
+	closeManifest(movie.Path)
+}
@@ -0,0 +1,466 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Move of an image when laying out the data directory again
+type Move struct {
+	From string
+	To   string
+}
+
+// Relayout moves and renames the movies and images saved in the data
+// directory to follow the layout, eg. after changing the templates.
+// Movies are described by their manifest. Folders without one, saved
+// before manifests existed, are expected to be <site>/<movie> folders.
+// Folders shared by movies of several websites are split when the
+// layout tells them apart.
+//
+// Returns the images moved, or to move if dryRun is set. Movies that
+// can't be moved are left as is, and reported in the error.
+func Relayout(dataDir string, layout *Layout, dryRun bool) ([]Move, error) {
+	folders, err := movieFolders(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Movies moved to a folder already taken are left as is
+	taken := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		taken[folder] = true
+	}
+
+	var moves []Move
+	var errs []error
+	for _, folder := range folders {
+		movie, manifest, err := describeFolder(dataDir, folder)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", folder, err))
+			continue
+		}
+
+		plans, err := planFolder(dataDir, folder, movie, manifest, layout, taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", folder, err))
+			continue
+		}
+		for _, plan := range plans {
+			moves = append(moves, plan.moves...)
+		}
+		if dryRun || len(plans) == 0 {
+			continue
+		}
+
+		stays := false
+		for _, plan := range plans {
+			if err = plan.apply(dataDir, folder); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", folder, err))
+				break
+			}
+			taken[plan.folder] = true
+			stays = stays || plan.folder == folder
+		}
+		if err == nil && !stays {
+			delete(taken, folder)
+		}
+	}
+
+	return moves, errors.Join(errs...)
+}
+
+// movieFolders lists the folders of the data directory holding
+// images or a manifest, except folders of near-duplicates.
+func movieFolders(dataDir string) ([]string, error) {
+	found := make(map[string]bool)
+	err := filepath.WalkDir(dataDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == DuplicatesFolder {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || filepath.Dir(path) == filepath.Clean(dataDir) {
+			return nil
+		}
+		if d.Name() == ManifestFile || isImageFile(d.Name()) {
+			found[filepath.Dir(path)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]string, 0, len(found))
+	for folder := range found {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	return folders, nil
+}
+
+// isImageFile tells if a file of a movie folder is a movie still
+func isImageFile(fileName string) bool {
+	return !strings.HasPrefix(fileName, ".") && !strings.HasPrefix(fileName, ManifestFile)
+}
+
+// describeFolder reads the manifest of a movie folder, completed with
+// the images saved before manifests existed.
+func describeFolder(dataDir, folder string) (Movie, *Manifest, error) {
+	manifest, err := ReadManifest(folder)
+	if err != nil {
+		return Movie{}, nil, err
+	}
+
+	// Old downloads only have their path to tell what they are
+	if manifest.Site == "" || manifest.Title == "" {
+		rel, err := filepath.Rel(dataDir, folder)
+		if err != nil {
+			return Movie{}, nil, err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 2 {
+			return Movie{}, nil, errors.New("not a <site>/<movie> folder and no manifest to describe it")
+		}
+		manifest.Site, manifest.Title = parts[0], parts[1]
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return Movie{}, nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isImageFile(entry.Name()) {
+			continue
+		}
+		if _, recorded := manifest.Image(entry.Name()); recorded {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(folder, entry.Name()))
+		if err != nil {
+			return Movie{}, nil, err
+		}
+		width, height := ImageSize(content)
+		manifest.AddImage(ManifestImage{
+			FileName: entry.Name(),
+			Size:     int64(len(content)),
+			Width:    width,
+			Height:   height,
+			SHA256:   SHA256(content),
+		})
+	}
+
+	movie := Movie{
+		Name: manifest.Title,
+		Year: manifest.Year,
+		URL:  manifest.URL,
+		Path: folder,
+		Site: manifest.Site,
+	}
+
+	return movie, manifest, nil
+}
+
+// folderPart is the movie of a website saved in a folder
+type folderPart struct {
+	movie    Movie
+	manifest *Manifest
+}
+
+// splitFolder splits a folder shared by movies of several websites, eg.
+// saved with a path template without .Site, into the movies moved to
+// different folders by the layout. Images go with the movie of the
+// website they came from.
+func splitFolder(movie Movie, manifest *Manifest, layout *Layout) ([]folderPart, error) {
+	var parts []folderPart
+	byPath := make(map[string]int)
+	partOf := func(site string) (*Manifest, error) {
+		siteMovie := movie
+		if site != movie.Site {
+			siteMovie.Site, siteMovie.URL = site, ""
+		}
+		path, err := layout.MoviePath(siteMovie.Fields())
+		if err != nil {
+			return nil, err
+		}
+		i, found := byPath[path]
+		if !found {
+			i = len(parts)
+			byPath[path] = i
+			parts = append(parts, folderPart{
+				movie:    siteMovie,
+				manifest: &Manifest{Site: siteMovie.Site, Title: manifest.Title, Year: manifest.Year, URL: siteMovie.URL},
+			})
+		}
+		return parts[i].manifest, nil
+	}
+
+	for _, image := range manifest.Images {
+		part, err := partOf(manifest.SiteOf(image))
+		if err != nil {
+			return nil, err
+		}
+		part.Images = append(part.Images, image)
+	}
+	for _, image := range manifest.Duplicates {
+		part, err := partOf(manifest.SiteOf(image))
+		if err != nil {
+			return nil, err
+		}
+		part.Duplicates = append(part.Duplicates, image)
+	}
+
+	// Movies staying together keep the manifest as it is
+	if len(parts) <= 1 {
+		return []folderPart{{movie: movie, manifest: manifest}}, nil
+	}
+	return parts, nil
+}
+
+// planFolder works out where the movies of a folder and their images
+// are moved, nowhere if they stay as they are. The movie staying in
+// the folder, if any, comes last, so it keeps the manifest of the
+// folder once the others are moved.
+func planFolder(dataDir, folder string, movie Movie, manifest *Manifest, layout *Layout, taken map[string]bool) ([]*relayoutPlan, error) {
+	parts, err := splitFolder(movie, manifest, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*relayoutPlan
+	for _, part := range parts {
+		plan, err := planRelayout(dataDir, part.movie, part.manifest, layout)
+		if err != nil {
+			return nil, err
+		}
+		if plan.folder != folder && taken[plan.folder] {
+			return nil, fmt.Errorf("%s is already taken by another movie", plan.folder)
+		}
+		plans = append(plans, plan)
+	}
+	if len(plans) == 1 && len(plans[0].moves) == 0 {
+		return nil, nil
+	}
+
+	slices.SortStableFunc(plans, func(a, b *relayoutPlan) int {
+		switch {
+		case a.folder == folder && b.folder != folder:
+			return 1
+		case a.folder != folder && b.folder == folder:
+			return -1
+		}
+		return 0
+	})
+
+	return plans, nil
+}
+
+// relayoutPlan tells where a movie and its images are moved
+type relayoutPlan struct {
+	folder   string
+	manifest *Manifest
+
+	// New filenames of images and duplicates, by current filename
+	images     map[string]string
+	duplicates map[string]string
+
+	moves []Move
+}
+
+// planRelayout works out where a movie and its images are moved
+func planRelayout(dataDir string, movie Movie, manifest *Manifest, layout *Layout) (*relayoutPlan, error) {
+	path, err := layout.MoviePath(movie.Fields())
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &relayoutPlan{
+		folder:     filepath.Join(dataDir, path),
+		manifest:   manifest,
+		images:     make(map[string]string),
+		duplicates: make(map[string]string),
+	}
+
+	// Images are numbered in the order they were saved,
+	// near-duplicates coming after. Identical images ending up
+	// with the same name, eg. when named by content, are merged.
+	used := make(map[string]string)
+	name := func(image ManifestImage, index int) (string, error) {
+		var fields ImageFields
+		if image.OriginalName != "" {
+			fields = layout.ImageFields(movie, image.OriginalName, index, image.SHA256)
+		} else {
+			// Without its URL, the current filename is the original one
+			fields = layout.ImageFields(movie, image.FileName, index, image.SHA256)
+			fields.Name = strings.TrimSuffix(image.FileName, filepath.Ext(image.FileName))
+		}
+
+		fileName, err := layout.FileName(fields)
+		if err != nil {
+			return "", err
+		}
+		if hash, taken := used[fileName]; taken && hash != image.SHA256 {
+			fileName = suffixedFileName(fileName, image.SHA256)
+		}
+		used[fileName] = image.SHA256
+
+		return fileName, nil
+	}
+
+	for i, image := range manifest.Images {
+		if image.SHA256 == "" {
+			content, err := os.ReadFile(filepath.Join(movie.Path, image.FileName))
+			if err != nil {
+				return nil, err
+			}
+			manifest.Images[i].SHA256, image.SHA256 = SHA256(content), SHA256(content)
+		}
+		if image.OriginalName == "" {
+			manifest.Images[i].OriginalName, image.OriginalName = originalName(image.URL), originalName(image.URL)
+		}
+
+		fileName, err := name(image, i+1)
+		if err != nil {
+			return nil, err
+		}
+		plan.images[image.FileName] = fileName
+		plan.addMove(filepath.Join(movie.Path, image.FileName), filepath.Join(plan.folder, fileName))
+	}
+
+	// Near-duplicates have a folder of their own
+	used = make(map[string]string)
+	for i, image := range manifest.Duplicates {
+		if image.OriginalName == "" {
+			manifest.Duplicates[i].OriginalName, image.OriginalName = originalName(image.URL), originalName(image.URL)
+		}
+		fileName, err := name(image, len(manifest.Images)+i+1)
+		if err != nil {
+			return nil, err
+		}
+		plan.duplicates[image.FileName] = fileName
+
+		// Duplicates skipped entirely have no file to move
+		from := filepath.Join(movie.Path, DuplicatesFolder, image.FileName)
+		if _, err := os.Stat(from); err == nil {
+			plan.addMove(from, filepath.Join(plan.folder, DuplicatesFolder, fileName))
+		}
+	}
+
+	return plan, nil
+}
+
+// originalName is the filename of an image named after its URL, if known
+func originalName(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if imageURL == "" || err != nil {
+		return ""
+	}
+	return RequestFileName(u)
+}
+
+// addMove plans to move a file, unless it stays where it is
+func (p *relayoutPlan) addMove(from, to string) {
+	if from != to {
+		p.moves = append(p.moves, Move{From: from, To: to})
+	}
+}
+
+// apply moves the images of a movie folder and updates its manifest.
+// Images are first moved to temporary names, so images swapping their
+// names don't overwrite each other.
+func (p *relayoutPlan) apply(dataDir, folder string) error {
+	temporary := make([]string, len(p.moves))
+	for i, move := range p.moves {
+		if err := os.MkdirAll(filepath.Dir(move.To), os.ModePerm); err != nil {
+			return err
+		}
+		temporary[i] = filepath.Join(filepath.Dir(move.To), ".relayout-"+strconv.Itoa(i))
+		if err := os.Rename(move.From, temporary[i]); err != nil {
+			return err
+		}
+	}
+	for i, move := range p.moves {
+		if err := os.Rename(temporary[i], move.To); err != nil {
+			return err
+		}
+	}
+
+	// The manifest follows the images
+	for i, image := range p.manifest.Images {
+		p.manifest.Images[i].FileName = p.images[image.FileName]
+	}
+	for i, image := range p.manifest.Duplicates {
+		p.manifest.Duplicates[i].FileName = p.duplicates[image.FileName]
+		if fileName, found := p.images[image.DuplicateOf]; found {
+			p.manifest.Duplicates[i].DuplicateOf = fileName
+		}
+	}
+	p.manifest.Images = mergeImages(p.manifest.Images)
+	p.manifest.Duplicates = mergeImages(p.manifest.Duplicates)
+
+	if err := os.MkdirAll(p.folder, os.ModePerm); err != nil {
+		return err
+	}
+	if err := p.manifest.Write(p.folder); err != nil {
+		return err
+	}
+
+	if p.folder != folder {
+		if err := os.Remove(filepath.Join(folder, ManifestFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyFolders(dataDir, filepath.Join(folder, DuplicatesFolder))
+	}
+
+	return nil
+}
+
+// mergeImages merges identical images saved under the same filename
+// into the first one, keeping the URLs and former URLs of the others.
+func mergeImages(images []ManifestImage) []ManifestImage {
+	merged := make([]ManifestImage, 0, len(images))
+	for _, image := range images {
+		i := slices.IndexFunc(merged, func(m ManifestImage) bool { return m.FileName == image.FileName })
+		if i < 0 {
+			merged = append(merged, image)
+			continue
+		}
+		for _, imageURL := range append([]string{image.URL}, image.OtherURLs...) {
+			switch {
+			case imageURL == "" || merged[i].HasURL(imageURL):
+			case merged[i].URL == "":
+				merged[i].URL = imageURL
+			default:
+				merged[i].OtherURLs = append(merged[i].OtherURLs, imageURL)
+			}
+		}
+		for _, formerURL := range image.FormerURLs {
+			if !merged[i].HasURL(formerURL) && !slices.Contains(merged[i].FormerURLs, formerURL) {
+				merged[i].FormerURLs = append(merged[i].FormerURLs, formerURL)
+			}
+		}
+	}
+	return merged
+}
+
+// removeEmptyFolders removes a folder and its parents,
+// up to the data directory, as long as they are empty.
+func removeEmptyFolders(dataDir, folder string) {
+	dataDir = filepath.Clean(dataDir)
+	for folder = filepath.Clean(folder); folder != dataDir && strings.HasPrefix(folder, dataDir); folder = filepath.Dir(folder) {
+		if err := os.Remove(folder); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package scraper
+
+import (
+	"moviestills/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// listFiles lists the files of a directory, relative to it
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func TestRelayout(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(dataDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A movie with its manifest and a near-duplicate
+	write("blubeaver/Alien/film_alien_1.jpg", "first")
+	write("blubeaver/Alien/film_alien_2.jpg", "second")
+	write("blubeaver/Alien/duplicates/film_alien_1_small.jpg", "first, smaller")
+	manifest := &Manifest{
+		Site:  "blubeaver",
+		Title: "Alien",
+		Year:  "1979",
+		URL:   "https://example.com/alien.htm",
+		Images: []ManifestImage{
+			{URL: "https://example.com/film/alien_1.jpg", FileName: "film_alien_1.jpg", SHA256: SHA256([]byte("first"))},
+			{URL: "https://example.com/film/alien_2.jpg", FileName: "film_alien_2.jpg", SHA256: SHA256([]byte("second"))},
+		},
+		Duplicates: []ManifestImage{
+			{URL: "https://example.com/film/alien_1_small.jpg", FileName: "film_alien_1_small.jpg", SHA256: SHA256([]byte("first, smaller")), DuplicateOf: "film_alien_1.jpg"},
+		},
+	}
+	if err := manifest.Write(filepath.Join(dataDir, "blubeaver", "Alien")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A movie saved before manifests existed
+	write("dvdbeaver/Brazil/brazil.jpg", "brazil")
+
+	// Files of the data directory itself are not movies
+	write("catalog.db", "catalog")
+
+	original := listFiles(t, dataDir)
+
+	layout, err := NewLayout("{{.Title}} ({{.Year}})", `{{.Site}}-{{printf "%02d" .Index}}{{.Ext}}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moves, err := Relayout(dataDir, layout, true)
+	if err != nil || len(moves) != 4 {
+		t.Fatalf("Relayout() dry run = %d moves, %v, expected 4", len(moves), err)
+	}
+	if files := listFiles(t, dataDir); !reflect.DeepEqual(files, original) {
+		t.Fatalf("Files moved on a dry run: %v", files)
+	}
+
+	if _, err := Relayout(dataDir, layout, false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+	expected := []string{
+		"Alien (1979)/blubeaver-01.jpg",
+		"Alien (1979)/blubeaver-02.jpg",
+		"Alien (1979)/duplicates/blubeaver-03.jpg",
+		"Alien (1979)/movie.json",
+		"Brazil ()/dvdbeaver-01.jpg",
+		"Brazil ()/movie.json",
+		"catalog.db",
+	}
+	if files := listFiles(t, dataDir); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Files after Relayout():\n%v\nexpected:\n%v", files, expected)
+	}
+
+	moved, err := ReadManifest(filepath.Join(dataDir, "Alien (1979)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Images[0].FileName != "blubeaver-01.jpg" || moved.Duplicates[0].DuplicateOf != "blubeaver-01.jpg" {
+		t.Errorf("Manifest doesn't follow the images moved: %+v", moved)
+	}
+
+	// Back to the default layout, images are named after their URL again
+	defaultLayout, err := NewLayout(DefaultPathTemplate, DefaultFileNameTemplate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Relayout(dataDir, defaultLayout, false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+	expected = []string{
+		"blubeaver/Alien/duplicates/film_alien_1_small.jpg",
+		"blubeaver/Alien/film_alien_1.jpg",
+		"blubeaver/Alien/film_alien_2.jpg",
+		"blubeaver/Alien/movie.json",
+		"catalog.db",
+		"dvdbeaver/Brazil/dvdbeaver-01.jpg",
+		"dvdbeaver/Brazil/movie.json",
+	}
+	if files := listFiles(t, dataDir); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Files after Relayout() to the default layout:\n%v\nexpected:\n%v", files, expected)
+	}
+}
+
+func TestRelayoutByContent(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(dataDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same image saved twice, from different URLs
+	write("blubeaver/Alien/film_alien_1.jpg", "first")
+	write("blubeaver/Alien/film_alien_2.jpg", "second")
+	write("blubeaver/Alien/film_alien_3.jpg", "first")
+	manifest := &Manifest{
+		Site:  "blubeaver",
+		Title: "Alien",
+		URL:   "https://example.com/alien.htm",
+		Images: []ManifestImage{
+			{URL: "https://example.com/film/alien_1.jpg", FileName: "film_alien_1.jpg", SHA256: SHA256([]byte("first"))},
+			{URL: "https://example.com/film/alien_2.jpg", FileName: "film_alien_2.jpg", SHA256: SHA256([]byte("second"))},
+			{URL: "https://example.com/film/alien_3.jpg", FileName: "film_alien_3.jpg", SHA256: SHA256([]byte("first"))},
+		},
+	}
+	if err := manifest.Write(filepath.Join(dataDir, "blubeaver", "Alien")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashed filenames saved before manifests existed
+	write("dvdbeaver/Brazil/"+MD5("brazil.jpg")+".jpg", "brazil")
+
+	layout := LayoutFor(&config.Options{Naming: NamingContent})
+	if _, err := Relayout(dataDir, layout, false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"blubeaver/Alien/" + SHA256([]byte("first")) + ".jpg",
+		"blubeaver/Alien/" + SHA256([]byte("second")) + ".jpg",
+		"blubeaver/Alien/movie.json",
+		"dvdbeaver/Brazil/" + SHA256([]byte("brazil")) + ".jpg",
+		"dvdbeaver/Brazil/movie.json",
+	}
+	sort.Strings(expected)
+	if files := listFiles(t, dataDir); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Files after Relayout():\n%v\nexpected:\n%v", files, expected)
+	}
+
+	converted, err := ReadManifest(filepath.Join(dataDir, "blubeaver", "Alien"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted.Images) != 2 {
+		t.Fatalf("Identical images not merged: %+v", converted.Images)
+	}
+	merged := converted.Images[0]
+	if merged.OriginalName != "film_alien_1.jpg" || !merged.HasURL("https://example.com/film/alien_3.jpg") {
+		t.Errorf("Merged image lost its original name or URLs: %+v", merged)
+	}
+
+	// Converting again changes nothing
+	if moves, err := Relayout(dataDir, layout, false); err != nil || len(moves) != 0 {
+		t.Errorf("Relayout() again = %v, %v, expected no moves", moves, err)
+	}
+}
+
+func TestRelayoutSharedFolder(t *testing.T) {
+	dataDir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(dataDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Movies of two websites saved with a path template without .Site
+	write("Alien/alien_1.jpg", "blubeaver")
+	write("Alien/alien_2.jpg", "dvdbeaver")
+	write("Alien/duplicates/alien_2_small.jpg", "dvdbeaver, smaller")
+	shared := &Manifest{
+		Site:  "blubeaver",
+		Title: "Alien",
+		URL:   "https://blubeaver.example.com/alien.htm",
+		Images: []ManifestImage{
+			{URL: "https://blubeaver.example.com/alien_1.jpg", FileName: "alien_1.jpg", SHA256: SHA256([]byte("blubeaver"))},
+			{Site: "dvdbeaver", URL: "https://dvdbeaver.example.com/alien_2.jpg", FileName: "alien_2.jpg", SHA256: SHA256([]byte("dvdbeaver"))},
+		},
+		Duplicates: []ManifestImage{
+			{Site: "dvdbeaver", URL: "https://dvdbeaver.example.com/alien_2_small.jpg", FileName: "alien_2_small.jpg", SHA256: SHA256([]byte("dvdbeaver, smaller")), DuplicateOf: "alien_2.jpg"},
+		},
+	}
+	if err := shared.Write(filepath.Join(dataDir, "Alien")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The movie of the website of the folder stays where it is
+	write("blubeaver/Brazil/brazil_1.jpg", "blubeaver")
+	write("blubeaver/Brazil/brazil_2.jpg", "dvdbeaver")
+	shared = &Manifest{
+		Site:  "blubeaver",
+		Title: "Brazil",
+		Images: []ManifestImage{
+			{Site: "blubeaver", URL: "https://blubeaver.example.com/brazil_1.jpg", FileName: "brazil_1.jpg", SHA256: SHA256([]byte("blubeaver"))},
+			{Site: "dvdbeaver", URL: "https://dvdbeaver.example.com/brazil_2.jpg", FileName: "brazil_2.jpg", SHA256: SHA256([]byte("dvdbeaver"))},
+		},
+	}
+	if err := shared.Write(filepath.Join(dataDir, "blubeaver", "Brazil")); err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := NewLayout(DefaultPathTemplate, DefaultFileNameTemplate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Relayout(dataDir, layout, false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"blubeaver/Alien/alien_1.jpg",
+		"blubeaver/Alien/movie.json",
+		"blubeaver/Brazil/brazil_1.jpg",
+		"blubeaver/Brazil/movie.json",
+		"dvdbeaver/Alien/alien_2.jpg",
+		"dvdbeaver/Alien/duplicates/alien_2_small.jpg",
+		"dvdbeaver/Alien/movie.json",
+		"dvdbeaver/Brazil/brazil_2.jpg",
+		"dvdbeaver/Brazil/movie.json",
+	}
+	if files := listFiles(t, dataDir); !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Files after Relayout():\n%v\nexpected:\n%v", files, expected)
+	}
+
+	for _, folder := range []string{"blubeaver/Alien", "blubeaver/Brazil", "dvdbeaver/Alien", "dvdbeaver/Brazil"} {
+		manifest, err := ReadManifest(filepath.Join(dataDir, filepath.FromSlash(folder)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		site := filepath.Dir(filepath.FromSlash(folder))
+		if manifest.Site != site || len(manifest.Images) != 1 || manifest.SiteOf(manifest.Images[0]) != site {
+			t.Errorf("Manifest of %s = %+v, expected a single image of %s", folder, manifest, site)
+		}
+	}
+
+	// Moved folders are gone, nothing is left to move
+	if moves, err := Relayout(dataDir, layout, false); err != nil || len(moves) != 0 {
+		t.Errorf("Relayout() again = %v, %v, expected no moves", moves, err)
+	}
+}
@@ -0,0 +1,185 @@
+package scraper
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"moviestills/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Report of a scraping run, for scripts and nightly jobs
+type Report struct {
+	Version    string         `json:"version"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Duration   float64        `json:"duration_seconds"`
+	Stopped    bool           `json:"stopped"`
+	Options    config.Options `json:"options"`
+	Sites      []SiteReport   `json:"sites"`
+	Total      *Stats         `json:"total"`
+}
+
+// SiteReport is the report of a website
+type SiteReport struct {
+	*Stats
+	Duration float64 `json:"duration_seconds"`
+
+	// Movies visited without any image found
+	EmptyMovies []ReportMovie `json:"empty_movies"`
+}
+
+// ReportMovie describes a movie in reports
+type ReportMovie struct {
+	Name string `json:"name"`
+	Year string `json:"year,omitempty"`
+	URL  string `json:"url"`
+	Path string `json:"path"`
+}
+
+// NewReport reports on the websites scraped since the run started.
+// Credentials of the proxies are left out of the options.
+func NewReport(options *config.Options, agg *AggregatedStats, started time.Time, stopped bool) *Report {
+	finished := time.Now()
+	report := &Report{
+		Version:    config.VERSION,
+		StartedAt:  started.UTC(),
+		FinishedAt: finished.UTC(),
+		Duration:   finished.Sub(started).Seconds(),
+		Stopped:    stopped,
+		Options:    *options.Redacted(),
+		Sites:      make([]SiteReport, 0, len(agg.PerSite)),
+		Total:      &agg.Total,
+	}
+	for _, stats := range agg.PerSite {
+		site := SiteReport{
+			Stats:       stats,
+			Duration:    stats.Duration.Seconds(),
+			EmptyMovies: make([]ReportMovie, 0),
+		}
+		for _, movie := range stats.EmptyMovies() {
+			site.EmptyMovies = append(site.EmptyMovies, ReportMovie{Name: movie.Name, Year: movie.Year, URL: movie.URL, Path: movie.Path})
+		}
+		report.Sites = append(report.Sites, site)
+	}
+
+	// Websites scraped concurrently end in any order
+	sort.Slice(report.Sites, func(i, j int) bool { return report.Sites[i].Website < report.Sites[j].Website })
+
+	return report
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(path string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeReport(path, append(content, '\n'))
+}
+
+// JUnit XML report: a test suite by website, with a test case for every
+// movie, failing when no image was found, and a failing test case for
+// every request that failed.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, for CI tools
+func (r *Report) WriteJUnit(path string) error {
+	suites := junitSuites{Name: "moviestills", Time: seconds(r.Duration)}
+
+	for _, site := range r.Sites {
+		suite := junitSuite{
+			Name:      site.Website,
+			Time:      seconds(site.Duration),
+			Timestamp: r.StartedAt.Format(time.RFC3339),
+		}
+
+		// A website without any movie is likely broken
+		scraped := junitCase{ClassName: site.Website, Name: "movies found"}
+		if site.MoviesFound == 0 && !r.Stopped {
+			scraped.Failure = &junitFailure{Message: "no movie found"}
+		}
+		suite.Cases = append(suite.Cases, scraped)
+
+		for _, m := range site.Movies() {
+			movie := junitCase{ClassName: site.Website, Name: m.Movie.Name}
+			if m.Images == 0 {
+				movie.Failure = &junitFailure{Message: "no image found", Text: m.Movie.URL}
+			}
+			suite.Cases = append(suite.Cases, movie)
+		}
+
+		for _, failed := range site.FailedURLs {
+			request := junitCase{
+				ClassName: site.Website,
+				Name:      failed.URL,
+				Failure:   &junitFailure{Message: failed.Error, Text: failed.Movie},
+			}
+			if failed.Status != 0 {
+				request.Failure.Type = fmt.Sprintf("HTTP %d", failed.Status)
+			}
+			suite.Cases = append(suite.Cases, request)
+		}
+
+		for _, c := range suite.Cases {
+			if c.Failure != nil {
+				suite.Failures++
+			}
+		}
+		suite.Tests = len(suite.Cases)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	content, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeReport(path, append([]byte(xml.Header), append(content, '\n')...))
+}
+
+// seconds formats a duration in seconds for JUnit reports
+func seconds(duration float64) string {
+	return fmt.Sprintf("%.3f", duration)
+}
+
+// writeReport writes a report atomically, creating its folder if needed
+func writeReport(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content)
+}
@@ -1,22 +1,82 @@
 package scraper
 
 import (
+	"errors"
+	"image"
 	"moviestills/config"
+	"moviestills/pagecache"
+	"moviestills/robots"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/gocolly/colly/v2"
+	"github.com/gocolly/colly/v2/extensions"
 	"github.com/pterm/pterm"
 )
 
 // Stats tracks scraping progress
 type Stats struct {
-	Website          string
-	MoviesFound      int64
-	ImagesDownloaded int64
-	ImagesFailed     int64
+	Website          string `json:"website"`
+	MoviesFound      int64  `json:"movies_found"`
+	ImagesDownloaded int64  `json:"images_downloaded"`
+	ImagesFailed     int64  `json:"images_failed"`
+	ImagesSkipped    int64  `json:"images_skipped"`
+	ImagesDuplicated int64  `json:"images_duplicated"`
+	ImagesCollisions int64  `json:"images_collisions"`
+
+	// Images rejected before being saved, by reason,
+	// also counted as failed.
+	ImagesCorrupt     int64 `json:"images_corrupt"`
+	ImagesTooSmall    int64 `json:"images_too_small"`
+	ImagesPlaceholder int64 `json:"images_placeholder"`
+	ImagesInvalid     int64 `json:"images_invalid"`
+
+	// Size of the responses received, pages and images
+	BytesDownloaded int64 `json:"bytes_downloaded"`
+
+	// Requests retried after failing for a transient reason
+	Retries int64 `json:"retries"`
+
+	// Requests and images that failed, listed by website
+	RequestsFailed int64 `json:"requests_failed"`
+
+	// Requests skipped as disallowed by the robots.txt of their host
+	RobotsDisallowed int64 `json:"robots_disallowed"`
+
+	// How long scraping the website took
+	Duration time.Duration `json:"-"`
+
+	mu sync.Mutex
+
+	// Number of responses received, by HTTP status
+	StatusCodes map[int]int64 `json:"status_codes,omitempty"`
+
+	// Requests and images that failed, with why
+	FailedURLs []FailedURL `json:"failed_urls,omitempty"`
+
+	// Requests and images disallowed by robots.txt files
+	DisallowedURLs []string `json:"disallowed_urls,omitempty"`
+
+	// Number of images found for the movies visited, by movie URL
+	movies map[string]*MovieCount
+}
+
+// FailedURL is a request or an image that failed
+type FailedURL struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error"`
+	Movie  string `json:"movie,omitempty"`
+}
+
+// MovieCount is the number of images found for a movie
+type MovieCount struct {
+	Movie  Movie
+	Images int64
 }
 
 // Increment atomically increments a counter
@@ -32,6 +92,127 @@ func (s *Stats) IncrFailed() {
 	atomic.AddInt64(&s.ImagesFailed, 1)
 }
 
+func (s *Stats) IncrSkipped() {
+	atomic.AddInt64(&s.ImagesSkipped, 1)
+}
+
+func (s *Stats) IncrDuplicated() {
+	atomic.AddInt64(&s.ImagesDuplicated, 1)
+}
+
+func (s *Stats) IncrCollisions() {
+	atomic.AddInt64(&s.ImagesCollisions, 1)
+}
+
+// IncrRejected counts an image rejected for the reason of the error
+func (s *Stats) IncrRejected(err error) {
+	s.IncrFailed()
+
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		return
+	}
+	switch rejected.Reason {
+	case RejectedCorrupt:
+		atomic.AddInt64(&s.ImagesCorrupt, 1)
+	case RejectedTooSmall:
+		atomic.AddInt64(&s.ImagesTooSmall, 1)
+	case RejectedPlaceholder:
+		atomic.AddInt64(&s.ImagesPlaceholder, 1)
+	case RejectedInvalid:
+		atomic.AddInt64(&s.ImagesInvalid, 1)
+	}
+}
+
+// IncrRetries counts a request retried
+func (s *Stats) IncrRetries() {
+	atomic.AddInt64(&s.Retries, 1)
+}
+
+// AddDisallowed counts a request disallowed by robots.txt
+func (s *Stats) AddDisallowed(disallowedURL string) {
+	atomic.AddInt64(&s.RobotsDisallowed, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DisallowedURLs = append(s.DisallowedURLs, disallowedURL)
+}
+
+// AddResponse counts a response received, and its size
+func (s *Stats) AddResponse(status int, size int) {
+	atomic.AddInt64(&s.BytesDownloaded, int64(size))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.StatusCodes == nil {
+		s.StatusCodes = make(map[int]int64)
+	}
+	s.StatusCodes[status]++
+}
+
+// AddFailure keeps track of a request or an image that failed
+func (s *Stats) AddFailure(movie Movie, failedURL string, status int, err error) {
+	atomic.AddInt64(&s.RequestsFailed, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FailedURLs = append(s.FailedURLs, FailedURL{URL: failedURL, Status: status, Error: err.Error(), Movie: movie.Name})
+}
+
+// VisitMovie counts a request made for a movie, so movies
+// without any image found can be told.
+func (s *Stats) VisitMovie(movie Movie) {
+	if movie.URL == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.movies == nil {
+		s.movies = make(map[string]*MovieCount)
+	}
+	if _, found := s.movies[movie.URL]; !found {
+		s.movies[movie.URL] = &MovieCount{Movie: movie}
+	}
+}
+
+// FoundImage counts an image found for a movie, saved now or before
+func (s *Stats) FoundImage(movie Movie) {
+	s.VisitMovie(movie)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, found := s.movies[movie.URL]; found {
+		m.Images++
+	}
+}
+
+// Movies returns how many images were found for every movie
+// visited, sorted by movie URL.
+func (s *Stats) Movies() []MovieCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	movies := make([]MovieCount, 0, len(s.movies))
+	for _, m := range s.movies {
+		movies = append(movies, *m)
+	}
+	sort.Slice(movies, func(i, j int) bool { return movies[i].Movie.URL < movies[j].Movie.URL })
+
+	return movies
+}
+
+// EmptyMovies lists the movies visited without any image found, by URL
+func (s *Stats) EmptyMovies() []Movie {
+	var empty []Movie
+	for _, m := range s.Movies() {
+		if m.Images == 0 {
+			empty = append(empty, m.Movie)
+		}
+	}
+	return empty
+}
+
 // AggregatedStats holds stats from multiple scrapers
 type AggregatedStats struct {
 	mu      sync.Mutex
@@ -54,6 +235,26 @@ func (a *AggregatedStats) Add(s *Stats) {
 	a.Total.MoviesFound += s.MoviesFound
 	a.Total.ImagesDownloaded += s.ImagesDownloaded
 	a.Total.ImagesFailed += s.ImagesFailed
+	a.Total.ImagesSkipped += s.ImagesSkipped
+	a.Total.ImagesDuplicated += s.ImagesDuplicated
+	a.Total.ImagesCollisions += s.ImagesCollisions
+	a.Total.ImagesCorrupt += s.ImagesCorrupt
+	a.Total.ImagesTooSmall += s.ImagesTooSmall
+	a.Total.ImagesPlaceholder += s.ImagesPlaceholder
+	a.Total.ImagesInvalid += s.ImagesInvalid
+	a.Total.BytesDownloaded += s.BytesDownloaded
+	a.Total.Retries += s.Retries
+	a.Total.RequestsFailed += s.RequestsFailed
+	a.Total.RobotsDisallowed += s.RobotsDisallowed
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for status, count := range s.StatusCodes {
+		if a.Total.StatusCodes == nil {
+			a.Total.StatusCodes = make(map[int]int64)
+		}
+		a.Total.StatusCodes[status] += count
+	}
 }
 
 // Movie represents a movie being scraped
@@ -62,16 +263,26 @@ type Movie struct {
 	Year string
 	URL  string
 	Path string
+	Site string
 }
 
-// NewMovie creates a Movie with the proper path
+// NewMovie creates a Movie with the proper path,
+// following the path template of the options.
 func NewMovie(name, year, url, website string, options *config.Options) Movie {
-	return Movie{
+	movie := Movie{
 		Name: name,
 		Year: year,
 		URL:  url,
-		Path: filepath.Join(options.DataDir, website, name),
+		Site: website,
+	}
+
+	path, err := LayoutFor(options).MoviePath(movie.Fields())
+	if err != nil {
+		path = filepath.Join(website, name)
 	}
+	movie.Path = filepath.Join(options.DataDir, path)
+
+	return movie
 }
 
 // ToContext stores movie data in a Colly context
@@ -79,6 +290,8 @@ func (m Movie) ToContext() *colly.Context {
 	ctx := colly.NewContext()
 	ctx.Put("movie_name", m.Name)
 	ctx.Put("movie_path", m.Path)
+	ctx.Put("movie_url", m.URL)
+	ctx.Put("movie_site", m.Site)
 	if m.Year != "" {
 		ctx.Put("movie_year", m.Year)
 	}
@@ -90,36 +303,57 @@ func MovieFromContext(ctx *colly.Context) Movie {
 	return Movie{
 		Name: ctx.Get("movie_name"),
 		Year: ctx.Get("movie_year"),
+		URL:  ctx.Get("movie_url"),
 		Path: ctx.Get("movie_path"),
+		Site: ctx.Get("movie_site"),
 	}
 }
 
-// SiteConfig holds configuration for a specific website scraper
-type SiteConfig struct {
-	Name           string
-	IndexURL       string
-	AllowedDomains []string
-	DetectCharset  bool
+// UserAgent is the user agent of requests following robots.txt files,
+// the one their rules are matched with
+var UserAgent = robots.Identify(config.VERSION)
+
+// SetUserAgent sets the user agent of a collector and its clones.
+// Following robots.txt files, we go by our name for their rules to
+// apply. Otherwise a random user agent is used for every request, to
+// avoid getting banned.
+func SetUserAgent(c *colly.Collector, options *config.Options) {
+	if options.RespectRobots {
+		c.UserAgent = UserAgent
+		return
+	}
+	extensions.RandomUserAgent(c)
 }
 
 // SetupIndexScraper configures the main index scraper with common settings
-func SetupIndexScraper(c *colly.Collector, cfg SiteConfig, log *Logger) {
-	c.AllowedDomains = cfg.AllowedDomains
+func SetupIndexScraper(c *colly.Collector, site Site, log *Logger) {
+	c.AllowedDomains = site.AllowedDomains()
 	c.AllowURLRevisit = true
 
-	if cfg.DetectCharset {
+	if detector, ok := site.(CharsetDetector); ok && detector.DetectCharset() {
 		c.DetectCharset = true
 	}
 
-	// Common error handler
+	// Common error handler, requests cancelled
+	// on shutdown are not worth reporting.
 	c.OnError(func(r *colly.Response, err error) {
-		log.Error(r.Request.URL, "\t", pterm.White(r.StatusCode), "\nError:", pterm.Red(err))
+		if c.Context.Err() != nil {
+			return
+		}
+		if errors.Is(err, robots.ErrDisallowed) {
+			log.Warning("Skipping", URLField(r.Request.URL.String()), "disallowed by robots.txt")
+			return
+		}
+		log.Error(URLField(r.Request.URL.String()), "\t", StatusField(r.StatusCode), RequestDuration(r.Request), "\nError:", err)
 	})
 
 	// Common request handler
 	c.OnRequest(func(r *colly.Request) {
-		log.Debug("visiting index page", pterm.White(r.URL.String()))
+		log.Debug("visiting index page", URLField(r.URL.String()))
+		r.Headers.Set(pagecache.KindHeader, pagecache.KindIndex)
+		startRequest(r)
 	})
+	logResponses(c, log)
 }
 
 // SetupMovieScraper configures the movie page scraper with common settings
@@ -128,46 +362,134 @@ func SetupMovieScraper(c *colly.Collector, log *Logger) *colly.Collector {
 	movieScraper.AllowURLRevisit = false
 
 	movieScraper.OnRequest(func(r *colly.Request) {
-		log.Debug("visiting", pterm.White(r.URL.String()))
+		log.Debug("visiting", URLField(r.URL.String()))
+		r.Headers.Set(pagecache.KindHeader, pagecache.KindMovie)
+		startRequest(r)
 	})
+	logResponses(movieScraper, log)
 
 	return movieScraper
 }
 
-// SetupImageResponseHandler sets up the common image response handler
-func SetupImageResponseHandler(c *colly.Collector, options *config.Options, stats *Stats, log *Logger) {
+// logResponses logs every response received, with how long it took
+func logResponses(c *colly.Collector, log *Logger) {
+	c.OnResponse(func(r *colly.Response) {
+		log.Debug("received", URLField(r.Request.URL.String()), StatusField(r.StatusCode), RequestDuration(r.Request))
+	})
+}
+
+// Recorder keeps track of the images saved for movies, eg. in a catalog
+type Recorder interface {
+	RecordImage(movie Movie, image ManifestImage) error
+
+	// RemoveImage forgets an image no longer in the movie folder
+	RemoveImage(movie Movie, fileName string) error
+}
+
+// Recorders gives saved images to several recorders in turn
+type Recorders []Recorder
+
+func (r Recorders) RecordImage(movie Movie, image ManifestImage) error {
+	var errs []error
+	for _, recorder := range r {
+		if recorder != nil {
+			errs = append(errs, recorder.RecordImage(movie, image))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r Recorders) RemoveImage(movie Movie, fileName string) error {
+	var errs []error
+	for _, recorder := range r {
+		if recorder != nil {
+			errs = append(errs, recorder.RemoveImage(movie, fileName))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetupImageResponseHandler sets up the common image response handler.
+// Saved images are also given to the recorder, if any.
+func SetupImageResponseHandler(c *colly.Collector, site Site, options *config.Options, stats *Stats, recorder Recorder, log *Logger) {
+	validator, hasValidator := site.(ImageValidator)
+
 	c.OnResponse(func(r *colly.Response) {
 		// Ignore anything that is not an image
 		if !strings.Contains(r.Headers.Get("Content-Type"), "image") {
 			return
 		}
 
+		// Let the website discard images it doesn't want,
+		// then make sure the image can be used.
+		img, err := validateImage(r, options, validator, hasValidator)
+		if err != nil {
+			log.Error("Invalid image, not downloading", pterm.White(r.FileName()), err)
+			if stats != nil {
+				stats.IncrRejected(err)
+				stats.AddFailure(MovieFromContext(r.Ctx), r.Request.URL.String(), r.StatusCode, err)
+			}
+			return
+		}
+
 		movie := MovieFromContext(r.Ctx)
 
-		if err := SaveImage(movie.Path, movie.Name, r.FileName(), r.Body, options.Hash, log); err != nil {
-			log.Error("Can't save image", pterm.White(r.FileName()), pterm.Red(err))
+		// Keep track of where the image came from
+		image := NewManifestImage(r, r.FileName())
+		if Deduplicating(options) {
+			image.DHash = perceptualHash(img)
+		}
+
+		result, err := SaveUniqueImage(movie, image, r.Body, options, log)
+		if err != nil {
+			log.Error("Can't save image", pterm.White(r.FileName()), err)
 			if stats != nil {
 				stats.IncrFailed()
+				stats.AddFailure(movie, r.Request.URL.String(), r.StatusCode, err)
 			}
 			return
 		}
 
 		if stats != nil {
-			stats.IncrDownloaded()
+			stats.FoundImage(movie)
+			if result.Identical {
+				stats.IncrSkipped()
+			} else {
+				stats.IncrDownloaded()
+			}
+			if result.Renamed {
+				stats.IncrCollisions()
+			}
+			if result.Duplicate || result.Replaced != nil {
+				stats.IncrDuplicated()
+			}
+		}
+
+		if recorder == nil {
+			return
+		}
+		if result.Replaced != nil {
+			if err := recorder.RemoveImage(movie, result.Replaced.FileName); err != nil {
+				log.Error("Can't remove image", pterm.White(result.Replaced.FileName), "from the catalog:", err)
+			}
+		}
+		if result.Saved {
+			if err := recorder.RecordImage(movie, result.Image); err != nil {
+				log.Error("Can't record image", pterm.White(result.Image.FileName), "in the catalog:", err)
+			}
 		}
 	})
 }
 
-// VisitAndWait visits the index URL and waits for completion
-func VisitAndWait(indexScraper *colly.Collector, movieScraper *colly.Collector, url string, log *Logger) {
-	if err := indexScraper.Visit(url); err != nil {
-		log.Error("Can't visit index page", pterm.White(url), ":", pterm.Red(err))
-	}
-
-	indexScraper.Wait()
-	if movieScraper != nil {
-		movieScraper.Wait()
+// validateImage checks an image with the website validator, if any,
+// and decodes it.
+func validateImage(r *colly.Response, options *config.Options, validator ImageValidator, hasValidator bool) (image.Image, error) {
+	if hasValidator {
+		if err := validator.ValidateImage(r); err != nil {
+			return nil, &RejectedError{RejectedInvalid, err}
+		}
 	}
+	return DecodeImage(r.Body, options)
 }
 
 // PrintSummary prints the final scraping statistics for a single site
@@ -185,11 +507,53 @@ func PrintAggregatedSummary(agg *AggregatedStats) {
 		for _, s := range agg.PerSite {
 			pterm.DefaultSection.WithLevel(2).Println(s.Website)
 			printStatsItems(s)
+			printDisallowedURLs(s)
 		}
 		pterm.DefaultSection.WithLevel(2).Println("Total")
 	}
 
 	printStatsItems(&agg.Total)
+	if len(agg.PerSite) == 1 {
+		printDisallowedURLs(agg.PerSite[0])
+	}
+}
+
+// Disallowed URLs listed in the summary of a website, at most,
+// the others being in the reports
+const maxDisallowedURLs = 20
+
+// printDisallowedURLs lists the URLs of a website disallowed by robots.txt
+func printDisallowedURLs(stats *Stats) {
+	stats.mu.Lock()
+	urls := append([]string(nil), stats.DisallowedURLs...)
+	stats.mu.Unlock()
+	if len(urls) == 0 {
+		return
+	}
+
+	pterm.Info.Println("Skipped as disallowed by robots.txt:")
+	items := make([]pterm.BulletListItem, 0, maxDisallowedURLs+1)
+	for i, disallowedURL := range urls {
+		if i == maxDisallowedURLs {
+			items = append(items, pterm.BulletListItem{
+				Level:       0,
+				Text:        pterm.Sprintf("and %s more, see --report", pterm.White(len(urls)-maxDisallowedURLs)),
+				TextStyle:   pterm.NewStyle(pterm.FgDefault),
+				BulletStyle: pterm.NewStyle(pterm.FgYellow),
+			})
+			break
+		}
+		items = append(items, pterm.BulletListItem{
+			Level:       0,
+			Text:        disallowedURL,
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgYellow),
+		})
+	}
+
+	if err := pterm.DefaultBulletList.WithItems(items).Render(); err != nil {
+		pterm.Error.Println("Could not print disallowed URLs", pterm.Red(err))
+	}
 }
 
 func printStatsItems(stats *Stats) {
@@ -206,6 +570,36 @@ func printStatsItems(stats *Stats) {
 			TextStyle:   pterm.NewStyle(pterm.FgDefault),
 			BulletStyle: pterm.NewStyle(pterm.FgGreen),
 		},
+		{
+			Level:       0,
+			Text:        pterm.Sprintf("Images already saved: %s", pterm.White(stats.ImagesSkipped)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgGreen),
+		},
+		{
+			Level:       0,
+			Text:        pterm.Sprintf("Near-duplicate images: %s", pterm.White(stats.ImagesDuplicated)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgYellow),
+		},
+		{
+			Level:       0,
+			Text:        pterm.Sprintf("Filename collisions: %s", pterm.White(stats.ImagesCollisions)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgYellow),
+		},
+		{
+			Level:       0,
+			Text:        pterm.Sprintf("Requests retried: %s", pterm.White(stats.Retries)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgYellow),
+		},
+		{
+			Level:       0,
+			Text:        pterm.Sprintf("Disallowed by robots.txt: %s", pterm.White(stats.RobotsDisallowed)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgYellow),
+		},
 		{
 			Level:       0,
 			Text:        pterm.Sprintf("Images failed: %s", pterm.White(stats.ImagesFailed)),
@@ -214,6 +608,28 @@ func printStatsItems(stats *Stats) {
 		},
 	}
 
+	// Detail why images were rejected
+	rejected := []struct {
+		reason string
+		count  int64
+	}{
+		{RejectedCorrupt, stats.ImagesCorrupt},
+		{RejectedTooSmall, stats.ImagesTooSmall},
+		{RejectedPlaceholder, stats.ImagesPlaceholder},
+		{RejectedInvalid, stats.ImagesInvalid},
+	}
+	for _, r := range rejected {
+		if r.count == 0 {
+			continue
+		}
+		items = append(items, pterm.BulletListItem{
+			Level:       1,
+			Text:        pterm.Sprintf("Rejected as %s: %s", r.reason, pterm.White(r.count)),
+			TextStyle:   pterm.NewStyle(pterm.FgDefault),
+			BulletStyle: pterm.NewStyle(pterm.FgRed),
+		})
+	}
+
 	if err := pterm.DefaultBulletList.WithItems(items).Render(); err != nil {
 		pterm.Error.Println("Could not print summary", pterm.Red(err))
 	}
@@ -0,0 +1,336 @@
+// Package scrapertest runs website scrapers offline, against a local
+// mock server serving recorded HTML pages and fake images.
+package scrapertest
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"moviestills/config"
+	"moviestills/pagecache"
+	"moviestills/robots"
+	"moviestills/scraper"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Server serves recorded pages of a website from a directory,
+// mirroring the paths of the real website. Pages hosted on other
+// domains, or whose links are matched on their domain, are recorded
+// under a folder named after the domain, eg. "i.imgur.com/abc.png".
+//
+// Images that were not recorded are generated on the fly, so tests
+// don't need to store them. Any path containing "missing" is not found.
+type Server struct {
+	*httptest.Server
+	dir   string
+	files http.Handler
+
+	// OnRequest is called, if set, for every request received
+	OnRequest func(r *http.Request)
+
+	// RobotsTxt is served as the robots.txt of the server, if set
+	RobotsTxt string
+}
+
+// NewServer starts a mock server for the recorded pages in dir.
+// The server is closed when the test ends.
+func NewServer(t testing.TB, dir string) *Server {
+	t.Helper()
+
+	s := &Server{
+		dir:   dir,
+		files: http.FileServer(http.Dir(dir)),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.OnRequest != nil {
+		s.OnRequest(r)
+	}
+
+	if s.RobotsTxt != "" && r.URL.Path == "/robots.txt" {
+		_, _ = io.WriteString(w, s.RobotsTxt)
+		return
+	}
+
+	if strings.Contains(r.URL.Path, "missing") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Recorded files and pages are served as is
+	recorded := filepath.Join(s.dir, filepath.FromSlash(path.Clean(r.URL.Path)))
+	if _, err := os.Stat(recorded); err == nil || !isImage(r.URL.Path) {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	body, contentType := FakeImage(r.URL.Path)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write(body)
+}
+
+// Host is the domain of the server, to allow in scrapers
+func (s *Server) Host() string {
+	u, _ := url.Parse(s.URL)
+	return u.Hostname()
+}
+
+// Mock returns the website with its index URL and allowed domains
+// pointing at the server. The path of the index URL is kept.
+func (s *Server) Mock(site scraper.Site) scraper.Site {
+	indexURL, _ := url.Parse(site.IndexURL())
+	return mockedSite{
+		Site:     site,
+		indexURL: s.URL + indexURL.RequestURI(),
+		domains:  []string{s.Host()},
+	}
+}
+
+// mockedSite is a website scraped from a mock server
+type mockedSite struct {
+	scraper.Site
+	indexURL string
+	domains  []string
+}
+
+func (m mockedSite) IndexURL() string {
+	return m.indexURL
+}
+
+func (m mockedSite) AllowedDomains() []string {
+	return m.domains
+}
+
+// Optional interfaces of the website must keep working
+func (m mockedSite) DetectCharset() bool {
+	detector, ok := m.Site.(scraper.CharsetDetector)
+	return ok && detector.DetectCharset()
+}
+
+func (m mockedSite) ValidateImage(r *colly.Response) error {
+	if validator, ok := m.Site.(scraper.ImageValidator); ok {
+		return validator.ValidateImage(r)
+	}
+	return nil
+}
+
+// Result of a scraping run
+type Result struct {
+	Stats *scraper.Stats
+
+	// Dir is where movie stills of the website were saved
+	Dir string
+}
+
+// Options returns the options used by default to run scrapers,
+// saving movie stills in a temporary data directory.
+func Options(t testing.TB) *config.Options {
+	return &config.Options{
+		Parallel: 4,
+		TimeOut:  10 * time.Second,
+		CacheDir: t.TempDir(),
+		DataDir:  t.TempDir(),
+
+		MinWidth:      500,
+		MinHeight:     265,
+		Dedup:         scraper.DedupMove,
+		DedupDistance: 4,
+	}
+}
+
+// Run scrapes a website from the mock server with the given options,
+// or with Options() if nil.
+func Run(t testing.TB, server *Server, site scraper.Site, options *config.Options) *Result {
+	t.Helper()
+	return RunContext(t.Context(), t, server, site, options)
+}
+
+// RunContext is like Run, scraping until the context is done
+func RunContext(ctx context.Context, t testing.TB, server *Server, site scraper.Site, options *config.Options) *Result {
+	t.Helper()
+
+	if options == nil {
+		options = Options(t)
+	}
+
+	// Follow the robots.txt of the server if asked to, like the app
+	transport := http.DefaultTransport
+	if options.RespectRobots {
+		transport = robots.New(scraper.UserAgent).Transport(transport)
+	}
+
+	c := colly.NewCollector()
+	scraper.SetUserAgent(c, options)
+	c.WithTransport(pagecache.New(filepath.Join(options.CacheDir, site.Name()), transport, options.CachePolicy()))
+	c.SetRequestTimeout(options.TimeOut)
+	c.Async = options.Async
+	if err := c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: options.Parallel}); err != nil {
+		t.Fatalf("Can't limit the scraper: %v", err)
+	}
+
+	stats := &scraper.Stats{Website: site.Name()}
+	scraper.Run(ctx, server.Mock(site), c, options, stats, nil)
+
+	return &Result{
+		Stats: stats,
+		Dir:   filepath.Join(options.DataDir, site.Name()),
+	}
+}
+
+// Files lists the images saved for every movie, sorted by name
+func (r *Result) Files(t testing.TB) map[string][]string {
+	t.Helper()
+
+	files := make(map[string][]string)
+	err := filepath.WalkDir(r.Dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == scraper.ManifestFile {
+			return err
+		}
+		rel, err := filepath.Rel(r.Dir, p)
+		if err != nil {
+			return err
+		}
+		movie := filepath.ToSlash(filepath.Dir(rel))
+		files[movie] = append(files[movie], filepath.Base(rel))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Can't list saved files: %v", err)
+	}
+
+	for _, names := range files {
+		sort.Strings(names)
+	}
+
+	return files
+}
+
+func isImage(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// FakeImage generates an image for the given path. The same path
+// always gives the same image, while different paths give different
+// images, of 1280x720 pixels. A "-small" or "-thumb" suffix, eg.
+// "still-small.jpg", gives the image of the path without it, at half
+// or an eighth of its size: a near-duplicate or a thumbnail.
+// Returns the encoded image and its content type.
+func FakeImage(p string) ([]byte, string) {
+	ext := path.Ext(p)
+	scale := 1
+	for suffix, factor := range scaledSuffixes {
+		if original, found := strings.CutSuffix(strings.TrimSuffix(p, ext), suffix); found {
+			p, scale = original+ext, factor
+		}
+	}
+
+	hasher := fnv.New64a()
+	_, _ = hasher.Write([]byte(p))
+	random := rand.New(rand.NewSource(int64(hasher.Sum64())))
+
+	// Large tiles of random shades, so images can be told apart
+	// by their perceptual hashes, covered with blocks of random
+	// noise to not be tiny once encoded.
+	const width, height, tile, block = 1280, 720, 80, 8
+	var shades [height / tile][width / tile]int
+	for y := range shades {
+		for x := range shades[y] {
+			shades[y][x] = 32 + random.Intn(192)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y += block {
+		for x := 0; x < width; x += block {
+			shade := shades[y/tile][x/tile]
+			c := color.RGBA{noise(random, shade), noise(random, shade), noise(random, shade), 255}
+			for dy := 0; dy < block; dy++ {
+				for dx := 0; dx < block; dx++ {
+					img.SetRGBA(x+dx, y+dy, c)
+				}
+			}
+		}
+	}
+
+	var encoded image.Image = img
+	if scale > 1 {
+		encoded = shrink(img, scale)
+	}
+
+	var buf bytes.Buffer
+	if strings.ToLower(ext) == ".png" {
+		_ = png.Encode(&buf, encoded)
+		return buf.Bytes(), "image/png"
+	}
+	_ = jpeg.Encode(&buf, encoded, &jpeg.Options{Quality: 90})
+	return buf.Bytes(), "image/jpeg"
+}
+
+// noise returns a random value around the given shade
+func noise(random *rand.Rand, shade int) uint8 {
+	return uint8(shade - 32 + random.Intn(64))
+}
+
+// Suffixes of fake images which are smaller versions of others,
+// with how many times smaller they are.
+var scaledSuffixes = map[string]int{
+	"-small": 2,
+	"-thumb": 8,
+}
+
+// shrink divides the size of an image, averaging pixels
+func shrink(img *image.RGBA, factor int) *image.RGBA {
+	bounds := img.Bounds()
+	small := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/factor, bounds.Dy()/factor))
+	for y := 0; y < small.Bounds().Dy(); y++ {
+		for x := 0; x < small.Bounds().Dx(); x++ {
+			var r, g, b int
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					p := img.RGBAAt(x*factor+dx, y*factor+dy)
+					r, g, b = r+int(p.R), g+int(p.G), b+int(p.B)
+				}
+			}
+			n := factor * factor
+			small.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255})
+		}
+	}
+	return small
+}
+
+// SkipLive skips tests requesting live websites in short mode,
+// eg. in CI sandboxes without network access.
+func SkipLive(t testing.TB) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping test requesting a live website in short mode")
+	}
+}
@@ -0,0 +1,326 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"moviestills/config"
+	"moviestills/pagecache"
+	"moviestills/robots"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/pterm/pterm"
+)
+
+// Session holds everything a website needs while being scraped
+type Session struct {
+	ctx context.Context
+
+	Site    Site
+	Options *config.Options
+	Stats   *Stats
+	Log     *Logger
+
+	// Recorder is given every image saved, can be nil
+	Recorder Recorder
+
+	// State tracks the progress of movies to resume scraping later
+	State *CrawlState
+
+	// Index visits the index page of the website
+	Index *colly.Collector
+
+	// Movies visits movie pages and movie stills
+	Movies *colly.Collector
+
+	// Extra collectors created by the website, waited
+	// for between the index and the movie scrapers.
+	extra []*colly.Collector
+}
+
+// Run scrapes a website with the given collector until there is
+// nothing left to visit, or the context is done. In this case, no new
+// request is made and requests in progress are discarded. Saved images
+// are given to the recorder, if not nil.
+func Run(ctx context.Context, site Site, c *colly.Collector, options *config.Options, stats *Stats, recorder Recorder) {
+	log := NewLogger(site.Name())
+
+	if stats != nil {
+		defer func(start time.Time) {
+			stats.Duration = time.Since(start)
+		}(time.Now())
+	}
+
+	// Requests in progress are cancelled with the context
+	c.Context = ctx
+
+	// Setup the index scraper with common settings
+	SetupIndexScraper(c, site, log)
+
+	s := &Session{
+		ctx:      ctx,
+		Site:     site,
+		Options:  options,
+		Stats:    stats,
+		Log:      log,
+		Recorder: recorder,
+		Index:    c,
+	}
+
+	// Create and setup the movie scraper
+	s.Movies = SetupMovieScraper(c, log)
+
+	// Setup the common image response handler
+	SetupImageResponseHandler(s.Movies, site, options, stats, recorder, log)
+
+	site.DiscoverMovies(s)
+	site.ExtractImages(s)
+
+	// Keep track of the progress, once the website is done
+	// with its own callbacks as they can queue new requests.
+	s.State = loadCrawlState(site, options, log)
+	stopTracking := trackCrawlState(s.State, log)
+	defer stopTracking()
+	s.trackRequests()
+	s.trackStats()
+	s.skipSavedImages()
+
+	// Pick up the queue of an interrupted scraping
+	if options.Resume {
+		s.resume()
+	}
+
+	// Visit and wait for completion
+	s.visitAndWait()
+}
+
+// loadCrawlState loads the crawl state of the website when resuming,
+// or starts a new one.
+func loadCrawlState(site Site, options *config.Options, log *Logger) *CrawlState {
+	path := CrawlStatePath(options.CacheDir, site.Name())
+	if !options.Resume {
+		return NewCrawlState(path, site.Name())
+	}
+
+	state, err := LoadCrawlState(path, site.Name())
+	if err != nil {
+		log.Error("Can't load crawl state", pterm.White(path), "starting from scratch:", err)
+		return NewCrawlState(path, site.Name())
+	}
+
+	return state
+}
+
+// trackStats counts the responses received and the requests failed,
+// and the movies visited.
+func (s *Session) trackStats() {
+	if s.Stats == nil {
+		return
+	}
+
+	for _, c := range append([]*colly.Collector{s.Index, s.Movies}, s.extra...) {
+		c.OnResponse(func(r *colly.Response) {
+			s.Stats.AddResponse(r.StatusCode, len(r.Body))
+		})
+
+		// Requests interrupted on shutdown didn't fail, nor
+		// those disallowed by robots.txt which were not made
+		c.OnError(func(r *colly.Response, err error) {
+			if s.Stopped() {
+				return
+			}
+			if errors.Is(err, robots.ErrDisallowed) {
+				s.Log.Debug("Skipping", URLField(r.Request.URL.String()), "disallowed by robots.txt")
+				s.Stats.AddDisallowed(r.Request.URL.String())
+				return
+			}
+			if r.StatusCode != 0 {
+				s.Stats.AddResponse(r.StatusCode, len(r.Body))
+			}
+			s.Stats.AddFailure(MovieFromContext(r.Ctx), r.Request.URL.String(), r.StatusCode, err)
+		})
+	}
+
+	s.Movies.OnRequest(func(r *colly.Request) {
+		s.Stats.VisitMovie(MovieFromContext(r.Ctx))
+	})
+}
+
+// trackRequests marks the requests made for movies as finished
+func (s *Session) trackRequests() {
+	s.Movies.OnScraped(func(r *colly.Response) {
+		s.finish(r.Ctx)
+	})
+
+	// Requests interrupted on shutdown are still to do
+	s.Movies.OnError(func(r *colly.Response, err error) {
+		if !s.Stopped() {
+			s.finish(r.Ctx)
+		}
+	})
+}
+
+// skipSavedImages aborts requests for images already saved,
+// before downloading them again.
+func (s *Session) skipSavedImages() {
+	s.Movies.OnRequest(func(r *colly.Request) {
+		movie := MovieFromContext(r.Ctx)
+		fileName, _ := LayoutFor(s.Options).RequestFileName(movie, RequestFileName(r.URL))
+		if !s.hasImage(movie, r.URL.String(), fileName) {
+			return
+		}
+
+		s.Log.Debug("Image already saved, skipping", URLField(r.URL.String()))
+		r.Abort()
+
+		if s.Stats != nil {
+			s.Stats.IncrSkipped()
+			s.Stats.FoundImage(movie)
+		}
+		s.finish(r.Ctx)
+	})
+}
+
+// hasImage tells if the image found at a URL was already saved for a
+// movie. The manifest of the movie is read once, and kept up to date
+// in memory while the movie is being scraped.
+func (s *Session) hasImage(movie Movie, imageURL, fileName string) bool {
+	if movie.Path == "" {
+		return false
+	}
+
+	unlock := lockManifest(movie.Path)
+	defer unlock()
+
+	manifest, err := openManifest(movie.Path)
+	if err != nil {
+		return false
+	}
+	return manifest.HasImage(movie.Path, imageURL, fileName, s.Options.VerifyImages)
+}
+
+// finish marks a request made for a movie as finished. Once the movie
+// is done, its manifest is no longer kept in memory.
+func (s *Session) finish(ctx *colly.Context) {
+	if !s.State.Finish(ctx) {
+		return
+	}
+	if moviePath := MovieFromContext(ctx).Path; moviePath != "" {
+		closeManifest(moviePath)
+	}
+}
+
+// Context is done when scraping must stop
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Stopped tells if scraping must stop, eg. on shutdown.
+// No new request is made once stopped.
+func (s *Session) Stopped() bool {
+	return s.ctx.Err() != nil
+}
+
+// resume queues again the requests left to do for movies
+// that were not fully scraped last time.
+func (s *Session) resume() {
+	for movieURL, requests := range s.State.Resume() {
+		s.Log.Info("Resuming movie", URLField(movieURL), "with", pterm.White(len(requests)), "request(s) left")
+		for _, pending := range requests {
+			if err := s.request(pending.URL, pending.NewContext()); err != nil {
+				s.Log.Error("Can't resume request", URLField(pending.URL), ":", err)
+			}
+		}
+	}
+}
+
+// Clone creates a new collector sharing the settings of the index
+// scraper, eg. to visit listing pages. The session waits for it
+// before the movie scraper.
+func (s *Session) Clone() *colly.Collector {
+	c := s.Index.Clone()
+	s.extra = append(s.extra, c)
+
+	// Listing pages are cached like the index page
+	c.OnRequest(func(r *colly.Request) {
+		r.Headers.Set(pagecache.KindHeader, pagecache.KindIndex)
+	})
+
+	return c
+}
+
+// NewMovie creates a Movie found on the website being scraped
+func (s *Session) NewMovie(name, year, url string) Movie {
+	return NewMovie(name, year, url, s.Site.Name(), s.Options)
+}
+
+// QueueMovie visits the page of a movie found on the website,
+// unless it was fully scraped before or is already queued.
+func (s *Session) QueueMovie(movie Movie) {
+	if !s.State.Start(movie) {
+		if s.State.IsDone(movie.URL) {
+			s.Log.Info("Movie already scraped, skipping:", MovieField(movie.Name))
+		}
+		return
+	}
+
+	// Keep the movie for later when stopping
+	if s.Stopped() {
+		_ = s.request(movie.URL, movie.ToContext())
+		return
+	}
+
+	s.Log.Info("Found movie page for:", MovieField(movie.Name))
+
+	if s.Stats != nil {
+		s.Stats.IncrMovies()
+	}
+
+	if err := s.request(movie.URL, movie.ToContext()); err != nil {
+		s.Log.Error("Can't get movie page", MovieField(movie.Name), URLField(movie.URL), ":", err)
+	}
+}
+
+// Visit follows a link found while scraping a movie, eg. to one of its
+// stills. Links must be followed through the session to keep track of
+// what is left to do for the movie, in case scraping is interrupted.
+func (s *Session) Visit(r *colly.Request, link string) error {
+	return s.request(r.AbsoluteURL(link), r.Ctx.Clone())
+}
+
+// request queues a request for a movie, with its own context.
+// Once stopped, requests are only kept in the crawl state.
+func (s *Session) request(requestURL string, ctx *colly.Context) error {
+	s.State.Queue(ctx, requestURL)
+	if s.Stopped() {
+		return nil
+	}
+
+	// Requests cancelled while stopping are still to do, requests
+	// disallowed by robots.txt were counted already
+	if err := s.Movies.Request("GET", requestURL, nil, ctx, nil); err != nil {
+		if s.Stopped() {
+			return nil
+		}
+		s.finish(ctx)
+		if errors.Is(err, robots.ErrDisallowed) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// visitAndWait visits the index URL and waits for every collector
+func (s *Session) visitAndWait() {
+	indexURL := s.Site.IndexURL()
+	if err := s.Index.Visit(indexURL); err != nil && !s.Stopped() && !errors.Is(err, robots.ErrDisallowed) {
+		s.Log.Error("Can't visit index page", URLField(indexURL), ":", err)
+	}
+
+	s.Index.Wait()
+	for _, c := range s.extra {
+		c.Wait()
+	}
+	s.Movies.Wait()
+}
@@ -0,0 +1,99 @@
+package scraper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Site is implemented by every website we know how to scrape
+// movie stills from.
+type Site interface {
+	// Name is the simplified name of the website, used on the CLI
+	Name() string
+
+	// Description is a short summary of what the website provides
+	Description() string
+
+	// IndexURL is the first page visited on the website
+	IndexURL() string
+
+	// AllowedDomains are the only domains the scrapers can visit
+	AllowedDomains() []string
+
+	// DiscoverMovies sets up the index scraper to find movie pages
+	// and queue them with the session.
+	DiscoverMovies(s *Session)
+
+	// ExtractImages sets up the movie scraper to find and visit
+	// movie stills on movie pages, through the session.
+	ExtractImages(s *Session)
+}
+
+// CharsetDetector can be implemented by websites that don't
+// declare the charset of their pages properly.
+type CharsetDetector interface {
+	DetectCharset() bool
+}
+
+// ImageValidator can be implemented by websites that need to
+// discard some images before they get saved.
+type ImageValidator interface {
+	ValidateImage(r *colly.Response) error
+}
+
+// Available websites, populated by the websites package
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Site)
+)
+
+// Register makes a website available to the app.
+// It panics if a website with the same name already exists.
+func Register(site Site) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := site.Name()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("scraper: website %q registered twice", name))
+	}
+	registry[name] = site
+}
+
+// Lookup returns the website registered with the given name
+func Lookup(name string) (Site, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	site, exists := registry[name]
+	return site, exists
+}
+
+// Sites returns every registered website sorted by name
+func Sites() []Site {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	sites := make([]Site, 0, len(registry))
+	for _, site := range registry {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Name() < sites[j].Name()
+	})
+
+	return sites
+}
+
+// Names returns the names of every registered website, sorted
+func Names() []string {
+	sites := Sites()
+	names := make([]string, 0, len(sites))
+	for _, site := range sites {
+		names = append(names, site.Name())
+	}
+	return names
+}
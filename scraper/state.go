@@ -0,0 +1,309 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/pterm/pterm"
+)
+
+// CrawlStateFile is the name of the file keeping track of the
+// progress of a website, saved in its cache folder.
+const CrawlStateFile = "state.json"
+
+// How often the crawl state is saved while scraping
+const checkpointInterval = 5 * time.Second
+
+// Context key of the URL a request was queued with. Requests can
+// be redirected, so their own URL can't be used to find them back.
+const pendingKey = "pending_url"
+
+// CrawlState keeps track of the movies discovered on a website,
+// the ones fully scraped and the requests left to do for the
+// others, so an interrupted scraping can be resumed.
+type CrawlState struct {
+	Site      string                 `json:"site"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Movies    map[string]*MovieState `json:"movies"`
+
+	mu    sync.Mutex
+	path  string
+	dirty bool
+
+	// Movies queued during this run, by URL
+	started map[string]bool
+}
+
+// MovieState is the progress of a movie, found by its URL
+type MovieState struct {
+	Name    string           `json:"name"`
+	Year    string           `json:"year,omitempty"`
+	Path    string           `json:"path"`
+	Done    bool             `json:"done"`
+	Pending []PendingRequest `json:"pending,omitempty"`
+}
+
+// PendingRequest is a request queued for a movie but not done yet,
+// with its context to run it again the same way.
+type PendingRequest struct {
+	URL     string            `json:"url"`
+	Context map[string]string `json:"context,omitempty"`
+}
+
+// Crawl states of the websites being scraped, saved on shutdown
+var crawlStates sync.Map
+
+// CrawlStatePath is where the crawl state of a website is saved
+func CrawlStatePath(cacheDir, website string) string {
+	return filepath.Join(cacheDir, website, CrawlStateFile)
+}
+
+// NewCrawlState creates an empty crawl state saved at the given path
+func NewCrawlState(path, website string) *CrawlState {
+	return &CrawlState{
+		Site:    website,
+		Movies:  make(map[string]*MovieState),
+		path:    path,
+		dirty:   true,
+		started: make(map[string]bool),
+	}
+}
+
+// LoadCrawlState loads the crawl state saved at the given path.
+// An empty state is returned if nothing was saved yet.
+func LoadCrawlState(path, website string) (*CrawlState, error) {
+	state := NewCrawlState(path, website)
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, err
+	}
+	if state.Movies == nil {
+		state.Movies = make(map[string]*MovieState)
+	}
+
+	return state, nil
+}
+
+// Save writes the crawl state to its file
+func (c *CrawlState) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.UpdatedAt = time.Now().UTC()
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, append(content, '\n')); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Checkpoint saves the crawl state if it changed since last time
+func (c *CrawlState) Checkpoint() error {
+	c.mu.Lock()
+	dirty := c.dirty
+	c.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+	return c.Save()
+}
+
+// Start records a movie found on the website. Returns false if the
+// movie must not be scraped, because it was fully scraped before
+// or is already being scraped.
+func (c *CrawlState) Start(movie Movie) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started[movie.URL] {
+		return false
+	}
+	if state, exists := c.Movies[movie.URL]; exists && state.Done {
+		return false
+	}
+
+	c.started[movie.URL] = true
+	c.Movies[movie.URL] = &MovieState{
+		Name: movie.Name,
+		Year: movie.Year,
+		Path: movie.Path,
+	}
+	c.dirty = true
+
+	return true
+}
+
+// IsDone tells if a movie was fully scraped
+func (c *CrawlState) IsDone(movieURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, exists := c.Movies[movieURL]
+	return exists && state.Done
+}
+
+// Queue records a request made for a movie, with the context it
+// is made with. The URL is kept in the context to find it back.
+func (c *CrawlState) Queue(ctx *colly.Context, requestURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx.Put(pendingKey, requestURL)
+
+	state := c.movie(ctx)
+	state.Done = false
+	state.Pending = append(state.Pending, PendingRequest{
+		URL:     requestURL,
+		Context: contextValues(ctx),
+	})
+	c.dirty = true
+}
+
+// Finish records the end of a request made for a movie, and tells if
+// the movie is now done, once all of its requests are finished.
+func (c *CrawlState) Finish(ctx *colly.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Requests can be finished twice, eg. on parsing errors
+	requestURL := ctx.Get(pendingKey)
+	if requestURL == "" {
+		return false
+	}
+	ctx.Put(pendingKey, "")
+
+	state := c.movie(ctx)
+	for i, pending := range state.Pending {
+		if pending.URL == requestURL {
+			state.Pending = append(state.Pending[:i], state.Pending[i+1:]...)
+			break
+		}
+	}
+	state.Done = len(state.Pending) == 0
+	c.dirty = true
+
+	return state.Done
+}
+
+// Resume returns the requests left to do for the movies that were not
+// fully scraped, by movie URL. These movies are considered started.
+func (c *CrawlState) Resume() map[string][]PendingRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resumed := make(map[string][]PendingRequest)
+	for movieURL, state := range c.Movies {
+		if state.Done || len(state.Pending) == 0 {
+			continue
+		}
+
+		// Requests will be queued again
+		resumed[movieURL] = state.Pending
+		state.Pending = nil
+		c.started[movieURL] = true
+	}
+	c.dirty = true
+
+	return resumed
+}
+
+// movie returns the state of the movie a request is made for
+func (c *CrawlState) movie(ctx *colly.Context) *MovieState {
+	movie := MovieFromContext(ctx)
+	state, exists := c.Movies[movie.URL]
+	if !exists {
+		state = &MovieState{Name: movie.Name, Year: movie.Year, Path: movie.Path}
+		c.Movies[movie.URL] = state
+	}
+	return state
+}
+
+// contextValues returns the text values of a context
+func contextValues(ctx *colly.Context) map[string]string {
+	values := make(map[string]string)
+	ctx.ForEach(func(k string, v interface{}) interface{} {
+		if text, ok := v.(string); ok && k != pendingKey {
+			values[k] = text
+		}
+		return nil
+	})
+	return values
+}
+
+// NewContext rebuilds the context a pending request was made with
+func (p PendingRequest) NewContext() *colly.Context {
+	ctx := colly.NewContext()
+	for k, v := range p.Context {
+		ctx.Put(k, v)
+	}
+	return ctx
+}
+
+// trackCrawlState saves the crawl state regularly until the returned
+// function is called, and on shutdown with SaveCrawlStates.
+func trackCrawlState(state *CrawlState, log *Logger) func() {
+	crawlStates.Store(state, true)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := state.Checkpoint(); err != nil {
+					log.Error("Can't save crawl state", pterm.White(state.path), err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		crawlStates.Delete(state)
+		if err := state.Save(); err != nil {
+			log.Error("Can't save crawl state", pterm.White(state.path), err)
+		}
+	}
+}
+
+// SaveCrawlStates saves the crawl state of every website being
+// scraped, eg. before quitting so they can be resumed later.
+func SaveCrawlStates() error {
+	var err error
+	crawlStates.Range(func(key, _ interface{}) bool {
+		if saveErr := key.(*CrawlState).Save(); saveErr != nil {
+			err = saveErr
+		}
+		return true
+	})
+	return err
+}
@@ -0,0 +1,83 @@
+package scraper
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestCrawlState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example", CrawlStateFile)
+	state := NewCrawlState(path, "example")
+
+	movie := Movie{Name: "Alien", URL: "https://example.com/alien", Path: "data/example/Alien", Site: "example"}
+	if !state.Start(movie) {
+		t.Fatal("Start() refused a new movie")
+	}
+	if state.Start(movie) {
+		t.Error("Start() accepted a movie already started")
+	}
+
+	// The movie page queues two images before being scraped
+	page := movie.ToContext()
+	state.Queue(page, movie.URL)
+	images := []*colly.Context{page.Clone(), page.Clone()}
+	state.Queue(images[0], "https://example.com/1.jpg")
+	state.Queue(images[1], "https://example.com/2.jpg")
+	if state.Finish(page) || state.Finish(page) || state.Finish(images[0]) {
+		t.Error("Finish() tells the movie is done with an image left to do")
+	}
+
+	if state.IsDone(movie.URL) {
+		t.Error("Movie is done with an image left to do")
+	}
+
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	loaded, err := LoadCrawlState(path, "example")
+	if err != nil {
+		t.Fatalf("LoadCrawlState() unexpected error: %v", err)
+	}
+
+	resumed := loaded.Resume()
+	pending := resumed[movie.URL]
+	if len(resumed) != 1 || len(pending) != 1 || pending[0].URL != "https://example.com/2.jpg" {
+		t.Fatalf("Resume() = %+v, expected the second image only", resumed)
+	}
+	if loaded.Start(movie) {
+		t.Error("Start() accepted a resumed movie")
+	}
+
+	// The context of the request is the same as before
+	ctx := pending[0].NewContext()
+	if resumedMovie := MovieFromContext(ctx); resumedMovie != movie {
+		t.Errorf("Resumed request is for %+v, expected %+v", resumedMovie, movie)
+	}
+
+	loaded.Queue(ctx, pending[0].URL)
+	if !loaded.Finish(ctx) || !loaded.IsDone(movie.URL) {
+		t.Error("Movie is not done once all requests are finished")
+	}
+
+	// Movies done are not scraped again
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = LoadCrawlState(path, "example")
+	if loaded.Start(movie) {
+		t.Error("Start() accepted a movie already done")
+	}
+}
+
+func TestLoadMissingCrawlState(t *testing.T) {
+	state, err := LoadCrawlState(filepath.Join(t.TempDir(), CrawlStateFile), "example")
+	if err != nil {
+		t.Fatalf("LoadCrawlState() unexpected error: %v", err)
+	}
+	if len(state.Movies) != 0 {
+		t.Errorf("Missing crawl state has movies: %v", state.Movies)
+	}
+}
@@ -0,0 +1,80 @@
+package scraper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"moviestills/config"
+	"strings"
+	"sync"
+)
+
+// Reasons images are rejected before being saved
+const (
+	RejectedCorrupt     = "corrupt"
+	RejectedTooSmall    = "too small"
+	RejectedPlaceholder = "placeholder"
+	RejectedInvalid     = "invalid"
+)
+
+// RejectedError tells why an image was rejected
+type RejectedError struct {
+	Reason string
+	Err    error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Known placeholders served by image hosts instead of
+// images removed, by SHA-256 hash of their content.
+var placeholders sync.Map
+
+// RegisterPlaceholder adds the SHA-256 hash of an image served by a
+// host in place of removed images, eg. imgur's "removed.png".
+func RegisterPlaceholder(hash, description string) {
+	placeholders.Store(strings.ToLower(hash), description)
+}
+
+// Placeholder tells if content is a known placeholder, and which one
+func Placeholder(content []byte) (string, bool) {
+	description, found := placeholders.Load(SHA256(content))
+	if !found {
+		return "", false
+	}
+	return description.(string), true
+}
+
+// DecodeImage decodes an image before saving it. Truncated or corrupt
+// files, images smaller than the minimum dimensions of the options and
+// known placeholders are rejected with a RejectedError.
+func DecodeImage(content []byte, options *config.Options) (image.Image, error) {
+	if description, found := Placeholder(content); found {
+		return nil, &RejectedError{RejectedPlaceholder, fmt.Errorf("placeholder %s", description)}
+	}
+
+	for _, hash := range options.Placeholders {
+		if strings.EqualFold(hash, SHA256(content)) {
+			return nil, &RejectedError{RejectedPlaceholder, errors.New("placeholder given in options")}
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, &RejectedError{RejectedCorrupt, err}
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() < options.MinWidth || bounds.Dy() < options.MinHeight {
+		return nil, &RejectedError{RejectedTooSmall, fmt.Errorf("%dx%d pixels, expected at least %dx%d",
+			bounds.Dx(), bounds.Dy(), options.MinWidth, options.MinHeight)}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,58 @@
+package scraper
+
+import (
+	"errors"
+	"moviestills/config"
+	"testing"
+)
+
+func TestDecodeImage(t *testing.T) {
+	still := encodePNG(t, tiledImage(1, 640, 360))
+	removed := encodePNG(t, tiledImage(3, 640, 360))
+	givenPlaceholder := encodePNG(t, tiledImage(4, 640, 360))
+	thumbnail := encodePNG(t, tiledImage(1, 160, 90))
+
+	RegisterPlaceholder(SHA256(removed), "removed image")
+	options := &config.Options{MinWidth: 500, MinHeight: 265, Placeholders: []string{SHA256(givenPlaceholder)}}
+
+	cases := []struct {
+		name    string
+		content []byte
+		reason  string
+	}{
+		{"valid", still, ""},
+		{"truncated", still[:len(still)/2], RejectedCorrupt},
+		{"not an image", []byte("<html></html>"), RejectedCorrupt},
+		{"thumbnail", thumbnail, RejectedTooSmall},
+		{"known placeholder", removed, RejectedPlaceholder},
+		{"placeholder given in options", givenPlaceholder, RejectedPlaceholder},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			img, err := DecodeImage(c.content, options)
+			if c.reason == "" {
+				if err != nil || img == nil {
+					t.Fatalf("Valid image rejected: %v", err)
+				}
+				return
+			}
+
+			var rejected *RejectedError
+			if !errors.As(err, &rejected) || rejected.Reason != c.reason {
+				t.Fatalf("DecodeImage() = %v, expected to be rejected as %s", err, c.reason)
+			}
+		})
+	}
+}
+
+func TestIncrRejected(t *testing.T) {
+	stats := &Stats{}
+	stats.IncrRejected(&RejectedError{RejectedTooSmall, errors.New("tiny")})
+	stats.IncrRejected(&RejectedError{RejectedCorrupt, errors.New("truncated")})
+	stats.IncrRejected(errors.New("unknown"))
+
+	if stats.ImagesFailed != 3 || stats.ImagesTooSmall != 1 || stats.ImagesCorrupt != 1 {
+		t.Errorf("Rejected images not counted by reason: %+v", stats)
+	}
+}
@@ -1,12 +1,30 @@
 package main
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"moviestills/catalog"
 	"moviestills/config"
+	"moviestills/hostlimit"
+	"moviestills/metrics"
+	"moviestills/proxypool"
+	"moviestills/retry"
+	"moviestills/robots"
+	"moviestills/scraper"
 	"moviestills/utils"
+	"moviestills/websites"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/alexflint/go-arg"
 	"github.com/pterm/pterm"
 )
 
@@ -14,14 +32,110 @@ func clearScreen() {
 	print("\033[H\033[2J")
 }
 
-func handleShutdown() {
-	sigChan := make(chan os.Signal, 1)
+// handleShutdown cancels the returned context on the first signal, so
+// scrapers stop cleanly and print what they did. The second signal
+// forces the app to quit.
+func handleShutdown() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		<-sigChan
-		pterm.Info.Println("Shutting down...")
+		pterm.Info.Println("Shutting down, waiting for downloads in progress... Press CTRL+C again to force quit.")
+		cancel()
+
+		<-sigChan
+		pterm.Info.Println("Forcing shutdown...")
+
+		// Save where we stopped to resume later with --resume
+		if err := scraper.SaveCrawlStates(); err != nil {
+			pterm.Error.Println("Can't save crawl state:", pterm.Red(err))
+		}
 		os.Exit(130)
 	}()
+
+	return ctx
+}
+
+// resolveOptions reads the config file, if any, under the options set
+// through the CLI or environment variables
+func resolveOptions(parser *arg.Parser, options *config.Options) *config.Origins {
+	origins, err := options.Resolve(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		parser.Fail(err.Error())
+	}
+	return origins
+}
+
+// validateOptions checks the options arguments can't check by themselves
+func validateOptions(parser *arg.Parser, options *config.Options) {
+	switch options.Dedup {
+	case scraper.DedupMove, scraper.DedupSkip, scraper.DedupOff:
+	default:
+		parser.Fail("--dedup must be one of: move, skip, off")
+	}
+
+	if options.DedupDistance < 0 || options.DedupDistance > 64 {
+		parser.Fail("--dedup-distance must be between 0 and 64")
+	}
+
+	if options.MaxAttempts < 1 {
+		parser.Fail("--max-attempts must be at least 1")
+	}
+	if options.RetryDelay < 0 || options.RetryMaxDelay < options.RetryDelay {
+		parser.Fail("--retry-delay can't be negative, nor longer than --retry-max-delay")
+	}
+	for _, class := range options.RetryOn {
+		if !slices.Contains(retry.Classes, class) {
+			parser.Fail("--retry-on must be one of: " + strings.Join(retry.Classes, ", "))
+		}
+	}
+
+	if _, err := options.HostLimitRules(); err != nil {
+		parser.Fail(err.Error())
+	}
+	if options.Parallel < 0 || options.Rate < 0 || options.RandomDelay < 0 {
+		parser.Fail("--parallel, --rate and --delay can't be negative")
+	}
+
+	if _, err := options.ProxyList(); err != nil {
+		parser.Fail(err.Error())
+	}
+	switch options.ProxyRotation {
+	case proxypool.RoundRobin, proxypool.Random:
+	default:
+		parser.Fail("--proxy-rotation must be round-robin or random")
+	}
+	if options.ProxyMaxFailures < 0 || options.ProxyEjectFor < 0 {
+		parser.Fail("--proxy-max-failures and --proxy-eject can't be negative")
+	}
+
+	switch options.LogFormat {
+	case scraper.LogText, scraper.LogJSON:
+	default:
+		parser.Fail("--log-format must be one of: text, json")
+	}
+
+	switch options.LinkMode {
+	case scraper.LinkAuto, scraper.LinkReflink, scraper.LinkHardlink:
+	default:
+		parser.Fail("--link-mode must be one of: auto, reflink, hardlink")
+	}
+
+	switch options.Naming {
+	case scraper.NamingURL:
+	case scraper.NamingContent:
+		if options.FileNameTemplate != scraper.DefaultFileNameTemplate {
+			parser.Fail("--naming=content names images by their content, --filename-template can't be used with it")
+		}
+	default:
+		parser.Fail("--naming must be one of: url, content")
+	}
+
+	if _, err := scraper.NewLayout(options.PathTemplate, options.FileNameTemplate, options.Hash); err != nil {
+		parser.Fail(err.Error())
+	}
 }
 
 func setupLogging(options *config.Options) {
@@ -35,6 +149,36 @@ func setupLogging(options *config.Options) {
 	if options.NoColors {
 		pterm.DisableColor()
 	}
+
+	// Structured logs go to the log file, or to the standard output
+	// instead of the pretty output, moved to the standard error.
+	var w io.Writer
+	switch {
+	case options.LogFile != "":
+		file, err := os.OpenFile(options.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			pterm.Error.Println("Can't open the log file", pterm.White(options.LogFile), pterm.Red(err))
+			os.Exit(1)
+		}
+		w = file
+	case options.LogFormat == scraper.LogJSON:
+		pterm.SetDefaultOutput(os.Stderr)
+		w = os.Stdout
+	default:
+		return
+	}
+
+	level := slog.LevelInfo
+	if options.Debug {
+		level = slog.LevelDebug
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(w, handlerOptions)
+	if options.LogFormat == scraper.LogJSON {
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	}
+	scraper.SetupLogging(handler, options.LogFile == "")
 }
 
 func setupDirectories(options *config.Options) {
@@ -50,3 +194,199 @@ func setupDirectories(options *config.Options) {
 		os.Exit(1)
 	}
 }
+
+func loadDefinitions(options *config.Options) {
+	definitions, err := websites.LoadDefinitions(options.SitesDir)
+	if err != nil {
+		pterm.Error.Println("Can't load website definitions:", pterm.Red(err))
+		os.Exit(1)
+	}
+
+	// Make websites defined in files available, unless
+	// they conflict with an existing scraper.
+	for _, def := range definitions {
+		if _, exists := scraper.Lookup(def.Name); exists {
+			pterm.Warning.Println("A scraper already exists for", pterm.White(def.Name), "ignoring", pterm.White(def.Path))
+			continue
+		}
+		scraper.Register(def.Site())
+		pterm.Debug.Println("Loaded website definition", pterm.White(def.Path))
+	}
+}
+
+// openCatalog starts recording a run in the catalog, unless disabled.
+// The returned function records the end of the run and closes the catalog.
+func openCatalog(options *config.Options, websitesToScrape []string) (scraper.Recorder, func()) {
+	if options.NoCatalog {
+		return nil, func() {}
+	}
+
+	path := options.CatalogPath()
+	cat, err := catalog.Open(path)
+	if err != nil {
+		pterm.Error.Println("Can't open the catalog", pterm.White(path), pterm.Red(err))
+		os.Exit(1)
+	}
+
+	run, err := cat.StartRun("scrape", websitesToScrape)
+	if err != nil {
+		pterm.Error.Println("Can't record the run in the catalog", pterm.White(path), pterm.Red(err))
+		os.Exit(1)
+	}
+
+	return run, func() {
+		if err := run.Finish(); err != nil {
+			pterm.Warning.Println("Can't record the end of the run in the catalog", pterm.Red(err))
+		}
+		if err := cat.Close(); err != nil {
+			pterm.Warning.Println("Can't close the catalog", pterm.Red(err))
+		}
+	}
+}
+
+// openLinker links images identical to one saved before as they are
+// saved, if asked to. The linker is nil otherwise.
+func openLinker(options *config.Options) (*scraper.Linker, func()) {
+	if !options.LinkIdentical {
+		return nil, func() {}
+	}
+
+	index, err := scraper.OpenContentIndex(options.DataDir, false)
+	if err != nil {
+		pterm.Error.Println("Can't open the index of saved images", pterm.Red(err))
+		os.Exit(1)
+	}
+
+	linker := scraper.NewLinker(index, options.LinkMode, false)
+	return linker, func() {
+		if err := index.Close(); err != nil {
+			pterm.Warning.Println("Can't close the index of saved images", pterm.Red(err))
+		}
+		printLinkStats(linker.Stats(), false)
+	}
+}
+
+// newHostLimiter limits the requests made to hosts, for all websites
+func newHostLimiter(options *config.Options) *hostlimit.Limiter {
+	// Rules were checked with the other options
+	rules, _ := options.HostLimitRules()
+	return hostlimit.New(rules...)
+}
+
+// warnCappedParallel warns about websites set to make more requests at
+// once than the limits of their hosts allow, these being shared by all
+// websites. Websites allowing any domain are checked with the default
+// limits.
+func warnCappedParallel(options *config.Options, websites []string) {
+	// Rules were checked with the other options
+	rules, _ := options.HostLimitRules()
+
+	for _, website := range websites {
+		parallel := options.Sites[website].Parallel
+		site, exists := scraper.Lookup(website)
+		if parallel == nil || !exists {
+			continue
+		}
+
+		hosts := site.AllowedDomains()
+		if len(hosts) == 0 {
+			hosts = []string{"*"}
+		}
+		// Requests are made to lowercase hosts
+		warned := make(map[string]bool)
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			if warned[host] {
+				continue
+			}
+			warned[host] = true
+
+			rule, limited := hostlimit.Match(rules, host)
+			if !limited || rule.Parallelism == 0 || *parallel <= rule.Parallelism {
+				continue
+			}
+			pterm.Warning.Println("Option", pterm.White(website+".parallel="+strconv.Itoa(*parallel)), "is capped at",
+				pterm.White(rule.Parallelism), "requests at once to", pterm.White(host)+", raise it with",
+				pterm.White("--host-limit '"+host+":parallel="+strconv.Itoa(*parallel)+"'"))
+		}
+	}
+}
+
+// newProxyPool holds the proxies of all websites, and warns
+// when one of them is set aside
+func newProxyPool(options *config.Options) *proxypool.Pool {
+	pool := proxypool.New(options.ProxyPolicy())
+	pool.OnEject = func(stats proxypool.Stats) {
+		pterm.Warning.Println("Setting proxy", pterm.White(stats.URL), "aside for", pterm.White(options.ProxyEjectFor),
+			"after", pterm.White(options.ProxyMaxFailures), "failed requests in a row")
+	}
+	return pool
+}
+
+// newRobots follows the robots.txt files of hosts, if asked to.
+// Robots are nil otherwise.
+func newRobots(options *config.Options) *robots.Robots {
+	if !options.RespectRobots {
+		return nil
+	}
+
+	r := robots.New(scraper.UserAgent)
+	r.OnUnreachable = func(host string, err error) {
+		pterm.Warning.Println("Can't read the robots.txt of", pterm.White(host), "allowing everything:", pterm.Red(err))
+	}
+	return r
+}
+
+// serveMetrics serves the metrics of the scrapers, if asked to.
+// The metrics are nil otherwise.
+func serveMetrics(options *config.Options) (*metrics.Metrics, func()) {
+	if options.MetricsAddr == "" {
+		return nil, func() {}
+	}
+
+	// Listen first, so a wrong address stops the app before scraping
+	listener, err := net.Listen("tcp", options.MetricsAddr)
+	if err != nil {
+		pterm.Error.Println("Can't serve metrics on", pterm.White(options.MetricsAddr), pterm.Red(err))
+		os.Exit(1)
+	}
+
+	m := metrics.New()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			pterm.Error.Println("Can't serve metrics", pterm.Red(err))
+		}
+	}()
+	pterm.Info.Println("Serving metrics on", pterm.White("http://"+listener.Addr().String()+"/metrics"))
+
+	return m, func() {
+		_ = server.Close()
+	}
+}
+
+// writeReports writes the reports of the run asked for, if any
+func writeReports(options *config.Options, aggStats *scraper.AggregatedStats, started time.Time, stopped bool) {
+	if options.Report == "" && options.ReportJUnit == "" {
+		return
+	}
+
+	report := scraper.NewReport(options, aggStats, started, stopped)
+	if options.Report != "" {
+		if err := report.WriteJSON(options.Report); err != nil {
+			pterm.Error.Println("Can't write the report", pterm.White(options.Report), pterm.Red(err))
+		} else {
+			pterm.Info.Println("Report written to", pterm.White(options.Report))
+		}
+	}
+	if options.ReportJUnit != "" {
+		if err := report.WriteJUnit(options.ReportJUnit); err != nil {
+			pterm.Error.Println("Can't write the JUnit report", pterm.White(options.ReportJUnit), pterm.Red(err))
+		} else {
+			pterm.Info.Println("JUnit report written to", pterm.White(options.ReportJUnit))
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 )
 
 func TestGetHTMLCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test requesting live websites in short mode")
+	}
+
 	cases := []struct {
 		in string
 	}{
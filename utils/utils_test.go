@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestNormalize(t *testing.T) {
@@ -141,3 +144,20 @@ func TestCreateFolder(t *testing.T) {
 		})
 	}
 }
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Sleep() unexpected error: %v", err)
+	}
+
+	// A cancelled context stops waiting at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := Sleep(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Sleep() waited %s once cancelled", elapsed)
+	}
+}
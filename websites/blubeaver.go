@@ -1,10 +1,8 @@
 package websites
 
 import (
-	"moviestills/config"
 	"moviestills/scraper"
 	"moviestills/utils"
-	"strconv"
 
 	"github.com/gocolly/colly/v2"
 	"github.com/pterm/pterm"
@@ -13,38 +11,53 @@ import (
 // BluBeaverURL is the webpage stores a list of links to movie reviews of Blu-rays
 const BluBeaverURL string = "http://www.dvdbeaver.com/blu-ray.htm"
 
-// BluBeaverScraper is the main function that handles all the scraping logic
-// for this website.
-func BluBeaverScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("blubeaver")
-
-	cfg := scraper.SiteConfig{
-		Name:     "blubeaver",
-		IndexURL: BluBeaverURL,
-		AllowedDomains: []string{
-			"www.blubeaver.ca",
-			"www.dvdbeaver.com",
-			"dvdbeaver.com",
-			"DVDBeaver.com",
-			"www.DVDBeaver.com",
-		},
-		DetectCharset: true,
-	}
+func init() {
+	scraper.Register(BluBeaver{})
+}
+
+// BluBeaver handles all the scraping logic for this website
+type BluBeaver struct{}
+
+// Name is the simplified name of the website
+func (BluBeaver) Name() string {
+	return "blubeaver"
+}
+
+// Description is a short summary of the website
+func (BluBeaver) Description() string {
+	return "Blu-ray reviews from DVDBeaver with native resolution snapshots"
+}
+
+// IndexURL is the first page visited on the website
+func (BluBeaver) IndexURL() string {
+	return BluBeaverURL
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// AllowedDomains are the only domains the scrapers can visit
+func (BluBeaver) AllowedDomains() []string {
+	return []string{
+		"www.blubeaver.ca",
+		"www.dvdbeaver.com",
+		"dvdbeaver.com",
+		"DVDBeaver.com",
+		"www.DVDBeaver.com",
+	}
+}
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
+// DetectCharset is needed as pages don't declare their charset properly
+func (BluBeaver) DetectCharset() bool {
+	return true
+}
 
-	// Setup the common image response handler
-	scraper.SetupImageResponseHandler(movieScraper, options, stats, log)
+// DiscoverMovies finds links to movies reviews on the index page
+func (BluBeaver) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Find links to movies reviews and isolate the movie's title.
 	// Since BluBeaver is somewhat a custom website, some links
 	// might have different cases. We use the CSS4 "i" case-insensitive
 	// feature to make sure our filter doesn't miss anything.
-	c.OnHTML("li a[href*='film' i][href$='htm' i]", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("li a[href*='film' i][href$='htm' i]", func(e *colly.HTMLElement) {
 		// Sometimes, Blubeaver made mistakes and added links to reviews
 		// on Amazon icons. Since we use the link to isolate the movie's title,
 		// we ignore these links as they don't have the movie's name included.
@@ -54,31 +67,27 @@ func BluBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 		}
 
 		movieURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found movie page link", pterm.White(movieURL))
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
 
 		// Remove weird accents and spaces from the movie's title
 		movieName, err := utils.Normalize(e.Text)
 		if err != nil {
-			log.Error("Can't normalize Movie name for", pterm.White(e.Text), ":", pterm.Red(err))
+			log.Error("Can't normalize Movie name for", pterm.White(e.Text), ":", err)
 			return
 		}
 
-		movie := scraper.NewMovie(movieName, "", movieURL, "blubeaver", options)
-		log.Info("Found movie page for:", pterm.White(movieName))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
-
-		if err = movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't get movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(movieName, "", movieURL))
 	})
+}
+
+// ExtractImages finds movie stills on movie reviews
+func (BluBeaver) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// It's rare but sometimes on BD reviews there are no large versions.
 	// Therefore we download the images as shown on the webpage and
 	// be sure we avoid some weird ones (subtitles, DVD covers etc).
-	movieScraper.OnHTML(
+	s.Movies.OnHTML(
 		":not(a) >"+
 			"img:not([src*='banner' i])"+
 			":not([src*='rating' i])"+
@@ -93,16 +102,10 @@ func BluBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 			":not([src*='menu' i])", func(e *colly.HTMLElement) {
 			movieImageURL := e.Request.AbsoluteURL(e.Attr("src"))
 
-			// Filter low resolutions images to avoid false positives.
-			// if the images are too small, we won't be able to use them
-			// anyway so let's skip them.
-			movieImageWidth, _ := strconv.Atoi(e.Attr("width"))
-			movieImageHeight, _ := strconv.Atoi(e.Attr("height"))
-
-			if movieImageHeight >= 265 && movieImageWidth >= 500 {
-				if err := e.Request.Visit(movieImageURL); err != nil {
-					log.Error("Can't get inline image", pterm.White(movieImageURL), ":", pterm.Red(err))
-				}
+			// Low resolution images are discarded once downloaded, based on
+			// their actual dimensions rather than the HTML attributes.
+			if err := s.Visit(e.Request, movieImageURL); err != nil {
+				log.Error("Can't get inline image", scraper.URLField(movieImageURL), ":", err)
 			}
 		})
 
@@ -112,31 +115,41 @@ func BluBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 	//
 	// We try to avoid images with "subs" in the filename as they are
 	// most likely images with subtitles on top. We don't want that.
-	movieScraper.OnHTML("a[href*='large' i]:not([href*='subs' i])", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("a[href*='large' i]:not([href*='subs' i])", func(e *colly.HTMLElement) {
 		movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found large image", pterm.White(movieImageURL))
-
-		if err := e.Request.Visit(movieImageURL); err != nil {
-			log.Error("Can't get large image", pterm.White(movieImageURL), ":", pterm.Red(err))
-
-			// Sometimes, the high quality version of an image
-			// is not available anymore ("Not Found").
-			//
-			// In this case, we can try to save the image
-			// shown on the webpage that has a lower resolution.
-			lowImageURL, imgExists := e.DOM.Find("img").Attr("src")
-			if !imgExists {
-				log.Error("Could not find an image inside link", pterm.White(movieImageURL))
-				return
-			}
+		log.Debug("Found large image", scraper.URLField(movieImageURL))
 
-			log.Info("Trying to save low quality image instead", pterm.White(lowImageURL))
-			if err := e.Request.Visit(e.Request.AbsoluteURL(lowImageURL)); err != nil {
-				log.Error("Can't get low resolution image", pterm.White(lowImageURL), ":", pterm.Red(err))
-			}
+		// Keep the image shown on the webpage in case
+		// the large version is not available anymore.
+		if lowImageURL, imgExists := e.DOM.Find("img").Attr("src"); imgExists {
+			e.Request.Ctx.Put(lowImageKey(movieImageURL), e.Request.AbsoluteURL(lowImageURL))
+		}
+
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't get large image", scraper.URLField(movieImageURL), ":", err)
 		}
 	})
 
-	// Visit and wait for completion
-	scraper.VisitAndWait(c, movieScraper, BluBeaverURL, log)
+	// Sometimes, the high quality version of an image
+	// is not available anymore ("Not Found").
+	//
+	// In this case, we can try to save the image
+	// shown on the webpage that has a lower resolution.
+	s.Movies.OnError(func(r *colly.Response, err error) {
+		lowImageURL := r.Ctx.Get(lowImageKey(r.Request.URL.String()))
+		if lowImageURL == "" || s.Stopped() {
+			return
+		}
+
+		log.Info("Trying to save low quality image instead", scraper.URLField(lowImageURL))
+		if err := s.Visit(r.Request, lowImageURL); err != nil {
+			log.Error("Can't get low resolution image", scraper.URLField(lowImageURL), ":", err)
+		}
+	})
+}
+
+// lowImageKey is the context key storing the lower resolution
+// version of a large image.
+func lowImageKey(largeImageURL string) string {
+	return "low_image:" + largeImageURL
 }
@@ -1,6 +1,7 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"strconv"
 	"testing"
@@ -10,6 +11,8 @@ import (
 
 // Test BluBeaver index page
 func TestIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(BluBeaverURL)
 
@@ -23,6 +26,8 @@ func TestIndexPage(t *testing.T) {
 // The "10" movie
 // Normal movie review with large images available
 func TestNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("http://www.dvdbeaver.com/film3/blu-ray_reviews53/10_blu-ray.htm")
 
@@ -69,6 +74,8 @@ func TestNormalMoviePage(t *testing.T) {
 // No large image versions are available so
 // we save the inlined images despite their average resolution
 func TestMoviePageWithOnlyInlineImages(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("http://www.dvdbeaver.com/film2/DVDReviews38/10000_BC_blu-ray.htm")
 
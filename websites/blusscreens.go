@@ -1,10 +1,9 @@
 package websites
 
 import (
-	"moviestills/config"
+	"fmt"
 	"moviestills/scraper"
 	"moviestills/utils"
-	"strconv"
 	"strings"
 
 	"github.com/gocolly/colly/v2"
@@ -26,105 +25,88 @@ const BlusURL string = "https://www.bluscreens.net/cinematographers.html"
 // on imgur, it returns a small image with some text on it. We don't want that.
 const MinimumSize int = 1024 * 20
 
-// BlusScraper is the main function that handles all the scraping
-// logic for this website.
-func BlusScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("blusscreens")
-
-	cfg := scraper.SiteConfig{
-		Name:     "blusscreens",
-		IndexURL: BlusURL,
-		AllowedDomains: []string{
-			"www.bluscreens.net",
-			"imgur.com",
-			"i.imgur.com",
-			"postimage.org",
-			"postimg.cc",
-			"i.postimg.cc",
-			"pixxxels.cc",
-			"i.pixxxels.cc",
-		},
-	}
+func init() {
+	scraper.Register(Blus{})
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// Blus handles all the scraping logic for this website
+type Blus struct{}
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
+// Name is the simplified name of the website
+func (Blus) Name() string {
+	return "blusscreens"
+}
 
-	// Custom response handler for this site (needs size filtering)
-	movieScraper.OnResponse(func(r *colly.Response) {
-		// Ignore anything that is not an image
-		if !strings.Contains(r.Headers.Get("Content-Type"), "image") {
-			return
-		}
+// Description is a short summary of the website
+func (Blus) Description() string {
+	return "High resolution screen captures taken directly from Blu-ray releases"
+}
 
-		// Calculate Image Size from Headers
-		imageSize, err := strconv.Atoi(r.Headers.Get("Content-Length"))
-		if err != nil {
-			log.Error("Can't get image size from headers:", pterm.Red(err))
-			return
-		}
+// IndexURL is the first page visited on the website
+func (Blus) IndexURL() string {
+	return BlusURL
+}
 
-		// Images are hosted on imgur and some might have been deleted. When an image is deleted
-		// on imgur, it returns a small image with some text on it. We don't want that.
-		if imageSize < MinimumSize {
-			log.Error("Small-sized image, not downloading", pterm.White(r.FileName()))
-			if stats != nil {
-				stats.IncrFailed()
-			}
-			return
-		}
+// AllowedDomains are the only domains the scrapers can visit
+func (Blus) AllowedDomains() []string {
+	return []string{
+		"www.bluscreens.net",
+		"imgur.com",
+		"i.imgur.com",
+		"postimage.org",
+		"postimg.cc",
+		"i.postimg.cc",
+		"pixxxels.cc",
+		"i.pixxxels.cc",
+	}
+}
 
-		movie := scraper.MovieFromContext(r.Ctx)
+// ValidateImage discards small-sized images.
+// Images are hosted on imgur and some might have been deleted. When an image is deleted
+// on imgur, it returns a small image with some text on it. We don't want that.
+func (Blus) ValidateImage(r *colly.Response) error {
+	// Content-Length is not always set, rely on what was downloaded
+	if len(r.Body) < MinimumSize {
+		return fmt.Errorf("small-sized image of %d bytes", len(r.Body))
+	}
 
-		if err := scraper.SaveImage(movie.Path, movie.Name, r.FileName(), r.Body, options.Hash, log); err != nil {
-			log.Error("Can't save image", pterm.White(r.FileName()), pterm.Red(err))
-			if stats != nil {
-				stats.IncrFailed()
-			}
-			return
-		}
+	return nil
+}
 
-		if stats != nil {
-			stats.IncrDownloaded()
-		}
-	})
+// DiscoverMovies finds links to movie pages on the cinematographers page
+func (Blus) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Isolate every movie listed, keep its title and
 	// create a dedicated folder if it doesn't exist
 	// to store images.
 	//
 	// Then visit movie page where images are listed/displayed.
-	c.OnHTML("h2.wsite-content-title a[href*=html]", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("h2.wsite-content-title a[href*=html]", func(e *colly.HTMLElement) {
 		// Remove weird accents and spaces from the movie's title
 		movieName, err := utils.Normalize(e.Text)
 		if err != nil {
-			log.Error("Can't normalize Movie name for", pterm.White(e.Text), ":", pterm.Red(err))
+			log.Error("Can't normalize Movie name for", pterm.White(e.Text), ":", err)
 			return
 		}
 
 		movieURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found movie page link", pterm.White(movieURL))
-
-		movie := scraper.NewMovie(movieName, "", movieURL, "blusscreens", options)
-		log.Info("Found movie page for:", pterm.White(movieName))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
 
-		if err = movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't get movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(movieName, "", movieURL))
 	})
+}
+
+// ExtractImages finds movie stills hosted on imgur and postimage
+func (Blus) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// Go through each link to imgur found on the movie page
-	movieScraper.OnHTML(
+	s.Movies.OnHTML(
 		"div.galleryInnerImageHolder a[href*=imgur], "+
 			"td.wsite-multicol-col div a[href*=imgur]", func(e *colly.HTMLElement) {
 			movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-			log.Debug("inside movie page for", pterm.White(e.Request.Ctx.Get("movie_name")))
+			log.Debug("inside movie page for", scraper.MovieField(e.Request.Ctx.Get("movie_name")))
 
 			// Create link to the real image if it's a link to imgur's
 			// website and not directly to the image.
@@ -134,53 +116,51 @@ func BlusScraper(c *colly.Collector, options *config.Options, stats *scraper.Sta
 				movieImageURL = strings.Replace(movieImageURL, "https://imgur.com", "https://i.imgur.com", 1)
 			}
 
-			log.Debug("Found linked image", pterm.White(movieImageURL))
-			if err := e.Request.Visit(movieImageURL); err != nil {
-				log.Error("Can't get linked image", pterm.White(movieImageURL), ":", pterm.Red(err))
+			log.Debug("Found linked image", scraper.URLField(movieImageURL))
+			if err := s.Visit(e.Request, movieImageURL); err != nil {
+				log.Error("Can't get linked image", scraper.URLField(movieImageURL), ":", err)
 			}
 		})
 
 	// Some old pages of blusscreens have a different layout.
 	// We need a special function to handle this.
 	// eg: https://www.bluscreens.net/skin-i-live-in-the.html
-	movieScraper.OnHTML("div.galleryInnerImageHolder a[href*=postimage]", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("div.galleryInnerImageHolder a[href*=postimage]", func(e *colly.HTMLElement) {
 		postImgURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("found postimage link", pterm.White(postImgURL))
+		log.Debug("found postimage link", scraper.URLField(postImgURL))
 
-		if err := e.Request.Visit(postImgURL); err != nil {
-			log.Error("Can't request postimage link", pterm.White(postImgURL), ":", pterm.Red(err))
+		if err := s.Visit(e.Request, postImgURL); err != nil {
+			log.Error("Can't request postimage link", scraper.URLField(postImgURL), ":", err)
 		}
 	})
 
 	// Another kind of weird layout mixing table and div.
 	// eg: https://www.bluscreens.net/pain--gain.html
-	movieScraper.OnHTML("td.wsite-multicol-col div a[href*=postim]", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("td.wsite-multicol-col div a[href*=postim]", func(e *colly.HTMLElement) {
 		postImgURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("found postimage.org link", pterm.White(postImgURL))
+		log.Debug("found postimage.org link", scraper.URLField(postImgURL))
 
 		// Some links redirect to "postimg.org" and later "pixxxels.cc".
 		// "postimg.org" is not available anymore, we might need to rewrite the URLs.
 		postImgURL = strings.Replace(postImgURL, "postimg.org", "postimage.org", 1)
 
-		if err := e.Request.Visit(postImgURL); err != nil {
-			log.Error("Can't request postimage link", pterm.White(postImgURL), ":", pterm.Red(err))
+		if err := s.Visit(e.Request, postImgURL); err != nil {
+			log.Error("Can't request postimage link", scraper.URLField(postImgURL), ":", err)
 		}
 	})
 
 	// Get full images from postimage.cc host.
 	// We need to get the "download" button link as
 	// the image shown on the page is in a "lower" resolution.
-	movieScraper.OnHTML(
+	s.Movies.OnHTML(
 		"div#content a#download[href*=postimg], "+
 			"div#content a#download[href*=pixxxels]", func(e *colly.HTMLElement) {
 			movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-			log.Debug("found postimg full image", pterm.White(movieImageURL))
+			log.Debug("found postimg full image", scraper.URLField(movieImageURL))
 
-			if err := e.Request.Visit(movieImageURL); err != nil {
-				log.Error("Can't get postimage full image", pterm.White(movieImageURL), ":", pterm.Red(err))
+			if err := s.Visit(e.Request, movieImageURL); err != nil {
+				log.Error("Can't get postimage full image", scraper.URLField(movieImageURL), ":", err)
 			}
 		})
 
-	// Visit and wait for completion
-	scraper.VisitAndWait(c, movieScraper, BlusURL, log)
 }
@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test BlusScreens cinematographer page
 func TestBlusIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(BlusURL)
 
@@ -20,6 +23,8 @@ func TestBlusIndexPage(t *testing.T) {
 // The "Giant" movie
 // Movie review with gallery link to imgur
 func TestBlusNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://www.bluscreens.net/giant.html")
 
@@ -34,6 +39,8 @@ func TestBlusNormalMoviePage(t *testing.T) {
 // Some pages of blusscreens link to postimg galleries.
 // eg: The Skin I Live In
 func TestBlusAlternativeMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://www.bluscreens.net/skin-i-live-in-the.html")
 
@@ -47,6 +54,8 @@ func TestBlusAlternativeMoviePage(t *testing.T) {
 // Another layout using wsite-image elements in multicol tables.
 // eg: Tie Me Up! Tie Me Down!
 func TestBlusAlternative2MoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://www.bluscreens.net/tie-me-up-tie-me-down.html")
 
@@ -59,6 +68,8 @@ func TestBlusAlternative2MoviePage(t *testing.T) {
 
 // Get Link to gallery from movie page
 func TestBlusGalleryLink(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	doc := utils.GetHTMLCode("https://www.bluscreens.net/skin-i-live-in-the.html")
 
 	_, urlExists := doc.Find("a[href*='postimg.cc/gallery/']").Attr("href")
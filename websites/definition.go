@@ -0,0 +1,325 @@
+package websites
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"moviestills/scraper"
+	"moviestills/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/gocolly/colly/v2"
+	"github.com/pterm/pterm"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition describes a website that can be scraped with
+// CSS selectors only. Definitions are written in YAML or JSON
+// files and loaded at startup, no need to recompile the app.
+type Definition struct {
+	Name           string   `yaml:"name" json:"name"`
+	Description    string   `yaml:"description" json:"description"`
+	IndexURL       string   `yaml:"index_url" json:"index_url"`
+	AllowedDomains []string `yaml:"allowed_domains" json:"allowed_domains"`
+	DetectCharset  bool     `yaml:"detect_charset" json:"detect_charset"`
+
+	// Movies finds links to movie pages on the index page
+	Movies Selector `yaml:"movies" json:"movies"`
+
+	// Images finds links to movie stills on movie pages
+	Images Selector `yaml:"images" json:"images"`
+
+	// Path of the file the definition was loaded from
+	Path string `yaml:"-" json:"-"`
+}
+
+// Selector describes how to find links on a page
+type Selector struct {
+	// CSS selector of the elements to look for
+	Selector string `yaml:"selector" json:"selector"`
+
+	// Attribute holding the URL, "href" by default
+	Attribute string `yaml:"attribute" json:"attribute"`
+
+	// Optional CSS selector, relative to the element,
+	// of the child holding the URL.
+	Link string `yaml:"link" json:"link"`
+
+	// Optional CSS selectors, relative to the element, of the
+	// children holding the movie's title and year. The text of
+	// the element is used as the title by default.
+	Title string `yaml:"title" json:"title"`
+	Year  string `yaml:"year" json:"year"`
+
+	// Rules applied in order to rewrite the URL found
+	Rewrite []Rewrite `yaml:"rewrite" json:"rewrite"`
+}
+
+// Rewrite is a rule applied on URLs found by a selector
+type Rewrite struct {
+	// Remove GET parameters from the URL
+	RemoveParams bool `yaml:"remove_params" json:"remove_params"`
+
+	// Replace the first occurrence of a string by another,
+	// eg. "thumbnails" by "images".
+	Replace string `yaml:"replace" json:"replace"`
+	With    string `yaml:"with" json:"with"`
+}
+
+// Website names must be usable on the CLI and as folder names
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// LoadDefinitions loads every definition file (.yaml, .yml and .json)
+// found in a directory, sorted by filename. A missing directory
+// is not an error as definitions are optional.
+func LoadDefinitions(dir string) ([]Definition, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	definitions := make([]Definition, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		def, err := LoadDefinition(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, def)
+	}
+
+	return definitions, nil
+}
+
+// LoadDefinition loads and validates a single definition file
+func LoadDefinition(path string) (Definition, error) {
+	var def Definition
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return def, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(content, &def)
+	} else {
+		err = yaml.Unmarshal(content, &def)
+	}
+	if err != nil {
+		return def, fmt.Errorf("%s: %w", path, err)
+	}
+
+	def.Path = path
+	if err := def.Validate(); err != nil {
+		return def, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return def, nil
+}
+
+// Validate makes sure the definition can be used to scrape a website
+func (d *Definition) Validate() error {
+	if !validName.MatchString(d.Name) {
+		return fmt.Errorf("invalid name %q, use lowercase letters, digits and dashes", d.Name)
+	}
+
+	indexURL, err := url.Parse(d.IndexURL)
+	if err != nil || indexURL.Hostname() == "" {
+		return fmt.Errorf("invalid index_url %q", d.IndexURL)
+	}
+
+	// Allow the domain of the index page by default
+	if len(d.AllowedDomains) == 0 {
+		d.AllowedDomains = []string{indexURL.Hostname()}
+	}
+
+	if d.Movies.Selector == "" {
+		return errors.New("movies.selector is required")
+	}
+	if d.Images.Selector == "" {
+		return errors.New("images.selector is required")
+	}
+
+	for _, s := range []*Selector{&d.Movies, &d.Images} {
+		if s.Attribute == "" {
+			s.Attribute = "href"
+		}
+	}
+
+	// Invalid selectors would only fail once scraping, finding nothing
+	if err := d.Movies.compile("movies"); err != nil {
+		return err
+	}
+	if err := d.Images.compile("images"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// compile makes sure every CSS selector is valid, and tells which
+// field is not. Fields are named after the given selector name.
+func (s Selector) compile(name string) error {
+	fields := []struct {
+		field    string
+		selector string
+	}{
+		{"selector", s.Selector},
+		{"link", s.Link},
+		{"title", s.Title},
+		{"year", s.Year},
+	}
+
+	for _, f := range fields {
+		if f.selector == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(f.selector); err != nil {
+			return fmt.Errorf("invalid %s.%s %q: %w", name, f.field, f.selector, err)
+		}
+	}
+
+	return nil
+}
+
+// Site returns a website ready to be registered
+func (d Definition) Site() scraper.Site {
+	return definedSite{def: d}
+}
+
+// url finds the URL held by an element and rewrites it
+func (s Selector) url(e *colly.HTMLElement) (string, bool) {
+	link := e.DOM
+	if s.Link != "" {
+		link = e.DOM.Find(s.Link).First()
+	}
+
+	rawURL, exists := link.Attr(s.Attribute)
+	if !exists || strings.TrimSpace(rawURL) == "" {
+		return "", false
+	}
+
+	absoluteURL := e.Request.AbsoluteURL(strings.TrimSpace(rawURL))
+	for _, rule := range s.Rewrite {
+		absoluteURL = rule.apply(absoluteURL)
+	}
+
+	return absoluteURL, true
+}
+
+// apply rewrites a URL according to the rule
+func (r Rewrite) apply(rawURL string) string {
+	if r.RemoveParams {
+		rawURL = utils.RemoveURLParams(rawURL)
+	}
+	if r.Replace != "" {
+		rawURL = strings.Replace(rawURL, r.Replace, r.With, 1)
+	}
+	return rawURL
+}
+
+// definedSite is a website scraped according to its definition
+type definedSite struct {
+	def Definition
+}
+
+// Name is the simplified name of the website
+func (d definedSite) Name() string {
+	return d.def.Name
+}
+
+// Description is a short summary of the website
+func (d definedSite) Description() string {
+	return d.def.Description
+}
+
+// IndexURL is the first page visited on the website
+func (d definedSite) IndexURL() string {
+	return d.def.IndexURL
+}
+
+// AllowedDomains are the only domains the scrapers can visit
+func (d definedSite) AllowedDomains() []string {
+	return d.def.AllowedDomains
+}
+
+// DetectCharset is set for websites not declaring their charset properly
+func (d definedSite) DetectCharset() bool {
+	return d.def.DetectCharset
+}
+
+// DiscoverMovies finds links to movie pages on the index page
+func (d definedSite) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
+	movies := d.def.Movies
+
+	s.Index.OnHTML(movies.Selector, func(e *colly.HTMLElement) {
+		movieURL, urlExists := movies.url(e)
+		if !urlExists {
+			log.Debug("Can't find URL to movie page, next")
+			return
+		}
+
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
+
+		title := e.Text
+		if movies.Title != "" {
+			title = e.DOM.Find(movies.Title).First().Text()
+		}
+
+		// Remove weird accents and spaces from the movie's title
+		movieName, err := utils.Normalize(title)
+		if err != nil {
+			log.Error("Can't normalize Movie name for", pterm.White(title), ":", err)
+			return
+		}
+
+		var year string
+		if movies.Year != "" {
+			year, _ = utils.Normalize(e.DOM.Find(movies.Year).First().Text())
+		}
+
+		s.QueueMovie(s.NewMovie(movieName, year, movieURL))
+	})
+}
+
+// ExtractImages finds links to movie stills on movie pages
+func (d definedSite) ExtractImages(s *scraper.Session) {
+	log := s.Log
+	images := d.def.Images
+
+	s.Movies.OnHTML(images.Selector, func(e *colly.HTMLElement) {
+		movieImageURL, urlExists := images.url(e)
+		if !urlExists {
+			log.Debug("Can't find URL to movie image, next")
+			return
+		}
+
+		log.Debug("Found linked image", scraper.URLField(movieImageURL))
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't get linked image", scraper.URLField(movieImageURL), ":", err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package websites
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefinitions(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"gallery.yaml": `
+name: gallery
+description: A small gallery
+index_url: https://gallery.example.com/movies/
+movies:
+  selector: div.movies a
+images:
+  selector: div.thumbs img
+  attribute: src
+  rewrite:
+    - remove_params: true
+    - replace: thumbnails
+      with: images
+`,
+		"other.json": `{
+  "name": "other",
+  "index_url": "https://other.example.com/",
+  "allowed_domains": ["other.example.com", "cdn.example.com"],
+  "movies": {"selector": "tr", "link": "td a", "title": "td a", "year": "td.year"},
+  "images": {"selector": "a.large"}
+}`,
+		"README.md": "not a definition",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	definitions, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("LoadDefinitions() unexpected error: %v", err)
+	}
+	if len(definitions) != 2 {
+		t.Fatalf("Number of definitions is different than 2: %d", len(definitions))
+	}
+
+	gallery := definitions[0]
+	if gallery.Name != "gallery" {
+		t.Errorf("Definitions should be sorted by filename, got %q first", gallery.Name)
+	}
+	if len(gallery.AllowedDomains) != 1 || gallery.AllowedDomains[0] != "gallery.example.com" {
+		t.Errorf("Domain of the index page should be allowed by default, got %v", gallery.AllowedDomains)
+	}
+	if gallery.Movies.Attribute != "href" {
+		t.Errorf("Default attribute should be href, got %q", gallery.Movies.Attribute)
+	}
+
+	imageURL := "https://gallery.example.com/thumbnails/movie/001.jpg?w=150"
+	for _, rule := range gallery.Images.Rewrite {
+		imageURL = rule.apply(imageURL)
+	}
+	if imageURL != "https://gallery.example.com/images/movie/001.jpg" {
+		t.Errorf("Rewritten image URL is %q", imageURL)
+	}
+
+	other := definitions[1]
+	if len(other.AllowedDomains) != 2 || other.Movies.Link != "td a" {
+		t.Errorf("JSON definition was not loaded properly: %+v", other)
+	}
+}
+
+func TestLoadDefinitionsMissingDirectory(t *testing.T) {
+	definitions, err := LoadDefinitions(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || definitions != nil {
+		t.Errorf("LoadDefinitions() on a missing directory == %v, %v, expected nil, nil", definitions, err)
+	}
+}
+
+func TestInvalidDefinitions(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid name", "name: My Site\nindex_url: https://a.com\nmovies: {selector: a}\nimages: {selector: a}", "invalid name"},
+		{"invalid index", "name: site\nindex_url: nope\nmovies: {selector: a}\nimages: {selector: a}", "invalid index_url"},
+		{"no movies", "name: site\nindex_url: https://a.com\nimages: {selector: a}", "movies.selector is required"},
+		{"no images", "name: site\nindex_url: https://a.com\nmovies: {selector: a}", "images.selector is required"},
+		{"invalid selector", "name: site\nindex_url: https://a.com\nmovies: {selector: 'a[href'}\nimages: {selector: a}", "invalid movies.selector"},
+		{"invalid link", "name: site\nindex_url: https://a.com\nmovies: {selector: a}\nimages: {selector: div, link: 'a:unknown'}", "invalid images.link"},
+		{"invalid title", "name: site\nindex_url: https://a.com\nmovies: {selector: tr, title: 'td >'}\nimages: {selector: a}", "invalid movies.title"},
+		{"invalid year", "name: site\nindex_url: https://a.com\nmovies: {selector: tr, year: 'td..year'}\nimages: {selector: a}", "invalid movies.year"},
+		{"bad yaml", "name: [site", "yaml"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "site.yml")
+			if err := os.WriteFile(path, []byte(c.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadDefinition(path); err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("LoadDefinition() error = %v, expected %q", err, c.wantErr)
+			}
+		})
+	}
+}
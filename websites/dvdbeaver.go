@@ -1,10 +1,8 @@
 package websites
 
 import (
-	"moviestills/config"
 	"moviestills/scraper"
 	"moviestills/utils"
-	"strconv"
 	"strings"
 
 	"github.com/gocolly/colly/v2"
@@ -15,47 +13,59 @@ import (
 // sorted by alphabet (#, a, z). It's a good starting point for our task.
 const BeaverURL string = "http://www.dvdbeaver.com/film/reviews.htm"
 
-// DVDBeaverScraper is the main function that handles all the scraping
-// logic for this website.
-func DVDBeaverScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("dvdbeaver")
-
-	cfg := scraper.SiteConfig{
-		Name:     "dvdbeaver",
-		IndexURL: BeaverURL,
-		AllowedDomains: []string{
-			"www.dvdbeaver.com",
-			"DVDBeaver.com",
-			"www.DVDBeaver.com",
-		},
+func init() {
+	scraper.Register(DVDBeaver{})
+}
+
+// DVDBeaver handles all the scraping logic for this website
+type DVDBeaver struct{}
+
+// Name is the simplified name of the website
+func (DVDBeaver) Name() string {
+	return "dvdbeaver"
+}
+
+// Description is a short summary of the website
+func (DVDBeaver) Description() string {
+	return "A massive list of DVD reviews with a lot of average quality snapshots"
+}
+
+// IndexURL is the first page visited on the website
+func (DVDBeaver) IndexURL() string {
+	return BeaverURL
+}
+
+// AllowedDomains are the only domains the scrapers can visit
+func (DVDBeaver) AllowedDomains() []string {
+	return []string{
+		"www.dvdbeaver.com",
+		"DVDBeaver.com",
+		"www.DVDBeaver.com",
 	}
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// DiscoverMovies goes through the movie list pages sorted
+// by alphabet to find links to DVD reviews.
+func (DVDBeaver) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Movies list might be updated often with new movies
 	// so we authorize the scraper to revisit these pages.
-	movieListScraper := c.Clone()
+	movieListScraper := s.Clone()
 	movieListScraper.AllowURLRevisit = true
 	movieListScraper.DetectCharset = true
 
 	movieListScraper.OnRequest(func(r *colly.Request) {
-		log.Debug("visiting movie list page", pterm.White(r.URL.String()))
+		log.Debug("visiting movie list page", scraper.URLField(r.URL.String()))
 	})
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
-
-	// Setup the common image response handler
-	scraper.SetupImageResponseHandler(movieScraper, options, stats, log)
-
 	// Find links to movies list by alphabet
-	c.OnHTML("a[href*='listing' i]", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("a[href*='listing' i]", func(e *colly.HTMLElement) {
 		movieListURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found movie list page link", pterm.White(movieListURL))
+		log.Debug("Found movie list page link", scraper.URLField(movieListURL))
 
 		if err := movieListScraper.Visit(movieListURL); err != nil {
-			log.Error("Can't visit movie list page", pterm.White(movieListURL), pterm.Red(err))
+			log.Error("Can't visit movie list page", scraper.URLField(movieListURL), err)
 		}
 	})
 
@@ -88,26 +98,22 @@ func DVDBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 		// Take care of weird characters in the movie's title
 		movieName, err := utils.Normalize(reviewLink.Text())
 		if err != nil {
-			log.Error("Can't normalize Movie name for", pterm.White(reviewLink.Text()), pterm.Red(err))
+			log.Error("Can't normalize Movie name for", pterm.White(reviewLink.Text()), err)
 			return
 		}
 
-		log.Debug("Found movie link for", pterm.White(movieName))
+		log.Debug("Found movie link for", scraper.MovieField(movieName))
 
 		// Make sure we handle relative URLs if any
 		movieURL = e.Request.AbsoluteURL(movieURL)
 
-		movie := scraper.NewMovie(movieName, "", movieURL, "dvdbeaver", options)
-		log.Info("Found movie page for:", pterm.White(movieName))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
-
-		if err = movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't get movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(movieName, "", movieURL))
 	})
+}
+
+// ExtractImages finds movie stills on DVD reviews
+func (DVDBeaver) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// Look for links on images that redirects to a "largest" version.
 	// It is unlikely to find some of these on some DVD reviews, but sometimes
@@ -116,19 +122,19 @@ func DVDBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 	//
 	// We try to avoid images with "subs" in the filename as they are
 	// most likely images with subtitles on top. We don't want that.
-	movieScraper.OnHTML("a[href*='large' i]:not([href*='subs' i])", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("a[href*='large' i]:not([href*='subs' i])", func(e *colly.HTMLElement) {
 		movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found large image", pterm.White(movieImageURL))
+		log.Debug("Found large image", scraper.URLField(movieImageURL))
 
-		if err := e.Request.Visit(movieImageURL); err != nil {
-			log.Error("Can't get large image", pterm.White(movieImageURL), ":", pterm.Red(err))
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't get large image", scraper.URLField(movieImageURL), ":", err)
 		}
 	})
 
 	// On DVD reviews, there are almost never clickable large versions.
 	// Therefore we download the images as shown on the webpage and
 	// be sure we avoid some weird ones (subtitles, DVD covers etc).
-	movieScraper.OnHTML(
+	s.Movies.OnHTML(
 		"img:not([src*='banner' i])"+
 			":not([src*='rating' i])"+
 			":not([src*='package' i])"+
@@ -144,25 +150,11 @@ func DVDBeaverScraper(c *colly.Collector, options *config.Options, stats *scrape
 			":not([src*='menu' i])", func(e *colly.HTMLElement) {
 			movieImageURL := e.Request.AbsoluteURL(e.Attr("src"))
 
-			// Filter low resolutions images to avoid false positives.
-			// if the images are too small, we won't be able to use them
-			// anyway so let's skip them.
-			movieImageWidth, _ := strconv.Atoi(e.Attr("width"))
-			movieImageHeight, _ := strconv.Atoi(e.Attr("height"))
-
-			if movieImageHeight >= 265 && movieImageWidth >= 500 {
-				if err := e.Request.Visit(movieImageURL); err != nil {
-					log.Error("Can't request inline image", pterm.White(movieImageURL), pterm.Red(err))
-				}
+			// Low resolution images are discarded once downloaded, based on
+			// their actual dimensions rather than the HTML attributes.
+			if err := s.Visit(e.Request, movieImageURL); err != nil {
+				log.Error("Can't request inline image", scraper.URLField(movieImageURL), err)
 			}
 		})
 
-	// Visit and wait for completion
-	if err := c.Visit(BeaverURL); err != nil {
-		log.Error("Can't visit index page:", pterm.Red(err))
-	}
-
-	c.Wait()
-	movieListScraper.Wait()
-	movieScraper.Wait()
 }
@@ -1,6 +1,7 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"strconv"
 	"testing"
@@ -10,6 +11,8 @@ import (
 
 // Test number of movie lists index pages
 func TestMainDVDBeaverPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	doc := utils.GetHTMLCode(BeaverURL)
 
 	numPages := doc.Find("a[href*='listing' i]").Length()
@@ -20,6 +23,8 @@ func TestMainDVDBeaverPage(t *testing.T) {
 
 // Test DVDBeaver "number/#" index page
 func TestDVDBeaverIndexNumberPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("http://www.dvdbeaver.com/listing/num.htm")
 
@@ -33,6 +38,8 @@ func TestDVDBeaverIndexNumberPage(t *testing.T) {
 // The "3 Godfathers" movie
 // Normal movie review with inline images
 func TestDVDBeaverMoviePageWithOnlyInlineImages(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("http://www.dvdbeaver.com/film/DVDReviews19/3_godfathers_dvd_review.htm")
 
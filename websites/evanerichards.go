@@ -1,7 +1,6 @@
 package websites
 
 import (
-	"moviestills/config"
 	"moviestills/scraper"
 	"moviestills/utils"
 
@@ -13,33 +12,44 @@ import (
 // TV movies, Series...
 const EvanERichardsURL string = "https://www.evanerichards.com/index"
 
-// EvanERichardsScraper is the main function that handles all the scraping logic
-// for this website.
-func EvanERichardsScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("evanerichards")
-
-	cfg := scraper.SiteConfig{
-		Name:     "evanerichards",
-		IndexURL: EvanERichardsURL,
-		AllowedDomains: []string{
-			"www.evanerichards.com",
-			"evanerichards.com",
-		},
-	}
+func init() {
+	scraper.Register(EvanERichards{})
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// EvanERichards handles all the scraping logic for this website
+type EvanERichards struct{}
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
+// Name is the simplified name of the website
+func (EvanERichards) Name() string {
+	return "evanerichards"
+}
+
+// Description is a short summary of the website
+func (EvanERichards) Description() string {
+	return "A short list of movies with a lot of snapshots for each"
+}
 
-	// Setup the common image response handler
-	scraper.SetupImageResponseHandler(movieScraper, options, stats, log)
+// IndexURL is the first page visited on the website
+func (EvanERichards) IndexURL() string {
+	return EvanERichardsURL
+}
+
+// AllowedDomains are the only domains the scrapers can visit
+func (EvanERichards) AllowedDomains() []string {
+	return []string{
+		"www.evanerichards.com",
+		"evanerichards.com",
+	}
+}
+
+// DiscoverMovies finds links to movie pages on the index table
+func (EvanERichards) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Find links to movies pages and isolate the movie's title and year.
 	// We iterate through each table row to check if it's indeed a movie
 	// and not something else –– this website provides TV Series too.
-	c.OnHTML("tbody tr.pp-table-row", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("tbody tr.pp-table-row", func(e *colly.HTMLElement) {
 		// Fetch various data in columns for each table entry
 		title, _ := utils.Normalize(e.DOM.Find("td.pp-table-cell-Title a").Text())
 		category, _ := utils.Normalize(e.DOM.Find("td.pp-table-cell-Category").Text())
@@ -57,30 +67,25 @@ func EvanERichardsScraper(c *colly.Collector, options *config.Options, stats *sc
 			return
 		}
 
-		log.Debug("Found movie page link", pterm.White(movieURL))
-
-		movie := scraper.NewMovie(title, year, movieURL, "evanerichards", options)
-		log.Info("Found movie page for:", pterm.White(title))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
+		// Make sure we handle relative URLs if any
+		movieURL = e.Request.AbsoluteURL(movieURL)
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
 
-		if err := movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't get movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(title, year, movieURL))
 	})
+}
+
+// ExtractImages finds links to large images on movie pages
+func (EvanERichards) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// Look for links on thumbnails that redirect to a "largest" version
-	movieScraper.OnHTML("div.elementor-widget-container div.ngg-gallery-thumbnail a[class*=shutter]", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("div.elementor-widget-container div.ngg-gallery-thumbnail a[class*=shutter]", func(e *colly.HTMLElement) {
 		movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found linked image", pterm.White(movieImageURL))
+		log.Debug("Found linked image", scraper.URLField(movieImageURL))
 
-		if err := e.Request.Visit(movieImageURL); err != nil {
-			log.Error("Can't get large image", pterm.White(movieImageURL), ":", pterm.Red(err))
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't get large image", scraper.URLField(movieImageURL), ":", err)
 		}
 	})
-
-	// Visit and wait for completion
-	scraper.VisitAndWait(c, movieScraper, EvanERichardsURL, log)
 }
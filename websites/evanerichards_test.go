@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test EvanERichards index page
 func TestEvanIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(EvanERichardsURL)
 
@@ -20,6 +23,8 @@ func TestEvanIndexPage(t *testing.T) {
 
 // 12 Monkeys
 func TestEvanNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://www.evanerichards.com/2009/28")
 
@@ -1,7 +1,6 @@
 package websites
 
 import (
-	"moviestills/config"
 	"moviestills/scraper"
 	"moviestills/utils"
 
@@ -13,66 +12,70 @@ import (
 // sorted by alphabet.
 const FilmGrabURL string = "https://film-grab.com/movies-a-z/"
 
-// FilmGrabScraper is the main function that handles all the scraping
-// logic for this website.
-func FilmGrabScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("film-grab")
-
-	cfg := scraper.SiteConfig{
-		Name:     "film-grab",
-		IndexURL: FilmGrabURL,
-		AllowedDomains: []string{
-			"film-grab.com",
-		},
-	}
+func init() {
+	scraper.Register(FilmGrab{})
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// FilmGrab handles all the scraping logic for this website
+type FilmGrab struct{}
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
+// Name is the simplified name of the website
+func (FilmGrab) Name() string {
+	return "film-grab"
+}
+
+// Description is a short summary of the website
+func (FilmGrab) Description() string {
+	return "Cherry-picked snapshots showing nice cinematography"
+}
 
-	// Setup the common image response handler
-	scraper.SetupImageResponseHandler(movieScraper, options, stats, log)
+// IndexURL is the first page visited on the website
+func (FilmGrab) IndexURL() string {
+	return FilmGrabURL
+}
+
+// AllowedDomains are the only domains the scrapers can visit
+func (FilmGrab) AllowedDomains() []string {
+	return []string{
+		"film-grab.com",
+	}
+}
+
+// DiscoverMovies finds links to movie pages on the index page
+func (FilmGrab) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Find links to movies pages and isolate the movie's title.
-	c.OnHTML("div#primary a.title[href*=film]", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("div#primary a.title[href*=film]", func(e *colly.HTMLElement) {
 		movieURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found movie page link", pterm.White(movieURL))
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
 
 		// Remove weird accents and spaces from the movie's title
 		movieName, err := utils.Normalize(e.Text)
 		if err != nil {
-			log.Error("Can't normalize Movie name for", pterm.White(e.Text), pterm.Red(err))
+			log.Error("Can't normalize Movie name for", pterm.White(e.Text), err)
 			return
 		}
 
-		movie := scraper.NewMovie(movieName, "", movieURL, "film-grab", options)
-		log.Info("Found movie page for:", pterm.White(movieName))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
-
-		if err := movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't get movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(movieName, "", movieURL))
 	})
+}
+
+// ExtractImages finds links to large images on movie pages
+func (FilmGrab) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// Look for links on thumbnails that redirect to a "largest" version
-	movieScraper.OnHTML("div.bwg_container div.bwg-item a.bwg-a[href*=film]", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("div.bwg_container div.bwg-item a.bwg-a[href*=film]", func(e *colly.HTMLElement) {
 		movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
 
 		// Remove weird GET parameters to have a proper filename
 		movieImageURL = utils.RemoveURLParams(movieImageURL)
 
-		log.Debug("Found link to large image", pterm.White(movieImageURL))
+		log.Debug("Found link to large image", scraper.URLField(movieImageURL))
 
-		if err := e.Request.Visit(movieImageURL); err != nil {
-			log.Error("Can't request linked image:", pterm.Red(err))
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't request linked image:", err)
 		}
 	})
-
-	// Visit and wait for completion
-	scraper.VisitAndWait(c, movieScraper, FilmGrabURL, log)
 }
@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test Film-grab index page
 func TestFilmGrabIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(FilmGrabURL)
 
@@ -19,6 +22,8 @@ func TestFilmGrabIndexPage(t *testing.T) {
 
 // 12 Angry Men
 func TestFilmGrabNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://film-grab.com/2013/11/19/12-angry-men/")
 
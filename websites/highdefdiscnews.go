@@ -1,7 +1,6 @@
 package websites
 
 import (
-	"moviestills/config"
 	"moviestills/scraper"
 	"moviestills/utils"
 	"strings"
@@ -14,35 +13,46 @@ import (
 // with Blu-rays images.
 const HighDefDiscNewsURL string = "https://highdefdiscnews.com/blu-ray-screenshots/"
 
-// HighDefDiscNewsScraper is the main function that handles all the scraping logic
-// for this website.
-func HighDefDiscNewsScraper(c *colly.Collector, options *config.Options, stats *scraper.Stats) {
-	log := scraper.NewLogger("highdefdiscnews")
-
-	cfg := scraper.SiteConfig{
-		Name:     "highdefdiscnews",
-		IndexURL: HighDefDiscNewsURL,
-		AllowedDomains: []string{
-			"highdefdiscnews.com",
-		},
-	}
+func init() {
+	scraper.Register(HighDefDiscNews{})
+}
 
-	// Setup the index scraper with common settings
-	scraper.SetupIndexScraper(c, cfg, log)
+// HighDefDiscNews handles all the scraping logic for this website
+type HighDefDiscNews struct{}
 
-	// Create and setup the movie scraper
-	movieScraper := scraper.SetupMovieScraper(c, log)
+// Name is the simplified name of the website
+func (HighDefDiscNews) Name() string {
+	return "highdefdiscnews"
+}
+
+// Description is a short summary of the website
+func (HighDefDiscNews) Description() string {
+	return "High-quality lossless snapshots of Blu-rays in native resolution"
+}
 
-	// Setup the common image response handler
-	scraper.SetupImageResponseHandler(movieScraper, options, stats, log)
+// IndexURL is the first page visited on the website
+func (HighDefDiscNews) IndexURL() string {
+	return HighDefDiscNewsURL
+}
+
+// AllowedDomains are the only domains the scrapers can visit
+func (HighDefDiscNews) AllowedDomains() []string {
+	return []string{
+		"highdefdiscnews.com",
+	}
+}
+
+// DiscoverMovies finds links to movie pages on the index page
+func (HighDefDiscNews) DiscoverMovies(s *scraper.Session) {
+	log := s.Log
 
 	// Find links to movies reviews and isolate the movie's title.
 	// Links contain some useless text such as "- Blu-ray Screenshots"
 	// or "[Remastered]".
 	// We remove these texts to isolate the movie's title.
-	c.OnHTML("div#mcTagMap ul.links a[href*=high]", func(e *colly.HTMLElement) {
+	s.Index.OnHTML("div#mcTagMap ul.links a[href*=high]", func(e *colly.HTMLElement) {
 		movieURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found movie page link", pterm.White(movieURL))
+		log.Debug("Found movie page link", scraper.URLField(movieURL))
 
 		// Isolate the movie's title from the text
 		tmpMovieName := isolateMovieTitle(e.Text)
@@ -50,33 +60,26 @@ func HighDefDiscNewsScraper(c *colly.Collector, options *config.Options, stats *
 		// Remove weird accents and spaces from the movie's title
 		movieName, err := utils.Normalize(tmpMovieName)
 		if err != nil {
-			log.Error("Can't normalize Movie name for", pterm.White(e.Text), pterm.Red(err))
+			log.Error("Can't normalize Movie name for", pterm.White(e.Text), err)
 			return
 		}
 
-		movie := scraper.NewMovie(movieName, "", movieURL, "highdefdiscnews", options)
-		log.Info("Found movie page for:", pterm.White(movieName))
-
-		if stats != nil {
-			stats.IncrMovies()
-		}
-
-		if err := movieScraper.Request("GET", movieURL, nil, movie.ToContext(), nil); err != nil {
-			log.Error("Can't visit movie page", pterm.White(movieURL), ":", pterm.Red(err))
-		}
+		s.QueueMovie(s.NewMovie(movieName, "", movieURL))
 	})
+}
+
+// ExtractImages finds links to large images on movie pages
+func (HighDefDiscNews) ExtractImages(s *scraper.Session) {
+	log := s.Log
 
 	// Look for links on thumbnails that redirects to a "largest" version.
-	movieScraper.OnHTML("div.gallery dl.gallery-item a[href*=high]", func(e *colly.HTMLElement) {
+	s.Movies.OnHTML("div.gallery dl.gallery-item a[href*=high]", func(e *colly.HTMLElement) {
 		movieImageURL := e.Request.AbsoluteURL(e.Attr("href"))
-		log.Debug("Found linked image", pterm.White(movieImageURL))
-		if err := e.Request.Visit(movieImageURL); err != nil {
-			log.Error("Can't get linked image", pterm.White(movieImageURL), ":", pterm.Red(err))
+		log.Debug("Found linked image", scraper.URLField(movieImageURL))
+		if err := s.Visit(e.Request, movieImageURL); err != nil {
+			log.Error("Can't get linked image", scraper.URLField(movieImageURL), ":", err)
 		}
 	})
-
-	// Visit and wait for completion
-	scraper.VisitAndWait(c, movieScraper, HighDefDiscNewsURL, log)
 }
 
 // isolateMovieTitle isolates the movie's title by getting rid of various words on the right.
@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test HighDefDiscNews index page
 func TestHighDefDiscNewsIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(HighDefDiscNewsURL)
 
@@ -19,6 +22,8 @@ func TestHighDefDiscNewsIndexPage(t *testing.T) {
 
 // Aliens
 func TestHighDefDiscNewsNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://highdefdiscnews.com/2018/06/29/aliens-blu-ray-screenshots/")
 
@@ -1,6 +1,7 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"strconv"
 	"testing"
@@ -8,6 +9,8 @@ import (
 
 // Test movie-screencaps index page
 func TestMovieScreencapsIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(ScreenCapsURL)
 
@@ -20,6 +23,8 @@ func TestMovieScreencapsIndexPage(t *testing.T) {
 
 // Eagle Eye
 func TestMovieScreencapsNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://movie-screencaps.com/eagle-eye-2008/")
 
@@ -0,0 +1,656 @@
+package websites
+
+import (
+	"context"
+	"fmt"
+	"moviestills/pagecache"
+	"moviestills/scraper"
+	"moviestills/scraper/scrapertest"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Run every scraper against its recorded pages in testdata,
+// served by a local mock server, and check what was saved.
+func TestScrapersOffline(t *testing.T) {
+	cases := []struct {
+		site       scraper.Site
+		movies     int64
+		downloaded int64
+		duplicated int64
+		failed     int64
+		rejected   map[string]int64
+		files      map[string][]string
+	}{
+		{
+			// Large images are preferred, but the inline image is
+			// saved when the large one is not found anymore. A smaller
+			// version of an image is moved aside as a near-duplicate,
+			// while thumbnails are discarded.
+			site: BluBeaver{}, movies: 2, downloaded: 6, duplicated: 1, failed: 1,
+			rejected: map[string]int64{scraper.RejectedTooSmall: 1},
+			files: map[string][]string{
+				"10": {
+					"film3_blu_ray_reviews53_10_3.jpg",
+					"film3_blu_ray_reviews53_large_10_1.jpg",
+					"film3_blu_ray_reviews53_large_10_2.jpg",
+				},
+				"10,000 BC": {
+					"film2_DVDReviews38_10000_bc_1.jpg",
+					"film2_DVDReviews38_10000_bc_2.jpg",
+				},
+				"10,000 BC/duplicates": {
+					"film2_DVDReviews38_10000_bc_2_small.jpg",
+				},
+			},
+		},
+		{
+			// The small "removed" image from imgur is discarded
+			site: Blus{}, movies: 2, downloaded: 4, failed: 1,
+			rejected: map[string]int64{scraper.RejectedInvalid: 1},
+			files: map[string][]string{
+				"Pain & Gain":        {"i_imgur_com_PainGain2.png", "imgur_com_PainGain1.png"},
+				"The Skin I Live In": {"i_imgur_com_Skin1.png", "i_postimg_cc_skin2.png"},
+			},
+		},
+		{
+			// Blu-ray reviews are left to BluBeaver, truncated images are discarded
+			site: DVDBeaver{}, movies: 2, downloaded: 4, failed: 1,
+			rejected: map[string]int64{scraper.RejectedCorrupt: 1},
+			files: map[string][]string{
+				"Alien":  {"film_DVDReviews20_alien_1.jpg", "film_DVDReviews20_alien_2.jpg"},
+				"Brazil": {"film_DVDReviews21_brazil_1.jpg", "film_DVDReviews21_large_brazil_2.jpg"},
+			},
+		},
+		{
+			// TV Series are ignored
+			site: EvanERichards{}, movies: 2, downloaded: 5,
+			files: map[string][]string{
+				"Akira": {
+					"wp_content_gallery_akira_akira_001.jpg",
+					"wp_content_gallery_akira_akira_002.jpg",
+				},
+				"Alien": {
+					"wp_content_gallery_alien_1979_alien_1979_001.jpg",
+					"wp_content_gallery_alien_1979_alien_1979_002.jpg",
+					"wp_content_gallery_alien_1979_alien_1979_003.jpg",
+				},
+			},
+		},
+		{
+			site: FilmGrab{}, movies: 2, downloaded: 5,
+			files: map[string][]string{
+				"12 Angry Men": {
+					"wp_content_uploads_photo_gallery_film_12angrymen001.jpg",
+					"wp_content_uploads_photo_gallery_film_12angrymen002.jpg",
+					"wp_content_uploads_photo_gallery_film_12angrymen003.jpg",
+				},
+				"Les Miserables": {
+					"wp_content_uploads_photo_gallery_film_lesmiserables001.jpg",
+					"wp_content_uploads_photo_gallery_film_lesmiserables002.jpg",
+				},
+			},
+		},
+		{
+			site: HighDefDiscNews{}, movies: 2, downloaded: 3,
+			files: map[string][]string{
+				"Alien":  {"highdef_wp_content_uploads_alien_1.png", "highdef_wp_content_uploads_alien_2.png"},
+				"Brazil": {"highdef_wp_content_uploads_brazil_1.png"},
+			},
+		},
+		{
+			// One snapshot out of 30 on every paginated page
+			site: ScreenCaps{}, movies: 1, downloaded: 4,
+			files: map[string][]string{
+				"Airplane!": {
+					"i1_wp_com_caps_pictures_198_0_airplane_full_airplane_movie_screencaps_com_120.jpg",
+					"i1_wp_com_caps_pictures_198_0_airplane_full_airplane_movie_screencaps_com_30.jpg",
+					"i1_wp_com_caps_pictures_198_0_airplane_full_airplane_movie_screencaps_com_60.jpg",
+					"i1_wp_com_caps_pictures_198_0_airplane_full_airplane_movie_screencaps_com_90.jpg",
+				},
+			},
+		},
+		{
+			site: ScreenMusings{}, movies: 2, downloaded: 5,
+			files: map[string][]string{
+				"Alien": {
+					"movie_dvd_alien_images_alien_1.jpg",
+					"movie_dvd_alien_images_alien_2.jpg",
+					"movie_dvd_alien_images_alien_3.jpg",
+				},
+				"Brazil": {
+					"movie_blu_ray_brazil_images_brazil_1.jpg",
+					"movie_blu_ray_brazil_images_brazil_2.jpg",
+				},
+			},
+		},
+		{
+			site: StillsFrmFilms{}, movies: 2, downloaded: 4,
+			files: map[string][]string{
+				"Alien":  {"2014_02_alien_001.jpg", "2014_02_alien_002.jpg"},
+				"Brazil": {"2014_02_brazil_001.jpg", "2014_02_brazil_002.jpg"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		for _, async := range []bool{false, true} {
+			name := c.site.Name()
+			if async {
+				name += "/async"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				server := scrapertest.NewServer(t, filepath.Join("testdata", c.site.Name()))
+
+				options := scrapertest.Options(t)
+				options.Async = async
+				result := scrapertest.Run(t, server, c.site, options)
+
+				stats := result.Stats
+				if stats.MoviesFound != c.movies {
+					t.Errorf("Movies found: %d, expected %d", stats.MoviesFound, c.movies)
+				}
+				if stats.ImagesDownloaded != c.downloaded {
+					t.Errorf("Images downloaded: %d, expected %d", stats.ImagesDownloaded, c.downloaded)
+				}
+				if stats.ImagesDuplicated != c.duplicated {
+					t.Errorf("Images duplicated: %d, expected %d", stats.ImagesDuplicated, c.duplicated)
+				}
+				if stats.ImagesFailed != c.failed {
+					t.Errorf("Images failed: %d, expected %d", stats.ImagesFailed, c.failed)
+				}
+
+				if rejected := rejectedImages(stats); !reflect.DeepEqual(rejected, c.rejected) {
+					t.Errorf("Images rejected: %v, expected %v", rejected, c.rejected)
+				}
+
+				files := result.Files(t)
+				if !reflect.DeepEqual(files, c.files) {
+					t.Errorf("Saved files:\n%v\nexpected:\n%v", files, c.files)
+				}
+
+				for movie, names := range files {
+					if filepath.Base(movie) == scraper.DuplicatesFolder {
+						checkDuplicates(t, filepath.Join(result.Dir, filepath.Dir(movie)), names)
+						continue
+					}
+					checkManifest(t, filepath.Join(result.Dir, movie), c.site.Name(), names)
+				}
+
+				// Only pages are cached, images are saved once
+				if removed, _, _ := pagecache.StripImages(options.CacheDir); removed != 0 {
+					t.Errorf("Images cached: %d", removed)
+				}
+			})
+		}
+	}
+}
+
+// rejectedImages counts the images rejected by reason, if any
+func rejectedImages(stats *scraper.Stats) map[string]int64 {
+	var rejected map[string]int64
+	for reason, count := range map[string]int64{
+		scraper.RejectedCorrupt:     stats.ImagesCorrupt,
+		scraper.RejectedTooSmall:    stats.ImagesTooSmall,
+		scraper.RejectedPlaceholder: stats.ImagesPlaceholder,
+		scraper.RejectedInvalid:     stats.ImagesInvalid,
+	} {
+		if count == 0 {
+			continue
+		}
+		if rejected == nil {
+			rejected = make(map[string]int64)
+		}
+		rejected[reason] = count
+	}
+	return rejected
+}
+
+// checkManifest makes sure the manifest of a movie folder
+// describes every image saved in it.
+func checkManifest(t *testing.T, moviePath, site string, names []string) {
+	t.Helper()
+
+	manifest, err := scraper.ReadManifest(moviePath)
+	if err != nil {
+		t.Fatalf("Can't read manifest of %s: %v", moviePath, err)
+	}
+
+	if manifest.Site != site || manifest.Title != filepath.Base(moviePath) || manifest.URL == "" {
+		t.Errorf("Manifest of %s doesn't describe the movie: %+v", moviePath, manifest)
+	}
+
+	if len(manifest.Images) != len(names) {
+		t.Fatalf("Manifest of %s lists %d images, expected %d", moviePath, len(manifest.Images), len(names))
+	}
+
+	for _, name := range names {
+		image, exists := manifest.Image(name)
+		if !exists {
+			t.Errorf("Manifest of %s doesn't list %s", moviePath, name)
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(moviePath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.Size != int64(len(content)) || image.SHA256 != scraper.SHA256(content) {
+			t.Errorf("Manifest of %s has wrong size or hash for %s", moviePath, name)
+		}
+		if image.URL == "" || image.FetchedAt.IsZero() {
+			t.Errorf("Manifest of %s doesn't tell where %s came from", moviePath, name)
+		}
+	}
+}
+
+// Resuming a scraping skips movies fully scraped and only
+// makes the requests left to do for the others.
+func TestResumeOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "blubeaver"))
+	options := scrapertest.Options(t)
+	first := scrapertest.Run(t, server, BluBeaver{}, options)
+
+	options.Resume = true
+	options.DataDir = t.TempDir()
+	result := scrapertest.Run(t, server, BluBeaver{}, options)
+	if result.Stats.MoviesFound != 0 || result.Stats.ImagesDownloaded != 0 {
+		t.Errorf("Movies already scraped were scraped again: %+v", result.Stats)
+	}
+
+	// Interrupt the scraping of a movie before one of its images
+	statePath := scraper.CrawlStatePath(options.CacheDir, BluBeaver{}.Name())
+	state, err := scraper.LoadCrawlState(statePath, BluBeaver{}.Name())
+	if err != nil {
+		t.Fatalf("Can't load crawl state: %v", err)
+	}
+
+	manifest, err := scraper.ReadManifest(filepath.Join(first.Dir, "10"))
+	if err != nil || len(manifest.Images) == 0 {
+		t.Fatalf("Can't read manifest of the first run: %v", err)
+	}
+	image := manifest.Images[0]
+
+	movie := state.Movies[manifest.URL]
+	if movie == nil || !movie.Done {
+		t.Fatalf("Movie %s is not done in crawl state: %+v", manifest.URL, movie)
+	}
+	movie.Done = false
+	movie.Pending = []scraper.PendingRequest{{
+		URL: image.URL,
+		Context: map[string]string{
+			"movie_name": "10",
+			"movie_url":  manifest.URL,
+			"movie_path": filepath.Join(options.DataDir, BluBeaver{}.Name(), "10"),
+			"movie_site": BluBeaver{}.Name(),
+		},
+	}}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	result = scrapertest.Run(t, server, BluBeaver{}, options)
+	if result.Stats.MoviesFound != 0 || result.Stats.ImagesDownloaded != 1 {
+		t.Errorf("Resumed movie was not picked up where it stopped: %+v", result.Stats)
+	}
+	expected := map[string][]string{"10": {image.FileName}}
+	if files := result.Files(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Saved files:\n%v\nexpected:\n%v", files, expected)
+	}
+
+	state, _ = scraper.LoadCrawlState(statePath, BluBeaver{}.Name())
+	if !state.IsDone(manifest.URL) {
+		t.Errorf("Resumed movie is not done")
+	}
+}
+
+// Stopping a scraping discards the requests in progress, without
+// leaving anything half-written, and keeps them for later.
+func TestStopOffline(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			server := scrapertest.NewServer(t, filepath.Join("testdata", "blubeaver"))
+			complete := scrapertest.Run(t, server, BluBeaver{}, nil).Files(t)
+
+			// Stop as soon as the first image is requested
+			ctx, cancel := context.WithCancel(t.Context())
+			var once sync.Once
+			server.OnRequest = func(r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, ".jpg") {
+					once.Do(cancel)
+				}
+			}
+
+			options := scrapertest.Options(t)
+			options.Async = async
+			result := scrapertest.RunContext(ctx, t, server, BluBeaver{}, options)
+			if result.Stats.ImagesDownloaded != 0 {
+				t.Errorf("Images downloaded after being stopped: %d", result.Stats.ImagesDownloaded)
+			}
+
+			hidden, _ := filepath.Glob(filepath.Join(result.Dir, "*", ".*"))
+			if len(hidden) != 0 {
+				t.Errorf("Files left half-written: %v", hidden)
+			}
+
+			// Everything is scraped once resumed
+			server.OnRequest = nil
+			options.Resume = true
+			result = scrapertest.Run(t, server, BluBeaver{}, options)
+			if files := result.Files(t); !reflect.DeepEqual(files, complete) {
+				t.Errorf("Saved files once resumed:\n%v\nexpected:\n%v", files, complete)
+			}
+		})
+	}
+}
+
+// Running a scraping again doesn't download images already saved,
+// unless they don't match what was downloaded.
+func TestSkipSavedOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "evanerichards"))
+	options := scrapertest.Options(t)
+	first := scrapertest.Run(t, server, EvanERichards{}, options)
+	files := first.Files(t)
+
+	var truncated string
+	for movie, names := range files {
+		truncated = filepath.Join(first.Dir, movie, names[0])
+		break
+	}
+	if err := os.Truncate(truncated, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var requested []string
+	server.OnRequest = func(r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".jpg") {
+			mu.Lock()
+			requested = append(requested, r.URL.Path)
+			mu.Unlock()
+		}
+	}
+
+	result := scrapertest.Run(t, server, EvanERichards{}, options)
+	stats := result.Stats
+	if stats.ImagesDownloaded != 1 || stats.ImagesSkipped != first.Stats.ImagesDownloaded-1 {
+		t.Errorf("Only the truncated image should be downloaded again: %+v", stats)
+	}
+	if len(requested) != 1 {
+		t.Errorf("Images requested again: %v", requested)
+	}
+	if f := result.Files(t); !reflect.DeepEqual(f, files) {
+		t.Errorf("Saved files:\n%v\nexpected:\n%v", f, files)
+	}
+	for movie, names := range files {
+		checkManifest(t, filepath.Join(result.Dir, movie), EvanERichards{}.Name(), names)
+	}
+
+	// Images modified but of the same size are only found by their hash
+	content, err := os.ReadFile(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 0xff
+	if err := os.WriteFile(truncated, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stats := scrapertest.Run(t, server, EvanERichards{}, options).Stats; stats.ImagesDownloaded != 0 {
+		t.Errorf("Images downloaded again without verifying them: %+v", stats)
+	}
+	options.VerifyImages = true
+	if stats := scrapertest.Run(t, server, EvanERichards{}, options).Stats; stats.ImagesDownloaded != 1 {
+		t.Errorf("Only the modified image should be downloaded again: %+v", stats)
+	}
+}
+
+// Different images with the same filename are both saved
+func TestCollisionsOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "collisions"))
+	options := scrapertest.Options(t)
+	first := scrapertest.Run(t, server, BluBeaver{}, options)
+
+	// Images are requested in order, the second one is renamed
+	second, _ := scrapertest.FakeImage("/film3/blu_ray_reviews54/large/heat_1.jpg")
+	expected := map[string][]string{
+		"Heat": {
+			"film3_blu_ray_reviews54_large_heat_1-" + scraper.SHA256(second)[:8] + ".jpg",
+			"film3_blu_ray_reviews54_large_heat_1.jpg",
+		},
+	}
+
+	if stats := first.Stats; stats.ImagesDownloaded != 2 || stats.ImagesCollisions != 1 {
+		t.Errorf("Colliding images not counted: %+v", stats)
+	}
+	files := first.Files(t)
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Saved files:\n%v\nexpected:\n%v", files, expected)
+	}
+	checkManifest(t, filepath.Join(first.Dir, "Heat"), BluBeaver{}.Name(), expected["Heat"])
+
+	// Both images are known to be saved next time
+	result := scrapertest.Run(t, server, BluBeaver{}, options)
+	if stats := result.Stats; stats.ImagesDownloaded != 0 || stats.ImagesSkipped != 2 {
+		t.Errorf("Colliding images downloaded again: %+v", stats)
+	}
+	if files := result.Files(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Saved files:\n%v\nexpected:\n%v", files, expected)
+	}
+}
+
+// Pages and images disallowed by robots.txt are skipped, counted
+// apart from the requests failing. Requests go by the user agent the
+// rules are matched with.
+func TestRobotsOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "blubeaver"))
+	server.RobotsTxt = "User-agent: *\nDisallow: /\n\nUser-agent: moviestills\nDisallow: /film2/\n"
+
+	var mu sync.Mutex
+	var requested []string
+	userAgents := make(map[string]bool)
+	server.OnRequest = func(r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		userAgents[r.UserAgent()] = true
+		mu.Unlock()
+	}
+
+	options := scrapertest.Options(t)
+	options.RespectRobots = true
+	result := scrapertest.Run(t, server, BluBeaver{}, options)
+
+	for _, path := range requested {
+		if strings.HasPrefix(path, "/film2/") {
+			t.Errorf("Disallowed path requested: %s", path)
+		}
+	}
+	if want := map[string]bool{scraper.UserAgent: true}; !reflect.DeepEqual(userAgents, want) {
+		t.Errorf("Requests went by %v, want %s", userAgents, scraper.UserAgent)
+	}
+
+	movie := server.URL + "/film2/DVDReviews38/10000_BC_blu-ray.htm"
+	if stats := result.Stats; stats.RobotsDisallowed != 1 || !reflect.DeepEqual(stats.DisallowedURLs, []string{movie}) || len(stats.FailedURLs) != 1 {
+		t.Errorf("Disallowed movie not counted apart: %+v", stats)
+	}
+	if _, found := result.Files(t)["10,000 BC"]; found {
+		t.Error("Images of a disallowed movie saved")
+	}
+	if _, found := result.Files(t)["10"]; !found {
+		t.Error("Images of an allowed movie not saved")
+	}
+}
+
+// Movies and images are saved following the templates, and can
+// be moved back to the default layout.
+func TestTemplatesOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "film-grab"))
+	expected := scrapertest.Run(t, server, FilmGrab{}, nil).Files(t)
+
+	options := scrapertest.Options(t)
+	options.PathTemplate = "{{.Site}}/{{.Title}} (film)"
+	options.FileNameTemplate = `{{printf "%03d" .Index}}{{.Ext}}`
+	result := scrapertest.Run(t, server, FilmGrab{}, options)
+
+	files := result.Files(t)
+	templated := map[string][]string{
+		"12 Angry Men (film)":   {"001.jpg", "002.jpg", "003.jpg"},
+		"Les Miserables (film)": {"001.jpg", "002.jpg"},
+	}
+	if !reflect.DeepEqual(files, templated) {
+		t.Errorf("Saved files:\n%v\nexpected:\n%v", files, templated)
+	}
+
+	// Images are known to be saved next time
+	again := scrapertest.Run(t, server, FilmGrab{}, options)
+	if again.Stats.ImagesDownloaded != 0 {
+		t.Errorf("Images downloaded again: %+v", again.Stats)
+	}
+
+	layout, err := scraper.NewLayout(scraper.DefaultPathTemplate, scraper.DefaultFileNameTemplate, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scraper.Relayout(options.DataDir, layout, false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+	if files := result.Files(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Files moved back:\n%v\nexpected:\n%v", files, expected)
+	}
+	for movie, names := range expected {
+		checkManifest(t, filepath.Join(result.Dir, movie), FilmGrab{}.Name(), names)
+	}
+}
+
+func TestReportOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "blubeaver"))
+	stats := scrapertest.Run(t, server, BluBeaver{}, nil).Stats
+
+	if stats.BytesDownloaded == 0 || stats.StatusCodes[http.StatusOK] == 0 || stats.StatusCodes[http.StatusNotFound] != 1 {
+		t.Errorf("Responses not counted: %d bytes, %v", stats.BytesDownloaded, stats.StatusCodes)
+	}
+
+	// The large image not found anymore, and the thumbnail
+	failed := make(map[string]int)
+	for _, f := range stats.FailedURLs {
+		if f.Movie == "" || f.Error == "" {
+			t.Errorf("Failure not described: %+v", f)
+		}
+		failed[path.Base(f.URL)] = f.Status
+	}
+	expected := map[string]int{"missing_10_3.jpg": http.StatusNotFound, "10000_bc_1-thumb.jpg": http.StatusOK}
+	if !reflect.DeepEqual(failed, expected) {
+		t.Errorf("Failed URLs = %v, expected %v", failed, expected)
+	}
+
+	if movies := stats.Movies(); len(movies) != 2 || len(stats.EmptyMovies()) != 0 {
+		t.Errorf("Movies = %+v, without images: %+v", movies, stats.EmptyMovies())
+	}
+}
+
+func TestContentNamingOffline(t *testing.T) {
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "film-grab"))
+
+	options := scrapertest.Options(t)
+	options.Naming = scraper.NamingContent
+	result := scrapertest.Run(t, server, FilmGrab{}, options)
+
+	expected := result.Files(t)
+	for movie, names := range expected {
+		for _, name := range names {
+			content, err := os.ReadFile(filepath.Join(result.Dir, movie, name))
+			if err != nil || name != scraper.SHA256(content)+".jpg" {
+				t.Errorf("%s/%s not named after its content (%v)", movie, name, err)
+			}
+		}
+	}
+
+	// Images are known to be saved next time
+	again := scrapertest.Run(t, server, FilmGrab{}, options)
+	if again.Stats.ImagesDownloaded != 0 {
+		t.Errorf("Images downloaded again: %+v", again.Stats)
+	}
+
+	// Hashed filenames are converted to the same names
+	hashed := scrapertest.Options(t)
+	hashed.Hash = true
+	converted := scrapertest.Run(t, server, FilmGrab{}, hashed)
+	if _, err := scraper.Relayout(hashed.DataDir, scraper.LayoutFor(options), false); err != nil {
+		t.Fatalf("Relayout() unexpected error: %v", err)
+	}
+	if files := converted.Files(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Converted files:\n%v\nexpected:\n%v", files, expected)
+	}
+
+	manifest, err := scraper.ReadManifest(filepath.Join(converted.Dir, "12 Angry Men"))
+	if err != nil || len(manifest.Images) == 0 || manifest.Images[0].OriginalName == "" {
+		t.Errorf("Original names not kept: %+v, %v", manifest, err)
+	}
+}
+
+// checkDuplicates makes sure the manifest of a movie folder lists
+// the near-duplicates moved aside, along with the image they duplicate.
+func checkDuplicates(t *testing.T, moviePath string, names []string) {
+	t.Helper()
+
+	manifest, err := scraper.ReadManifest(moviePath)
+	if err != nil {
+		t.Fatalf("Can't read manifest of %s: %v", moviePath, err)
+	}
+
+	if len(manifest.Duplicates) != len(names) {
+		t.Fatalf("Manifest of %s lists %d duplicates, expected %d", moviePath, len(manifest.Duplicates), len(names))
+	}
+
+	for _, name := range names {
+		duplicate, exists := manifest.Duplicate(name)
+		if !exists {
+			t.Errorf("Manifest of %s doesn't list duplicate %s", moviePath, name)
+			continue
+		}
+		if _, exists := manifest.Image(duplicate.DuplicateOf); !exists {
+			t.Errorf("Duplicate %s of %s is a duplicate of an unknown image %q", name, moviePath, duplicate.DuplicateOf)
+		}
+	}
+}
+
+// Websites defined in YAML files are scraped like the others
+func TestDefinitionOffline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "film-grab-yaml.yaml")
+	definition := `
+name: film-grab-yaml
+description: Film Grab, defined with CSS selectors only
+index_url: https://film-grab.com/movies-a-z/
+movies:
+  selector: div#primary a.title[href*=film]
+images:
+  selector: div.bwg_container div.bwg-item a.bwg-a[href*=film]
+  rewrite:
+    - remove_params: true
+`
+	if err := os.WriteFile(path, []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+	def, err := LoadDefinition(path)
+	if err != nil {
+		t.Fatalf("LoadDefinition() unexpected error: %v", err)
+	}
+
+	server := scrapertest.NewServer(t, filepath.Join("testdata", "film-grab"))
+	expected := scrapertest.Run(t, server, FilmGrab{}, nil).Files(t)
+	result := scrapertest.Run(t, server, def.Site(), nil)
+
+	files := result.Files(t)
+	if len(files) == 0 || !reflect.DeepEqual(files, expected) {
+		t.Errorf("Saved files:\n%v\nexpected, as the film-grab scraper:\n%v", files, expected)
+	}
+	for movie, names := range files {
+		checkManifest(t, filepath.Join(result.Dir, movie), def.Name, names)
+	}
+}
@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test ScreenMusings index page
 func TestScreenMusingsIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(ScreenMusingsURL)
 
@@ -20,6 +23,8 @@ func TestScreenMusingsIndexPage(t *testing.T) {
 // Check if "most viewed stills" link can be found on movie page
 // Annihilation
 func TestScreenMusingsMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://screenmusings.org/movie/blu-ray/Annihilation/")
 
@@ -35,6 +40,8 @@ func TestScreenMusingsMoviePage(t *testing.T) {
 
 // Annihilation
 func TestScreenMusingsMovieMostViewedPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://screenmusings.org/movie/blu-ray/Annihilation/most-viewed-stills.htm")
 
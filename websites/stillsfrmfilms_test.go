@@ -1,12 +1,15 @@
 package websites
 
 import (
+	"moviestills/scraper/scrapertest"
 	"moviestills/utils"
 	"testing"
 )
 
 // Test StillsFrmFilms index page
 func TestStillsFrmIndexPage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode(StillsFrmFilmsURL)
 
@@ -19,6 +22,8 @@ func TestStillsFrmIndexPage(t *testing.T) {
 
 // 25th Hour
 func TestStillsFrmNormalMoviePage(t *testing.T) {
+	scrapertest.SkipLive(t)
+
 	// Request the HTML page.
 	doc := utils.GetHTMLCode("https://stillsfrmfilms.wordpress.com/2012/09/17/25th-hour/")
 
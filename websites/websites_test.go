@@ -0,0 +1,33 @@
+package websites
+
+import (
+	"moviestills/scraper"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Every website should register itself with valid metadata
+func TestRegisteredWebsites(t *testing.T) {
+	sites := scraper.Sites()
+	if len(sites) != 9 {
+		t.Fatalf("Number of registered websites is different than 9: %d", len(sites))
+	}
+
+	for _, site := range sites {
+		if site.Description() == "" {
+			t.Errorf("%s: description is empty", site.Name())
+		}
+
+		indexURL, err := url.Parse(site.IndexURL())
+		if err != nil {
+			t.Errorf("%s: index URL %q can't be parsed: %v", site.Name(), site.IndexURL(), err)
+			continue
+		}
+
+		// The index page must be reachable by the scrapers
+		if !slices.Contains(site.AllowedDomains(), indexURL.Hostname()) {
+			t.Errorf("%s: index URL host %q is not an allowed domain", site.Name(), indexURL.Hostname())
+		}
+	}
+}